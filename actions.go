@@ -0,0 +1,74 @@
+package main
+
+import "strings"
+
+// ratingAction classifies the free-text StockItem.Action.
+type ratingAction string
+
+// Rating actions; an empty ratingAction is an action text no rule matched.
+const (
+	actionUpgrade      ratingAction = "upgrade"
+	actionDowngrade    ratingAction = "downgrade"
+	actionInitiate     ratingAction = "initiate"
+	actionReiterate    ratingAction = "reiterate"
+	actionTargetRaise  ratingAction = "target_raise"
+	actionTargetLower  ratingAction = "target_lower"
+	actionDropCoverage ratingAction = "drop_coverage"
+)
+
+// ratingActions lists every action, e.g. to validate filters.
+var ratingActions = []ratingAction{
+	actionUpgrade, actionDowngrade, actionInitiate, actionReiterate,
+	actionTargetRaise, actionTargetLower, actionDropCoverage,
+}
+
+// actionRules are tried in order against the lowercased action text; the
+// first rule with a matching keyword wins. The 0011 migration applies the
+// same rules in SQL, so keep the two in step.
+var actionRules = []struct {
+	Action   ratingAction
+	Keywords []string
+}{
+	{actionDropCoverage, []string{"drop", "terminat", "discontinu", "suspend"}},
+	{actionUpgrade, []string{"upgrad"}},
+	{actionDowngrade, []string{"downgrad"}},
+	{actionInitiate, []string{"initiat", "resum", "assum"}},
+	{actionTargetRaise, []string{"rais", "boost", "lift"}},
+	{actionTargetLower, []string{"lower", "cut", "reduc"}},
+	{actionReiterate, []string{"reiterat", "maintain", "affirm"}},
+}
+
+// classifyAction maps an action text to a ratingAction. Texts that only say
+// a target was set ("target set by") are classified by how the target
+// moved, and stay unclassified when either target is unknown.
+func classifyAction(raw string, targetFrom, targetTo *float64) ratingAction {
+	text := strings.ToLower(raw)
+	for _, rule := range actionRules {
+		for _, kw := range rule.Keywords {
+			if strings.Contains(text, kw) {
+				return rule.Action
+			}
+		}
+	}
+	if !strings.Contains(text, "target") || targetFrom == nil || targetTo == nil {
+		return ""
+	}
+	switch {
+	case *targetTo > *targetFrom:
+		return actionTargetRaise
+	case *targetTo < *targetFrom:
+		return actionTargetLower
+	default:
+		return actionReiterate
+	}
+}
+
+// actionScores stand in for the rating delta in handleRecommend when the
+// ratings do not move or are unmapped: a rising target is a milder signal
+// than an upgrade.
+var actionScores = map[ratingAction]float64{
+	actionUpgrade:     1,
+	actionDowngrade:   -1,
+	actionTargetRaise: 0.5,
+	actionTargetLower: -0.5,
+}
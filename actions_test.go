@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// actionSamples are upstream action texts with the targets they came with.
+var actionSamples = []struct {
+	Action   string
+	From, To *float64
+	Want     ratingAction
+}{
+	{"upgraded by", nil, nil, actionUpgrade},
+	{"Downgraded by", nil, nil, actionDowngrade},
+	{"initiated by", nil, ptr(10.0), actionInitiate},
+	{"coverage resumed by", nil, nil, actionInitiate},
+	{"reiterated by", ptr(5.0), ptr(5.0), actionReiterate},
+	{"target raised by", ptr(5.0), ptr(6.0), actionTargetRaise},
+	{"target lowered by", ptr(6.0), ptr(5.0), actionTargetLower},
+	{"price target cut by", nil, nil, actionTargetLower},
+	{"coverage dropped by", nil, nil, actionDropCoverage},
+	{"target set by", ptr(5.0), ptr(7.0), actionTargetRaise},
+	{"target set by", ptr(7.0), ptr(5.0), actionTargetLower},
+	{"target set by", ptr(5.0), ptr(5.0), actionReiterate},
+	{"target set by", nil, ptr(5.0), ""},
+	{"commented on", nil, nil, ""},
+}
+
+func TestClassifyAction(t *testing.T) {
+	for _, s := range actionSamples {
+		assert.Equal(t, s.Want, classifyAction(s.Action, s.From, s.To), s.Action)
+	}
+}
+
+func TestActionTypeMigration_MatchesClassifyAction(t *testing.T) {
+	db := openTestSQLite(t)
+	migs, err := embeddedMigrations(sqliteDialect)
+	require.NoError(t, err)
+	_, err = migrateDown(db, sqliteDialect, migs, len(migs)-10)
+	require.NoError(t, err)
+
+	for i, s := range actionSamples {
+		_, err := db.Exec(`INSERT INTO stock_info (ticker, company, brokerage, action, rating_from, rating_to, target_from, target_to, time)
+			VALUES ($1, '', 'Brok', $2, '', '', $3, $4, '2025-01-01 00:00:00+00:00')`, string(rune('A'+i)), s.Action, s.From, s.To)
+		require.NoError(t, err)
+	}
+	_, err = migrateUp(db, sqliteDialect, migs, 0)
+	require.NoError(t, err)
+
+	ratings, err := newSQLiteRepository(db).ListRatings(RatingFilter{Sort: "ticker"})
+	require.NoError(t, err)
+	require.Len(t, ratings, len(actionSamples))
+	for i, s := range actionSamples {
+		assert.Equal(t, s.Want, ratings[i].ActionType, s.Action)
+	}
+}
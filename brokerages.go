@@ -0,0 +1,361 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Brokerage is a canonical research firm and the raw names mapped to it.
+type Brokerage struct {
+	ID      int64    `json:"id"`
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+// brokerageSuggestion proposes that the brokerage created for a new raw name
+// is an existing firm; -mode=merge-brokerage applies it.
+type brokerageSuggestion struct {
+	BrokerageID   int64   `json:"brokerage_id"`
+	Name          string  `json:"name"`
+	SuggestedID   int64   `json:"suggested_id"`
+	SuggestedName string  `json:"suggested_name"`
+	Similarity    float64 `json:"similarity"`
+}
+
+// suggestSimilarity is the brokerageSimilarity above which a new name is
+// reported as a likely variant of a known brokerage.
+const suggestSimilarity = 0.88
+
+// brokerageSuffixes are corporate words that don't tell firms apart.
+var brokerageSuffixes = map[string]bool{
+	"inc": true, "incorporated": true, "co": true, "company": true, "corp": true, "corporation": true,
+	"llc": true, "llp": true, "lp": true, "ltd": true, "limited": true, "plc": true,
+	"ag": true, "sa": true, "nv": true, "group": true, "holdings": true, "the": true, "and": true,
+}
+
+// brokerageKey normalizes a raw brokerage name for alias lookup: case,
+// punctuation, spacing and corporate suffixes are ignored, so "J.P. Morgan"
+// and "JP Morgan" share the key "jpmorgan".
+func brokerageKey(raw string) string {
+	words := strings.FieldsFunc(strings.ToLower(raw), func(r rune) bool {
+		return unicode.IsSpace(r) || r == ',' || r == '&' || r == '-' || r == '/'
+	})
+	var b strings.Builder
+	for _, w := range words {
+		w = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, w)
+		if !brokerageSuffixes[w] {
+			b.WriteString(w)
+		}
+	}
+	return b.String()
+}
+
+// brokerageSimilarity is the Jaro-Winkler similarity of two brokerage keys,
+// from 0 (nothing in common) to 1 (equal).
+func brokerageSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+	window := max(len(ra), len(rb))/2 - 1
+	window = max(window, 0)
+	matchedA := make([]bool, len(ra))
+	matchedB := make([]bool, len(rb))
+	matches := 0
+	for i := range ra {
+		for j := max(0, i-window); j < min(len(rb), i+window+1); j++ {
+			if !matchedB[j] && ra[i] == rb[j] {
+				matchedA[i], matchedB[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+	transpositions, j := 0, 0
+	for i := range ra {
+		if !matchedA[i] {
+			continue
+		}
+		for !matchedB[j] {
+			j++
+		}
+		if ra[i] != rb[j] {
+			transpositions++
+		}
+		j++
+	}
+	m := float64(matches)
+	jaro := (m/float64(len(ra)) + m/float64(len(rb)) + (m-float64(transpositions/2))/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(ra), len(rb)) && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// brokerageResolver maps raw brokerage names to brokerage ids, creating a
+// brokerage for every name no alias matches. It caches brokerage_aliases for
+// a run; a nil resolver resolves nothing.
+type brokerageResolver struct {
+	db *sql.DB
+
+	mu    sync.Mutex
+	ids   map[string]int64 // brokerageKey -> id
+	names map[int64]string
+}
+
+// loadBrokerageResolver reads the known aliases.
+func loadBrokerageResolver(db *sql.DB) (*brokerageResolver, error) {
+	rows, err := db.Query("SELECT a.key, b.id, b.name FROM brokerage_aliases a JOIN brokerages b ON b.id = a.brokerage_id")
+	if err != nil {
+		return nil, fmt.Errorf("loading brokerage aliases: %w", err)
+	}
+	defer rows.Close()
+	res := &brokerageResolver{db: db, ids: map[string]int64{}, names: map[int64]string{}}
+	for rows.Next() {
+		var key, name string
+		var id int64
+		if err := rows.Scan(&key, &id, &name); err != nil {
+			return nil, fmt.Errorf("scanning brokerage alias: %w", err)
+		}
+		res.ids[key], res.names[id] = id, name
+	}
+	return res, rows.Err()
+}
+
+// id returns the brokerage of raw if it has been resolved.
+func (res *brokerageResolver) id(raw string) *int64 {
+	if res == nil {
+		return nil
+	}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if id, ok := res.ids[brokerageKey(raw)]; ok {
+		return &id
+	}
+	return nil
+}
+
+// Resolve makes sure every name in raws has a brokerage. Unknown names get a
+// brokerage of their own, outside any page transaction so ids stay valid
+// when a page is retried, plus a suggestion when they look like a known one.
+func (res *brokerageResolver) Resolve(raws []string) error {
+	if res == nil {
+		return nil
+	}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	for _, raw := range raws {
+		key := brokerageKey(raw)
+		if key == "" {
+			continue
+		}
+		if _, ok := res.ids[key]; ok {
+			continue
+		}
+		if err := res.create(strings.TrimSpace(raw), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// create stores a new brokerage for raw. Called with mu held.
+func (res *brokerageResolver) create(raw, key string) error {
+	var best string
+	var bestID int64
+	bestSim := 0.0
+	for k, id := range res.ids {
+		if sim := brokerageSimilarity(key, k); sim > bestSim {
+			best, bestID, bestSim = k, id, sim
+		}
+	}
+
+	tx, err := res.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin brokerage: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	if err := tx.QueryRow("INSERT INTO brokerages (name) VALUES ($1) RETURNING id", raw).Scan(&id); err != nil {
+		return fmt.Errorf("creating brokerage %q: %w", raw, err)
+	}
+	inserted, err := tx.Exec(
+		"INSERT INTO brokerage_aliases (key, alias, brokerage_id) VALUES ($1, $2, $3) ON CONFLICT (key) DO NOTHING",
+		key, raw, id,
+	)
+	if err != nil {
+		return fmt.Errorf("creating brokerage alias %q: %w", raw, err)
+	}
+	if n, _ := inserted.RowsAffected(); n == 0 {
+		// Another run created it first
+		tx.Rollback()
+		if err := res.db.QueryRow("SELECT brokerage_id FROM brokerage_aliases WHERE key=$1", key).Scan(&id); err != nil {
+			return fmt.Errorf("loading brokerage alias %q: %w", raw, err)
+		}
+		res.ids[key] = id
+		return nil
+	}
+	if bestSim >= suggestSimilarity {
+		log.Printf("New brokerage %q looks like %q (%.2f); merge with -mode=merge-brokerage -from=%d -into=%d",
+			raw, res.names[bestID], bestSim, id, bestID)
+		if _, err := tx.Exec(
+			"INSERT INTO brokerage_suggestions (brokerage_id, suggested_id, similarity) VALUES ($1, $2, $3)",
+			id, bestID, bestSim,
+		); err != nil {
+			return fmt.Errorf("suggesting brokerage %q: %w", best, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit brokerage %q: %w", raw, err)
+	}
+	res.ids[key], res.names[id] = id, raw
+	return nil
+}
+
+// backfillBrokerageIDs resolves the brokerages of stored ratings that have
+// no brokerage_id yet, e.g. those stored before brokerages existed.
+func backfillBrokerageIDs(db *sql.DB, res *brokerageResolver) (int64, error) {
+	rows, err := db.Query("SELECT DISTINCT brokerage FROM stock_info WHERE brokerage_id IS NULL")
+	if err != nil {
+		return 0, fmt.Errorf("listing unresolved brokerages: %w", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan brokerage: %w", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("listing unresolved brokerages: %w", err)
+	}
+	if err := res.Resolve(names); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, name := range names {
+		id := res.id(name)
+		if id == nil {
+			continue
+		}
+		result, err := db.Exec("UPDATE stock_info SET brokerage_id=$1 WHERE brokerage=$2 AND brokerage_id IS NULL", *id, name)
+		if err != nil {
+			return total, fmt.Errorf("backfilling brokerage %q: %w", name, err)
+		}
+		n, _ := result.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
+
+// mergeBrokerage folds brokerage from into into: its aliases and ratings move
+// over and from is deleted.
+func mergeBrokerage(db *sql.DB, from, into int64) error {
+	if from == into {
+		return fmt.Errorf("cannot merge brokerage %d into itself", from)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin merge: %w", err)
+	}
+	defer tx.Rollback()
+
+	var name string
+	if err := tx.QueryRow("SELECT name FROM brokerages WHERE id=$1", into).Scan(&name); err == sql.ErrNoRows {
+		return fmt.Errorf("brokerage %d: %w", into, errNotFound)
+	} else if err != nil {
+		return fmt.Errorf("loading brokerage %d: %w", into, err)
+	}
+	for _, stmt := range []string{
+		"UPDATE brokerage_aliases SET brokerage_id=$2 WHERE brokerage_id=$1",
+		"UPDATE stock_info SET brokerage_id=$2 WHERE brokerage_id=$1",
+		"DELETE FROM brokerage_suggestions WHERE brokerage_id=$1",
+		// Suggestions of from that would turn into one of into already
+		// made, or into suggesting itself, go before the rest move over
+		"DELETE FROM brokerage_suggestions WHERE suggested_id=$1 AND (brokerage_id=$2 OR brokerage_id IN (SELECT brokerage_id FROM brokerage_suggestions WHERE suggested_id=$2))",
+		"UPDATE brokerage_suggestions SET suggested_id=$2 WHERE suggested_id=$1",
+	} {
+		if _, err := tx.Exec(stmt, from, into); err != nil {
+			return fmt.Errorf("merging brokerage %d into %d: %w", from, into, err)
+		}
+	}
+	result, err := tx.Exec("DELETE FROM brokerages WHERE id=$1", from)
+	if err != nil {
+		return fmt.Errorf("deleting brokerage %d: %w", from, err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("brokerage %d: %w", from, errNotFound)
+	}
+	return tx.Commit()
+}
+
+// queryBrokerages lists brokerages with their aliases, by name.
+func queryBrokerages(db *sql.DB) ([]Brokerage, error) {
+	rows, err := db.Query(`
+		SELECT b.id, b.name, a.alias
+		FROM brokerages b LEFT JOIN brokerage_aliases a ON a.brokerage_id = b.id
+		ORDER BY b.name, b.id, a.alias`)
+	if err != nil {
+		return nil, fmt.Errorf("listing brokerages: %w", err)
+	}
+	defer rows.Close()
+	out := []Brokerage{}
+	for rows.Next() {
+		var id int64
+		var name string
+		var alias sql.NullString
+		if err := rows.Scan(&id, &name, &alias); err != nil {
+			return nil, fmt.Errorf("scanning brokerage: %w", err)
+		}
+		if len(out) == 0 || out[len(out)-1].ID != id {
+			out = append(out, Brokerage{ID: id, Name: name, Aliases: []string{}})
+		}
+		if alias.Valid {
+			last := &out[len(out)-1]
+			last.Aliases = append(last.Aliases, alias.String)
+		}
+	}
+	return out, rows.Err()
+}
+
+// queryBrokerageSuggestions lists pending suggestions, most similar first.
+func queryBrokerageSuggestions(db *sql.DB) ([]brokerageSuggestion, error) {
+	rows, err := db.Query(`
+		SELECT s.brokerage_id, b.name, s.suggested_id, t.name, s.similarity
+		FROM brokerage_suggestions s
+		JOIN brokerages b ON b.id = s.brokerage_id
+		JOIN brokerages t ON t.id = s.suggested_id
+		ORDER BY s.similarity DESC, b.name`)
+	if err != nil {
+		return nil, fmt.Errorf("listing brokerage suggestions: %w", err)
+	}
+	defer rows.Close()
+	out := []brokerageSuggestion{}
+	for rows.Next() {
+		var s brokerageSuggestion
+		if err := rows.Scan(&s.BrokerageID, &s.Name, &s.SuggestedID, &s.SuggestedName, &s.Similarity); err != nil {
+			return nil, fmt.Errorf("scanning brokerage suggestion: %w", err)
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrokerageKey(t *testing.T) {
+	for raw, key := range map[string]string{
+		"J.P. Morgan":                "jpmorgan",
+		"JPMorgan Chase & Co.":       "jpmorganchase",
+		"Goldman Sachs Group, Inc.":  "goldmansachs",
+		"  goldman   sachs ":         "goldmansachs",
+		"Royal Bank of Canada":       "royalbankofcanada",
+		"B. Riley Securities":        "brileysecurities",
+		"Wells-Fargo":                "wellsfargo",
+		"The Benchmark Company, LLC": "benchmark",
+		"":                           "",
+	} {
+		assert.Equal(t, key, brokerageKey(raw), raw)
+	}
+}
+
+func TestBrokerageSimilarity(t *testing.T) {
+	assert.Equal(t, 1.0, brokerageSimilarity("barclays", "barclays"))
+	assert.GreaterOrEqual(t, brokerageSimilarity("goldmensachs", "goldmansachs"), suggestSimilarity)
+	assert.GreaterOrEqual(t, brokerageSimilarity("morganstanly", "morganstanley"), suggestSimilarity)
+	assert.Less(t, brokerageSimilarity("jpmorgan", "morganstanley"), suggestSimilarity)
+	assert.Less(t, brokerageSimilarity("ubs", "barclays"), suggestSimilarity)
+	assert.Equal(t, 0.0, brokerageSimilarity("", "ubs"))
+}
+
+func TestBrokerageResolver_SQLite(t *testing.T) {
+	db := openTestSQLite(t)
+	repo := newSQLiteRepository(db)
+
+	// Seeded keys follow brokerageKey, or the seeds would never match
+	rows, err := db.Query("SELECT key, alias FROM brokerage_aliases")
+	require.NoError(t, err)
+	for rows.Next() {
+		var key, alias string
+		require.NoError(t, rows.Scan(&key, &alias))
+		assert.Equal(t, brokerageKey(alias), key, alias)
+	}
+	require.NoError(t, rows.Close())
+
+	// A rating stored before its brokerage was known
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = repo.InsertRating(Rating{Ticker: "OLD", Brokerage: "JP Morgan", Time: at})
+	require.NoError(t, err)
+
+	res, err := loadBrokerageResolver(db)
+	require.NoError(t, err)
+	n, err := backfillBrokerageIDs(db, res)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	jpm := res.id("J.P. Morgan")
+	require.NotNil(t, jpm)
+
+	require.NoError(t, res.Resolve([]string{"Goldman Sachs & Co.", "Goldmen Sachs", "Acme Research", ""}))
+	goldman, typo := res.id("Goldman Sachs"), res.id("Goldmen Sachs")
+	require.NotNil(t, goldman)
+	require.NotNil(t, typo)
+	assert.NotEqual(t, *goldman, *typo, "fuzzy matches are only suggested")
+	assert.NotNil(t, res.id("acme research"))
+	assert.Nil(t, res.id("Unseen Partners"))
+
+	suggestions, err := repo.BrokerageSuggestions()
+	require.NoError(t, err)
+	require.Len(t, suggestions, 1)
+	assert.Equal(t, "Goldmen Sachs", suggestions[0].Name)
+	assert.Equal(t, *goldman, suggestions[0].SuggestedID)
+
+	lookups := ingestLookups{Current: testPrices.CurrentPrice, Brokerages: res}
+	for _, item := range []StockItem{
+		{Ticker: "GS", Brokerage: "Goldman Sachs", Time: at.Format(time.RFC3339)},
+		{Ticker: "GS", Brokerage: "Goldmen Sachs", Time: at.Add(time.Hour).Format(time.RFC3339)},
+	} {
+		r, err := parseStockItem(&item, lookups)
+		require.NoError(t, err)
+		_, err = repo.InsertRating(r)
+		require.NoError(t, err)
+	}
+
+	// Merging folds the typo's aliases and ratings into Goldman
+	require.NoError(t, mergeBrokerage(db, *typo, *goldman))
+	ratings, err := repo.ListRatings(RatingFilter{BrokerageIDs: []int64{*goldman}, Sort: "time"})
+	require.NoError(t, err)
+	require.Len(t, ratings, 2)
+	assert.Equal(t, "Goldmen Sachs", ratings[1].Brokerage, "the raw name is kept")
+
+	brokerages, err := repo.Brokerages()
+	require.NoError(t, err)
+	for _, b := range brokerages {
+		if b.ID == *goldman {
+			assert.Contains(t, b.Aliases, "Goldmen Sachs")
+		}
+		assert.NotEqual(t, *typo, b.ID)
+	}
+	suggestions, err = repo.BrokerageSuggestions()
+	require.NoError(t, err)
+	assert.Empty(t, suggestions)
+
+	assert.ErrorIs(t, mergeBrokerage(db, *typo, *goldman), errNotFound)
+}
+
+func TestMergeBrokerage_RetargetsSuggestions(t *testing.T) {
+	db := openTestSQLite(t)
+	ids := map[string]int64{}
+	for _, name := range []string{"Alpha", "Beta", "Gamma", "Delta"} {
+		var id int64
+		require.NoError(t, db.QueryRow("INSERT INTO brokerages (name) VALUES ($1) RETURNING id", name).Scan(&id))
+		ids[name] = id
+	}
+	for _, pair := range [][2]string{{"Gamma", "Alpha"}, {"Gamma", "Beta"}, {"Beta", "Alpha"}, {"Delta", "Alpha"}, {"Alpha", "Gamma"}} {
+		_, err := db.Exec("INSERT INTO brokerage_suggestions (brokerage_id, suggested_id, similarity) VALUES ($1, $2, 0.9)", ids[pair[0]], ids[pair[1]])
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, mergeBrokerage(db, ids["Alpha"], ids["Beta"]))
+	rows, err := db.Query("SELECT brokerage_id, suggested_id FROM brokerage_suggestions ORDER BY brokerage_id")
+	require.NoError(t, err)
+	defer rows.Close()
+	var got [][2]int64
+	for rows.Next() {
+		var pair [2]int64
+		require.NoError(t, rows.Scan(&pair[0], &pair[1]))
+		got = append(got, pair)
+	}
+	assert.Equal(t, [][2]int64{{ids["Gamma"], ids["Beta"]}, {ids["Delta"], ids["Beta"]}}, got,
+		"no duplicate for Gamma, no self-suggestion for Beta")
+}
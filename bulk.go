@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+)
+
+// stagingStmt creates the per-connection table COPY batches are loaded into
+// before being merged into stock_info. Rows are cleared after every merge.
+const stagingStmt = `
+	CREATE TEMP TABLE IF NOT EXISTS stock_info_staging (
+		seq              INT NOT NULL,
+		ticker           TEXT NOT NULL,
+		company          TEXT NOT NULL,
+		brokerage        TEXT NOT NULL,
+		action           TEXT NOT NULL,
+		rating_from      TEXT NOT NULL,
+		rating_to        TEXT NOT NULL,
+		target_from      NUMERIC,
+		target_to        NUMERIC,
+		time             TIMESTAMPTZ NOT NULL,
+		current_price    NUMERIC,
+		price_at_rating  NUMERIC,
+		price_updated_at TIMESTAMPTZ,
+		target_currency  TEXT NOT NULL,
+		rating_from_canonical TEXT,
+		rating_to_canonical   TEXT,
+		brokerage_id          BIGINT,
+		action_type           TEXT
+	) ON COMMIT DELETE ROWS
+	`
+
+// stagingColumns are copied in upsertArgs order, after the batch position.
+var stagingColumns = []string{
+	"seq", "ticker", "company", "brokerage", "action", "rating_from", "rating_to",
+	"target_from", "target_to", "time", "current_price", "price_at_rating", "price_updated_at",
+	"target_currency", "rating_from_canonical", "rating_to_canonical", "brokerage_id", "action_type",
+}
+
+// mergeStagingStmt upserts the staged batch. A rating staged twice would make
+// ON CONFLICT touch the same row twice, so only its last copy is kept.
+const mergeStagingStmt = `
+		INSERT INTO stock_info (
+		ticker, company, brokerage, action,
+		rating_from, rating_to, target_from, target_to,
+		time, current_price, price_at_rating, price_updated_at, target_currency,
+		rating_from_canonical, rating_to_canonical, brokerage_id, action_type
+		)
+		SELECT DISTINCT ON (ticker, brokerage, time, rating_to, target_to)
+			ticker, company, brokerage, action,
+			rating_from, rating_to, target_from, target_to,
+			time, current_price, price_at_rating, price_updated_at, target_currency,
+			rating_from_canonical, rating_to_canonical, brokerage_id, action_type
+		FROM stock_info_staging
+		ORDER BY ticker, brokerage, time, rating_to, target_to, seq DESC` + upsertClause
+
+// copyRatings loads batch into the staging table with COPY and merges it
+// into stock_info within tx. Ratings the merge leaves untouched, including
+// duplicates within the batch, count as unchanged. Database errors wrap
+// errExecInsert like row-by-row upserts.
+func copyRatings(tx *sql.Tx, batch []Rating) (fetchSummary, error) {
+	var counts fetchSummary
+	fail := func(step string, err error) (fetchSummary, error) {
+		return counts, fmt.Errorf("%w: %s: %w", errExecInsert, step, err)
+	}
+
+	if _, err := tx.Exec(stagingStmt); err != nil {
+		return fail("create staging", err)
+	}
+	stmt, err := tx.Prepare(pq.CopyIn("stock_info_staging", stagingColumns...))
+	if err != nil {
+		return fail("copy", err)
+	}
+	for i, r := range batch {
+		if _, err := stmt.Exec(append([]any{i}, utcArgs(r.upsertArgs())...)...); err != nil {
+			stmt.Close()
+			return fail("copy", err)
+		}
+	}
+	// An argument-less Exec flushes the COPY buffer
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return fail("copy", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return fail("copy", err)
+	}
+
+	rows, err := tx.Query(mergeStagingStmt)
+	if err != nil {
+		return fail("merge", err)
+	}
+	merged := 0
+	for rows.Next() {
+		var inserted bool
+		if err := rows.Scan(&inserted); err != nil {
+			rows.Close()
+			return fail("merge", err)
+		}
+		if inserted {
+			counts.Inserted++
+		} else {
+			counts.Updated++
+		}
+		merged++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fail("merge", err)
+	}
+	counts.Unchanged = len(batch) - merged
+
+	// Several batches can share the page transaction
+	if _, err := tx.Exec("TRUNCATE stock_info_staging"); err != nil {
+		return fail("truncate staging", err)
+	}
+	return counts, nil
+}
+
+// copyItems parses a page's items and writes them in batches of
+// f.batchSize through copyRatings, returning the items stored. Items that
+// fail to parse are rejected.
+// A row the database refuses fails its whole COPY, so that batch is rolled
+// back to its savepoint and redone through upsertItems, which rejects only
+// the offending rows.
+func (f *pageFetcher) copyItems(tx *sql.Tx, runID int64, items []StockItem) (fetchSummary, []StockItem, error) {
+	var counts fetchSummary
+	var stored []StockItem
+	batch := make([]Rating, 0, f.batchSize)
+	batchItems := make([]StockItem, 0, f.batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if _, err := tx.Exec("SAVEPOINT batch"); err != nil {
+			return fmt.Errorf("%w: savepoint: %w", errExecInsert, err)
+		}
+		c, err := copyRatings(tx, batch)
+		copied := batchItems
+		if isItemError(err) {
+			log.Printf("warning: COPY batch refused (%v); retrying row by row", err)
+			if _, rerr := tx.Exec("ROLLBACK TO SAVEPOINT batch"); rerr != nil {
+				return fmt.Errorf("%w: rollback to savepoint: %w", errExecInsert, rerr)
+			}
+			c, copied, err = f.upsertItems(tx, runID, batchItems)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("RELEASE SAVEPOINT batch"); err != nil {
+			return fmt.Errorf("%w: release savepoint: %w", errExecInsert, err)
+		}
+		counts.add(c)
+		stored = append(stored, copied...)
+		batch, batchItems = batch[:0], batchItems[:0]
+		return nil
+	}
+
+	lookups := f.lookups()
+	for _, item := range items {
+		r, err := parseStockItem(&item, lookups)
+		if err != nil {
+			if err := rejectItem(tx, runID, &item, err); err != nil {
+				return counts, nil, err
+			}
+			counts.Failed++
+			continue
+		}
+		batch = append(batch, r)
+		batchItems = append(batchItems, item)
+		if len(batch) == f.batchSize {
+			if err := flush(); err != nil {
+				return counts, nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return counts, nil, err
+	}
+	return counts, stored, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// expectCopyBatch expects one COPY + merge cycle of n rows, the merge
+// returning the given inserted flags.
+func expectCopyBatch(mock sqlmock.Sqlmock, n int, inserted ...bool) {
+	mock.ExpectExec("CREATE TEMP TABLE IF NOT EXISTS stock_info_staging").WillReturnResult(sqlmock.NewResult(0, 0))
+	copyStmt := mock.ExpectPrepare(`COPY "stock_info_staging"`)
+	for i := 0; i < n; i++ {
+		copyStmt.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	copyStmt.ExpectExec().WithoutArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+	merged := sqlmock.NewRows([]string{"inserted"})
+	for _, ins := range inserted {
+		merged.AddRow(ins)
+	}
+	mock.ExpectQuery("INSERT INTO stock_info .* FROM stock_info_staging").WillReturnRows(merged)
+	mock.ExpectExec("TRUNCATE stock_info_staging").WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestCopyRatings_CountsMergeOutcomes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	expectCopyBatch(mock, 3, true, false)
+	mock.ExpectCommit()
+
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	at := time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC)
+	batch := []Rating{
+		{Ticker: "A", Time: at, TargetTo: ptr(1.0)},
+		{Ticker: "B", Time: at},
+		{Ticker: "C", Time: at},
+	}
+	counts, err := copyRatings(tx, batch)
+	assert.NoError(t, err)
+	assert.Equal(t, fetchSummary{Inserted: 1, Updated: 1, Unchanged: 1}, counts)
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCopyRatings_MergeErrorAbortsPage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMP TABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+	copyStmt := mock.ExpectPrepare("COPY")
+	copyStmt.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	copyStmt.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FROM stock_info_staging").WillReturnError(fmt.Errorf("deadlock detected"))
+
+	tx, _ := db.Begin()
+	_, err = copyRatings(tx, []Rating{{Ticker: "A", Time: time.Now()}})
+	assert.ErrorIs(t, err, errExecInsert)
+	assert.Contains(t, err.Error(), "deadlock detected")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFetchAndStoreAllPages_CopyBatches(t *testing.T) {
+	restore := stubUpstream(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items":[
+			{"ticker":"TCK","company":"C","brokerage":"B1","action":"A","rating_from":"Hold","rating_to":"Buy","target_from":"$1","target_to":"$2","time":"2025-01-13T00:30:05Z"},
+			{"ticker":"TCK","company":"C","brokerage":"B2","action":"A","rating_from":"Hold","rating_to":"Buy","target_from":"bad","target_to":"$2","time":"2025-01-13T00:30:05Z"},
+			{"ticker":"TCK","company":"C","brokerage":"B3","action":"A","rating_from":"Hold","rating_to":"Buy","target_from":"$1","target_to":"$2","time":"2025-01-13T00:30:05Z"},
+			{"ticker":"TCK","company":"C","brokerage":"B4","action":"A","rating_from":"Hold","rating_to":"Buy","target_from":"$1","target_to":"$2","time":"2025-01-13T00:30:05Z"}
+		],"next_page":""}`)
+	})
+	defer restore()
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO stock_info")
+	mock.ExpectQuery("INSERT INTO fetch_runs").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectBegin()
+	// The unparseable item is rejected as soon as it is read
+	mock.ExpectExec("INSERT INTO rejected_items").WithArgs(1, sqlmock.AnyArg(), "target_from", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Three parseable items in batches of two
+	for _, inserted := range [][]bool{{true, true}, {true}} {
+		mock.ExpectExec("SAVEPOINT batch").WillReturnResult(sqlmock.NewResult(0, 0))
+		expectCopyBatch(mock, len(inserted), inserted...)
+		mock.ExpectExec("RELEASE SAVEPOINT batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	// One securities row per distinct ticker
+	mock.ExpectExec("INSERT INTO securities").WithArgs("TCK", "C").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO fetch_checkpoints").WithArgs(1, 1, "").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE fetch_runs SET inserted").WithArgs(1, 3, 0, 0, 1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("UPDATE fetch_runs SET status").WithArgs(1, runCompleted).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO fetch_watermarks").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	prep, err := db.Prepare(insertStmt)
+	assert.NoError(t, err)
+
+	summary, err := fetchAndStoreAllPages(db, prep, fetchOptions{BatchSize: 2, Prices: testPrices})
+	assert.NoError(t, err)
+	assert.Equal(t, fetchSummary{Inserted: 3, Failed: 1}, summary)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// httpTransport, when set, carries every request to the ratings API and the
+// price providers: a cassette recorder or player. Clients are built with it,
+// so set it before creating them.
+var httpTransport http.RoundTripper
+
+// cassetteInteraction is one recorded HTTP exchange: a response or the
+// transport error that replaced it. Request headers are not kept, so the
+// bearer token never reaches a cassette.
+type cassetteInteraction struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// cassetteKey matches a request to its recordings by method, path and query,
+// so a cassette replays against any API_ENDPOINT host.
+func cassetteKey(req *http.Request) string {
+	return req.Method + " " + req.URL.RequestURI()
+}
+
+// cassetteRecorder forwards requests and appends each exchange to a JSONL
+// cassette as it completes, so a run that fails midway keeps what led up to
+// the failure.
+type cassetteRecorder struct {
+	next http.RoundTripper
+	mu   sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+}
+
+func recordCassette(path string, next http.RoundTripper) (*cassetteRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating cassette: %w", err)
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false) // keep URLs and bodies readable
+	return &cassetteRecorder{next: next, f: f, enc: enc}, nil
+}
+
+func (c *cassetteRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	in := cassetteInteraction{Method: req.Method, URL: req.URL.String()}
+	resp, err := c.next.RoundTrip(req)
+	if err == nil {
+		// Buffer the body so it can be both recorded and returned; a
+		// timeout while reading it is recorded like a transport error
+		var body []byte
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		in.Status, in.Header, in.Body = resp.StatusCode, resp.Header.Clone(), string(body)
+		in.Header.Del("Set-Cookie")
+	}
+	if err != nil {
+		in = cassetteInteraction{Method: in.Method, URL: in.URL, Error: err.Error()}
+		resp = nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if werr := c.enc.Encode(in); werr != nil {
+		log.Printf("warning: recording %s: %v", cassetteKey(req), werr)
+	}
+	return resp, err
+}
+
+// Close closes the cassette file.
+func (c *cassetteRecorder) Close() error { return c.f.Close() }
+
+// cassettePlayer answers requests from a cassette without network. Requests
+// with the same key get their recordings in the order they were made, so
+// retries replay the failures that caused them.
+type cassettePlayer struct {
+	mu      sync.Mutex
+	pending map[string][]cassetteInteraction
+}
+
+// loadCassette reads a JSONL cassette written by cassetteRecorder.
+func loadCassette(r io.Reader) (*cassettePlayer, error) {
+	p := &cassettePlayer{pending: map[string][]cassetteInteraction{}}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var in cassetteInteraction
+		if err := json.Unmarshal(sc.Bytes(), &in); err != nil {
+			return nil, fmt.Errorf("cassette line %d: %w", line, err)
+		}
+		req, err := http.NewRequest(in.Method, in.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cassette line %d: %w", line, err)
+		}
+		key := cassetteKey(req)
+		p.pending[key] = append(p.pending[key], in)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading cassette: %w", err)
+	}
+	return p, nil
+}
+
+func openCassette(path string) (*cassettePlayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening cassette: %w", err)
+	}
+	defer f.Close()
+	return loadCassette(f)
+}
+
+func (p *cassettePlayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := cassetteKey(req)
+	p.mu.Lock()
+	queue := p.pending[key]
+	if len(queue) == 0 {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("cassette has no recording left for %s", key)
+	}
+	in := queue[0]
+	p.pending[key] = queue[1:]
+	p.mu.Unlock()
+
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if in.Error != "" {
+		return nil, errors.New(in.Error)
+	}
+	header := in.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+		StatusCode:    in.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(in.Body)),
+		ContentLength: int64(len(in.Body)),
+		Request:       req,
+	}, nil
+}
+
+// Unplayed counts the recordings not replayed yet, by request key.
+func (p *cassettePlayer) Unplayed() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	left := map[string]int{}
+	for key, queue := range p.pending {
+		if len(queue) > 0 {
+			left[key] = len(queue)
+		}
+	}
+	return left
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useCassette routes the clients built during the test through the cassette
+// at path.
+func useCassette(t *testing.T, path string) *cassettePlayer {
+	t.Helper()
+	player, err := openCassette(path)
+	require.NoError(t, err)
+	httpTransport = player
+	t.Cleanup(func() { httpTransport = nil })
+	return player
+}
+
+func TestCassette_RecordThenReplay(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Set-Cookie", "session=secret")
+		fmt.Fprintf(w, `{"call":%d}`, calls)
+	}))
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	path := filepath.Join(t.TempDir(), "exchanges.jsonl")
+	rec, err := recordCassette(path, http.DefaultTransport)
+	require.NoError(t, err)
+	client := &http.Client{Transport: rec}
+	get := func(c *http.Client, url string) (string, error) {
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		resp, err := c.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Sprintf("%d %s %s", resp.StatusCode, resp.Header.Get("Set-Cookie"), body), nil
+	}
+	var live []string
+	for range 2 {
+		got, err := get(client, ts.URL+"/list?next_page=a")
+		require.NoError(t, err)
+		live = append(live, got)
+	}
+	_, err = get(client, down.URL+"/list")
+	require.Error(t, err)
+	require.NoError(t, rec.Close())
+	ts.Close()
+	assert.Equal(t, []string{"429  ", `200 session=secret {"call":2}`}, live)
+
+	player, err := openCassette(path)
+	require.NoError(t, err)
+	client = &http.Client{Transport: player}
+	for i, want := range []string{"429  ", `200  {"call":2}`} {
+		// The host is not part of the match
+		got, err := get(client, "http://replay.test/list?next_page=a")
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "call %d, without cookies", i+1)
+	}
+	_, err = get(client, "http://replay.test/list")
+	assert.ErrorContains(t, err, "connection refused", "transport errors replay too")
+	_, err = get(client, "http://replay.test/list")
+	assert.ErrorContains(t, err, "no recording left for GET /list")
+	assert.Empty(t, player.Unplayed())
+}
+
+func TestFetchAndStoreAllPages_ReplaysCassette(t *testing.T) {
+	player := useCassette(t, "testdata/fetch.cassette.jsonl")
+	oldEndpoint := APIEndpoint
+	APIEndpoint = "https://ratings.example.com/swechallenge/list"
+	defer func() { APIEndpoint = oldEndpoint }()
+
+	db := openTestSQLite(t)
+	prep, err := db.Prepare(sqliteDialect.InsertStmt)
+	require.NoError(t, err)
+	defer prep.Close()
+
+	summary, err := fetchAndStoreAllPages(db, prep, fetchOptions{
+		Prices:       newYahooProvider(yahooBaseURL),
+		PriceWorkers: 1,
+		Upstream:     upstreamConfig{MaxRetries: 1},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, fetchSummary{Inserted: 3}, summary)
+	assert.Empty(t, player.Unplayed(), "the run made the recorded requests, 429 retry included")
+
+	repo := newSQLiteRepository(db)
+	akba, err := repo.LatestForTicker("AKBA")
+	require.NoError(t, err)
+	assert.Equal(t, 2.1, *akba.CurrentPrice)
+	assert.Equal(t, 1.95, *akba.PriceAtRating)
+	ceco, err := repo.LatestForTicker("CECO")
+	require.NoError(t, err)
+	assert.Nil(t, ceco.CurrentPrice, "Yahoo had no quote")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// dialect holds what differs between the supported databases.
+type dialect struct {
+	Driver     string // database/sql driver name
+	Migrations string // directory of migrationFiles holding its schema
+	// LockStmt and UnlockStmt serialize migrators; empty when the database
+	// needs no lock (SQLite already allows a single writer).
+	LockStmt   string
+	UnlockStmt string
+	// InsertStmt is the rating upsert, with the parameters of upsertArgs.
+	InsertStmt string
+	// CopyIn enables the COPY bulk ingest path.
+	CopyIn bool
+}
+
+var postgresDialect = dialect{
+	Driver:     "postgres",
+	Migrations: "migrations/postgres",
+	LockStmt:   "SELECT pg_advisory_lock($1)",
+	UnlockStmt: "SELECT pg_advisory_unlock($1)",
+	InsertStmt: insertStmt,
+	CopyIn:     true,
+}
+
+var sqliteDialect = dialect{
+	Driver:     "sqlite",
+	Migrations: "migrations/sqlite",
+	InsertStmt: sqliteInsertStmt,
+}
+
+// sqlitePragmas are appended to every SQLite DSN: wait on locks instead of
+// failing, enforce foreign keys, and write times in a sortable format.
+const sqlitePragmas = "_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_time_format=sqlite"
+
+// openDatabase picks the dialect from the connection string scheme:
+// "sqlite://path/to/file.db" (or "sqlite:file.db", "sqlite::memory:") opens
+// SQLite, anything else is handed to the Postgres driver.
+func openDatabase(conn string) (*sql.DB, dialect, error) {
+	path, ok := strings.CutPrefix(conn, "sqlite:")
+	if !ok {
+		db, err := sql.Open(postgresDialect.Driver, conn)
+		return db, postgresDialect, err
+	}
+
+	path = strings.TrimPrefix(path, "//")
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db, err := sql.Open(sqliteDialect.Driver, path+sep+sqlitePragmas)
+	if err != nil {
+		return nil, sqliteDialect, err
+	}
+	// One connection avoids SQLITE_BUSY between our own writers and keeps
+	// ":memory:" databases alive across statements.
+	db.SetMaxOpenConns(1)
+	return db, sqliteDialect, nil
+}
+
+// repository returns the StockRepository for db.
+func (d dialect) repository(db *sql.DB) StockRepository {
+	if d.Driver == sqliteDialect.Driver {
+		return newSQLiteRepository(db)
+	}
+	return newPostgresRepository(db)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// exportRowGroupSize bounds the rows a Parquet export buffers before
+// flushing a row group.
+const exportRowGroupSize = 10000
+
+// exportFormats are the export formats with their content types.
+var exportFormats = map[string]string{
+	"csv":     "text/csv",
+	"jsonl":   "application/x-ndjson",
+	"parquet": "application/vnd.apache.parquet",
+}
+
+// exportFormat picks the writer for path: format when set, else the file
+// extension, else CSV.
+func exportFormat(path, format string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".jsonl", ".ndjson", ".json":
+			format = "jsonl"
+		case ".parquet":
+			format = "parquet"
+		default:
+			format = "csv"
+		}
+	}
+	format = strings.ToLower(format)
+	if _, ok := exportFormats[format]; !ok {
+		return "", fmt.Errorf("unknown export format %q; use csv, jsonl or parquet", format)
+	}
+	return format, nil
+}
+
+// exportRow is the flat export record of a rating. Its column names match
+// the import fields, so a CSV or JSONL export can be imported again.
+type exportRow struct {
+	Ticker              string     `parquet:"ticker"`
+	Company             string     `parquet:"company"`
+	Brokerage           string     `parquet:"brokerage"`
+	BrokerageID         *int64     `parquet:"brokerage_id,optional"`
+	Action              string     `parquet:"action"`
+	ActionType          string     `parquet:"action_type"`
+	RatingFrom          string     `parquet:"rating_from"`
+	RatingTo            string     `parquet:"rating_to"`
+	RatingFromCanonical string     `parquet:"rating_from_canonical"`
+	RatingToCanonical   string     `parquet:"rating_to_canonical"`
+	TargetFrom          *float64   `parquet:"target_from,optional"`
+	TargetTo            *float64   `parquet:"target_to,optional"`
+	Currency            string     `parquet:"currency"`
+	Time                time.Time  `parquet:"time"`
+	CurrentPrice        *float64   `parquet:"current_price,optional"`
+	PriceAtRating       *float64   `parquet:"price_at_rating,optional"`
+	PriceUpdatedAt      *time.Time `parquet:"price_updated_at,optional"`
+	Exchange            string     `parquet:"exchange"`
+	Sector              string     `parquet:"sector"`
+	Industry            string     `parquet:"industry"`
+}
+
+// exportColumns is the CSV header, in exportRow field order.
+var exportColumns = []string{
+	"ticker", "company", "brokerage", "brokerage_id", "action", "action_type",
+	"rating_from", "rating_to", "rating_from_canonical", "rating_to_canonical",
+	"target_from", "target_to", "currency", "time", "current_price", "price_at_rating",
+	"price_updated_at", "exchange", "sector", "industry",
+}
+
+func newExportRow(r Rating) exportRow {
+	row := exportRow{
+		Ticker:              r.Ticker,
+		Company:             r.Company,
+		Brokerage:           r.Brokerage,
+		BrokerageID:         r.BrokerageID,
+		Action:              r.Action,
+		ActionType:          string(r.ActionType),
+		RatingFrom:          r.RatingFrom,
+		RatingTo:            r.RatingTo,
+		RatingFromCanonical: r.RatingFromCanonical,
+		RatingToCanonical:   r.RatingToCanonical,
+		TargetFrom:          r.TargetFrom,
+		TargetTo:            r.TargetTo,
+		Currency:            r.currency(),
+		Time:                r.Time.UTC(),
+		CurrentPrice:        r.CurrentPrice,
+		PriceAtRating:       r.PriceAtRating,
+		PriceUpdatedAt:      r.PriceUpdatedAt,
+	}
+	if r.Security != nil {
+		row.Exchange, row.Sector, row.Industry = r.Security.Exchange, r.Security.Sector, r.Security.Industry
+	}
+	return row
+}
+
+// csvRecord renders row under exportColumns; NULLs are empty.
+func (row exportRow) csvRecord() []string {
+	var brokerageID, pricedAt string
+	if row.BrokerageID != nil {
+		brokerageID = strconv.FormatInt(*row.BrokerageID, 10)
+	}
+	if row.PriceUpdatedAt != nil {
+		pricedAt = row.PriceUpdatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return []string{
+		row.Ticker, row.Company, row.Brokerage, brokerageID, row.Action, row.ActionType,
+		row.RatingFrom, row.RatingTo, row.RatingFromCanonical, row.RatingToCanonical,
+		formatTarget(row.TargetFrom), formatTarget(row.TargetTo), row.Currency,
+		row.Time.Format(time.RFC3339Nano), formatTarget(row.CurrentPrice), formatTarget(row.PriceAtRating),
+		pricedAt, row.Exchange, row.Sector, row.Industry,
+	}
+}
+
+// exportWriter encodes ratings one at a time. Close flushes what is
+// buffered but leaves the underlying writer open.
+type exportWriter interface {
+	Write(r Rating) error
+	Close() error
+}
+
+func newExportWriter(w io.Writer, format string) (exportWriter, error) {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(exportColumns); err != nil {
+			return nil, err
+		}
+		return csvExportWriter{cw}, nil
+	case "jsonl":
+		return jsonlExportWriter{json.NewEncoder(w)}, nil
+	case "parquet":
+		return parquetExportWriter{parquet.NewGenericWriter[exportRow](w, parquet.MaxRowsPerRowGroup(exportRowGroupSize))}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+type csvExportWriter struct{ cw *csv.Writer }
+
+func (c csvExportWriter) Write(r Rating) error {
+	return c.cw.Write(newExportRow(r).csvRecord())
+}
+
+func (c csvExportWriter) Close() error {
+	c.cw.Flush()
+	return c.cw.Error()
+}
+
+// jsonlExportWriter writes each rating as its /stocks item.
+type jsonlExportWriter struct{ enc *json.Encoder }
+
+func (j jsonlExportWriter) Write(r Rating) error { return j.enc.Encode(r.item()) }
+func (j jsonlExportWriter) Close() error         { return nil }
+
+type parquetExportWriter struct {
+	pw *parquet.GenericWriter[exportRow]
+}
+
+func (p parquetExportWriter) Write(r Rating) error {
+	_, err := p.pw.Write([]exportRow{newExportRow(r)})
+	return err
+}
+
+// Close writes the last row group and the file footer.
+func (p parquetExportWriter) Close() error { return p.pw.Close() }
+
+// exportRatings streams every rating matching f into w and closes it,
+// returning how many were written.
+func exportRatings(repo StockRepository, f RatingFilter, w exportWriter) (int, error) {
+	n := 0
+	err := repo.EachRating(f, func(r Rating) error {
+		if err := w.Write(r); err != nil {
+			return fmt.Errorf("writing %s: %w", r.Ticker, err)
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, w.Close()
+}
+
+// handleExport streams the ratings matching the /stocks parameters as a
+// download. format is csv (default), jsonl or parquet; without a limit every
+// match is exported.
+func handleExport(w http.ResponseWriter, r *http.Request, repo StockRepository) {
+	q := r.URL.Query()
+	f, err := ratingFilterFromQuery(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format, err := exportFormat("", q.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", exportFormats[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="stocks.%s"`, format))
+	out := &sentWriter{w: w}
+	ew, err := newExportWriter(out, format)
+	if err == nil {
+		var n int
+		if n, err = exportRatings(repo, f, ew); err != nil && out.sent {
+			// The status went out with the first bytes; the download is
+			// cut short instead
+			log.Printf("export after %d ratings: %v", n, err)
+			return
+		}
+	}
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// sentWriter records whether anything was written through it.
+type sentWriter struct {
+	w    io.Writer
+	sent bool
+}
+
+func (s *sentWriter) Write(p []byte) (int, error) {
+	s.sent = true
+	return s.w.Write(p)
+}
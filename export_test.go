@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportFormat(t *testing.T) {
+	for path, want := range map[string]string{"out.csv": "csv", "OUT.PARQUET": "parquet", "out.ndjson": "jsonl", "": "csv"} {
+		got, err := exportFormat(path, "")
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, path)
+	}
+	got, err := exportFormat("out.csv", "Parquet")
+	assert.NoError(t, err)
+	assert.Equal(t, "parquet", got)
+	_, err = exportFormat("", "xlsx")
+	assert.ErrorContains(t, err, `unknown export format "xlsx"`)
+}
+
+func TestExportRatings_RoundTripsThroughImport(t *testing.T) {
+	f := RatingFilter{Sort: "time"}
+	for _, format := range []string{"csv", "jsonl"} {
+		var buf bytes.Buffer
+		w, err := newExportWriter(&buf, format)
+		require.NoError(t, err)
+		n, err := exportRatings(testRatings(), f, w)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+
+		var r importReader = newJSONLReader(&buf)
+		if format == "csv" {
+			r, err = newCSVReader(&buf, nil)
+			require.NoError(t, err)
+		}
+		lines, errs := readAll(t, r)
+		assert.Empty(t, errs, format)
+		require.Len(t, lines, 3, format)
+		assert.Equal(t, "XYZ", lines[0].Item.Ticker)
+		assert.Equal(t, "ABC", lines[2].Item.Ticker)
+		assert.Equal(t, "Sell", lines[2].Item.RatingFrom)
+		assert.Equal(t, "12", lines[2].Item.TargetTo)
+		assert.Equal(t, "2025-01-02T00:00:00Z", lines[2].Item.Time, format)
+	}
+}
+
+func TestExportRatings_Parquet(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newExportWriter(&buf, "parquet")
+	require.NoError(t, err)
+	_, err = exportRatings(testRatings(), RatingFilter{Sort: "time"}, w)
+	require.NoError(t, err)
+
+	rows, err := parquet.Read[exportRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, newExportRow(testRatings().ratings[0]), rows[0])
+	assert.Nil(t, rows[0].PriceAtRating)
+	assert.Equal(t, 2.5, *rows[1].PriceAtRating)
+}
+
+func TestExportRatings_WriterError(t *testing.T) {
+	n, err := exportRatings(testRatings(), RatingFilter{}, failingExportWriter{})
+	assert.Equal(t, 0, n)
+	assert.ErrorContains(t, err, "writing ABC: disk full")
+}
+
+// failingExportWriter rejects every rating.
+type failingExportWriter struct{}
+
+func (failingExportWriter) Write(Rating) error { return errors.New("disk full") }
+func (failingExportWriter) Close() error       { return nil }
+
+func TestHandleExport(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleExport(w, httptest.NewRequest("GET", "/export?action_type=reiterate&sort=time&order=desc", nil), testRatings())
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="stocks.csv"`, w.Header().Get("Content-Disposition"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 3, "header and the two reiterations")
+	assert.True(t, strings.HasPrefix(lines[1], "XYZ,X Co,Brok,,reiterated,reiterate,Hold,Hold,"), lines[1])
+
+	w = httptest.NewRecorder()
+	handleExport(w, httptest.NewRequest("GET", "/export?format=jsonl&limit=1", nil), testRatings())
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "\n"))
+
+	for _, query := range []string{"format=xml", "sort=nope", "action_type=upgraded"} {
+		w = httptest.NewRecorder()
+		handleExport(w, httptest.NewRequest("GET", "/export?"+query, nil), testRatings())
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	w = httptest.NewRecorder()
+	handleExport(w, httptest.NewRequest("GET", "/export", nil), failingRepo{fmt.Errorf("down")})
+	assert.Equal(t, http.StatusInternalServerError, w.Code, "nothing was sent yet")
+	assert.Empty(t, w.Header().Get("Content-Disposition"))
+}
+
+func TestEachRating_SQLite(t *testing.T) {
+	db := openTestSQLite(t)
+	repo := newSQLiteRepository(db)
+	for _, r := range testRatings().ratings {
+		_, err := repo.InsertRating(r)
+		require.NoError(t, err)
+	}
+
+	var got []string
+	err := repo.EachRating(RatingFilter{Search: "x co", Sort: "time", Desc: true}, func(r Rating) error {
+		got = append(got, r.RatingTo)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Hold", "Buy"}, got)
+
+	stop := errors.New("stop")
+	calls := 0
+	err = repo.EachRating(RatingFilter{}, func(Rating) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeFaults are the chances, per request, that the fake upstream fails
+// instead of answering.
+type fakeFaults struct {
+	RateLimit float64 // 429 with a Retry-After
+	Timeout   float64 // stall for Delay before answering
+	Malformed float64 // truncated JSON body
+	Delay     time.Duration
+}
+
+// parseFaults parses "kind=rate,..." pairs where kind is 429, timeout or
+// malformed and rate is a probability between 0 and 1.
+func parseFaults(spec string, delay time.Duration) (fakeFaults, error) {
+	faults := fakeFaults{Delay: delay}
+	total := 0.0
+	for _, pair := range splitParam(spec) {
+		kind, v, ok := strings.Cut(pair, "=")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if !ok || err != nil || rate < 0 || rate > 1 {
+			return fakeFaults{}, fmt.Errorf("invalid fault %q; use kind=rate with a rate between 0 and 1", pair)
+		}
+		switch strings.ToLower(strings.TrimSpace(kind)) {
+		case "429":
+			faults.RateLimit = rate
+		case "timeout":
+			faults.Timeout = rate
+		case "malformed":
+			faults.Malformed = rate
+		default:
+			return fakeFaults{}, fmt.Errorf("invalid fault %q: unknown kind %q; use 429, timeout or malformed", pair, kind)
+		}
+		total += rate
+	}
+	if total > 1 {
+		return fakeFaults{}, fmt.Errorf("fault rates add up to %g, more than 1", total)
+	}
+	return faults, nil
+}
+
+// fakeOptions configure -mode=fake-upstream.
+type fakeOptions struct {
+	PageSize   int
+	Faults     string // see parseFaults
+	FaultDelay time.Duration
+	// Generated items, unless read from a file
+	Seed             uint64
+	Tickers, Ratings int
+}
+
+// readFakeItems reads the items to serve from an import file, skipping the
+// lines that cannot be read.
+func readFakeItems(path, format, columns string) ([]StockItem, error) {
+	format, err := importFormat(path, format)
+	if err != nil {
+		return nil, err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	var r importReader = newJSONLReader(in)
+	switch format {
+	case "csv":
+		mapping, err := parseColumnMapping(columns)
+		if err != nil {
+			return nil, err
+		}
+		if r, err = newCSVReader(in, mapping); err != nil {
+			return nil, err
+		}
+	case "json":
+		if r, err = newJSONReader(in); err != nil {
+			return nil, err
+		}
+	}
+	var items []StockItem
+	for {
+		line, err := r.Next()
+		var lerr *lineError
+		switch {
+		case err == io.EOF:
+			return items, nil
+		case errors.As(err, &lerr):
+			log.Printf("%s: skipping %v", path, lerr)
+		case err != nil:
+			return nil, err
+		default:
+			items = append(items, line.Item)
+		}
+	}
+}
+
+// fakeUpstream serves items as the ratings API does, newest first in pages
+// linked by next_page, and a Yahoo chart endpoint pricing their tickers with
+// syntheticPrice. Faults are drawn from a seeded source, so a run with the
+// same requests fails the same way.
+type fakeUpstream struct {
+	items    []StockItem
+	pageSize int
+	token    string // required bearer token; empty accepts any
+	faults   fakeFaults
+	tickers  map[string]bool
+	asOf     time.Time // day of the current price: the newest rating
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newFakeUpstream(items []StockItem, pageSize int, token string, faults fakeFaults, seed uint64) *fakeUpstream {
+	f := &fakeUpstream{
+		items:    items,
+		pageSize: max(pageSize, 1),
+		token:    token,
+		faults:   faults,
+		tickers:  map[string]bool{},
+		asOf:     syntheticEpoch,
+		rng:      rand.New(rand.NewPCG(seed, seed^0xfa17)),
+	}
+	var newest time.Time
+	for _, item := range items {
+		f.tickers[item.Ticker] = true
+		if t, err := time.Parse(time.RFC3339Nano, item.Time); err == nil && t.After(newest) {
+			newest = t
+		}
+	}
+	if !newest.IsZero() {
+		f.asOf = newest
+	}
+	return f
+}
+
+// Handler routes /v8/finance/chart/{ticker} to the price endpoint and any
+// other path to the ratings pages.
+func (f *fakeUpstream) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v8/finance/chart/", f.serveChart)
+	mux.HandleFunc("/", f.servePage)
+	return mux
+}
+
+// fault draws the failure for one request: "429", "timeout", "malformed" or
+// none.
+func (f *fakeUpstream) fault() string {
+	f.mu.Lock()
+	p := f.rng.Float64()
+	f.mu.Unlock()
+	switch {
+	case p < f.faults.RateLimit:
+		return "429"
+	case p < f.faults.RateLimit+f.faults.Timeout:
+		return "timeout"
+	case p < f.faults.RateLimit+f.faults.Timeout+f.faults.Malformed:
+		return "malformed"
+	}
+	return ""
+}
+
+// respond writes v as JSON after injecting a fault, if one is drawn.
+func (f *fakeUpstream) respond(w http.ResponseWriter, r *http.Request, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch f.fault() {
+	case "429":
+		log.Printf("fake upstream: 429 for %s", r.URL.RequestURI())
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	case "timeout":
+		log.Printf("fake upstream: stalling %s for %s", r.URL.RequestURI(), f.faults.Delay)
+		select {
+		case <-time.After(f.faults.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	case "malformed":
+		log.Printf("fake upstream: truncating %s", r.URL.RequestURI())
+		body = body[:len(body)/2]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// servePage answers a ratings API request. next_page is the offset of the
+// page, as an opaque token.
+func (f *fakeUpstream) servePage(w http.ResponseWriter, r *http.Request) {
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	offset := 0
+	if v := r.URL.Query().Get("next_page"); v != "" {
+		var err error
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 || offset > len(f.items) {
+			http.Error(w, "invalid next_page", http.StatusBadRequest)
+			return
+		}
+	}
+	end := min(offset+f.pageSize, len(f.items))
+	page := APIResponse{Items: f.items[offset:end]}
+	if end < len(f.items) {
+		page.NextPage = strconv.Itoa(end)
+	}
+	f.respond(w, r, http.StatusOK, page)
+}
+
+// fakeChart is the chart endpoint payload read by yahooProvider.chart.
+type fakeChart struct {
+	Chart struct {
+		Result []map[string]any `json:"result"`
+		Error  map[string]any   `json:"error"`
+	} `json:"chart"`
+}
+
+// serveChart answers the Yahoo chart endpoint: the current price for a
+// range query, daily bars for a period1/period2 query.
+func (f *fakeUpstream) serveChart(w http.ResponseWriter, r *http.Request) {
+	ticker := strings.TrimPrefix(r.URL.Path, "/v8/finance/chart/")
+	var chart fakeChart
+	if !f.tickers[ticker] {
+		chart.Chart.Error = map[string]any{"code": "Not Found", "description": "No data found, symbol may be delisted"}
+		f.respond(w, r, http.StatusNotFound, chart)
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Has("range") {
+		chart.Chart.Result = []map[string]any{{"meta": map[string]any{"regularMarketPrice": syntheticPrice(ticker, f.asOf)}}}
+		f.respond(w, r, http.StatusOK, chart)
+		return
+	}
+	p1, err1 := strconv.ParseInt(q.Get("period1"), 10, 64)
+	p2, err2 := strconv.ParseInt(q.Get("period2"), 10, 64)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "need range or period1 and period2", http.StatusBadRequest)
+		return
+	}
+	// Sessions opening at 14:30 UTC, as Yahoo timestamps them
+	bars, _ := syntheticPrices{}.DailyBars(ticker, time.Unix(p1, 0).UTC(), time.Unix(p2-1, 0).UTC())
+	var stamps []int64
+	var opens, highs, lows, closes []float64
+	var volumes []int64
+	for _, b := range bars {
+		stamps = append(stamps, b.Date.Add(14*time.Hour+30*time.Minute).Unix())
+		opens, highs, lows, closes = append(opens, b.Open), append(highs, b.High), append(lows, b.Low), append(closes, b.Close)
+		volumes = append(volumes, b.Volume)
+	}
+	chart.Chart.Result = []map[string]any{{
+		"meta":      map[string]any{"gmtoffset": -18000},
+		"timestamp": stamps,
+		"indicators": map[string]any{"quote": []map[string]any{{
+			"open": opens, "high": highs, "low": lows, "close": closes, "volume": volumes,
+		}}},
+	}}
+	f.respond(w, r, http.StatusOK, chart)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFaults(t *testing.T) {
+	faults, err := parseFaults("429=0.1, timeout=0.05,malformed=0", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, fakeFaults{RateLimit: 0.1, Timeout: 0.05, Delay: time.Second}, faults)
+
+	for spec, msg := range map[string]string{
+		"500=0.1":              `unknown kind "500"`,
+		"429":                  "use kind=rate",
+		"timeout=2":            "use kind=rate",
+		"429=0.6,malformed=.6": "add up to 1.2",
+	} {
+		_, err := parseFaults(spec, 0)
+		assert.ErrorContains(t, err, msg, spec)
+	}
+}
+
+func TestFakeUpstream_Fetch(t *testing.T) {
+	items := syntheticItems(3, 4, 25)
+	fake := newFakeUpstream(items, 10, "secret", fakeFaults{}, 1)
+	ts := httptest.NewServer(fake.Handler())
+	defer ts.Close()
+	oldEndpoint, oldToken := APIEndpoint, BearerToken
+	APIEndpoint, BearerToken = ts.URL+"/list", "secret"
+	defer func() { APIEndpoint, BearerToken = oldEndpoint, oldToken }()
+
+	db := openTestSQLite(t)
+	prep, err := db.Prepare(sqliteDialect.InsertStmt)
+	require.NoError(t, err)
+	defer prep.Close()
+	summary, err := fetchAndStoreAllPages(db, prep, fetchOptions{Prices: newYahooProvider(ts.URL), PriceWorkers: 2})
+	require.NoError(t, err)
+	assert.Equal(t, fetchSummary{Inserted: 25}, summary)
+
+	var pages int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM fetch_checkpoints").Scan(&pages))
+	assert.Equal(t, 3, pages, "25 items in pages of 10")
+
+	newest, err := time.Parse(time.RFC3339Nano, items[0].Time)
+	require.NoError(t, err)
+	latest, err := newSQLiteRepository(db).LatestForTicker(items[0].Ticker)
+	require.NoError(t, err)
+	assert.Equal(t, syntheticPrice(items[0].Ticker, newest), *latest.CurrentPrice)
+	require.NotNil(t, latest.PriceAtRating, "daily bars cover the rating date")
+
+	BearerToken = "wrong"
+	_, err = fetchPage(newUpstreamClient(upstreamConfig{}), "")
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestFakeUpstream_Faults(t *testing.T) {
+	items := syntheticItems(3, 2, 5)
+	get := func(faults fakeFaults, timeout time.Duration) (*http.Response, error) {
+		ts := httptest.NewServer(newFakeUpstream(items, 10, "", faults, 1).Handler())
+		t.Cleanup(ts.Close)
+		return (&http.Client{Timeout: timeout}).Get(ts.URL + "/list")
+	}
+
+	resp, err := get(fakeFaults{RateLimit: 1}, time.Second)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	resp, err = get(fakeFaults{Malformed: 1}, time.Second)
+	require.NoError(t, err)
+	var page APIResponse
+	assert.Error(t, json.NewDecoder(resp.Body).Decode(&page))
+	resp.Body.Close()
+
+	_, err = get(fakeFaults{Timeout: 1, Delay: time.Second}, 20*time.Millisecond)
+	assert.ErrorContains(t, err, "Timeout")
+}
+
+func TestFakeUpstream_ServesItemsFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.csv")
+	require.NoError(t, os.WriteFile(path, []byte("Symbol,time\nAAA,2025-01-13T00:00:00Z\nBBB\n"), 0o600))
+	items, err := readFakeItems(path, "", "ticker=Symbol")
+	require.NoError(t, err)
+	assert.Equal(t, []StockItem{{Ticker: "AAA", Time: "2025-01-13T00:00:00Z"}}, items, "unreadable lines are skipped")
+
+	ts := httptest.NewServer(newFakeUpstream(items, 10, "", fakeFaults{}, 1).Handler())
+	defer ts.Close()
+	p := newYahooProvider(ts.URL)
+	price, err := p.CurrentPrice("AAA")
+	require.NoError(t, err)
+	assert.Equal(t, syntheticPrice("AAA", time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC)), price)
+	_, err = p.CurrentPrice("ZZZ")
+	assert.ErrorContains(t, err, "status 404")
+}
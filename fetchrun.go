@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Fetch run statuses stored in fetch_runs.status.
+const (
+	runRunning   = "running"
+	runCompleted = "completed"
+	runFailed    = "failed"
+)
+
+// fetchRun is the resumable state of one fetch: the last committed page and
+// the cursor to request next.
+type fetchRun struct {
+	ID      int64
+	Page    int
+	NextKey string
+	Summary fetchSummary
+}
+
+// startFetchRun opens a new run, or when resume is set and the most recent
+// run did not complete, picks it up at its last checkpoint.
+func startFetchRun(db *sql.DB, resume bool) (fetchRun, error) {
+	var run fetchRun
+	if resume {
+		// Only the latest run resumes: an older unfinished one was
+		// superseded by whatever ran after it
+		var status string
+		err := db.QueryRow(
+			"SELECT id, status, inserted, updated, unchanged, failed FROM fetch_runs ORDER BY id DESC LIMIT 1",
+		).Scan(&run.ID, &status, &run.Summary.Inserted, &run.Summary.Updated, &run.Summary.Unchanged, &run.Summary.Failed)
+		if err != nil && err != sql.ErrNoRows {
+			return run, fmt.Errorf("loading last run: %w", err)
+		}
+		if err == nil && status != runCompleted {
+			err = db.QueryRow(
+				"SELECT page, next_page FROM fetch_checkpoints WHERE run_id=$1 ORDER BY page DESC LIMIT 1",
+				run.ID,
+			).Scan(&run.Page, &run.NextKey)
+			if err != nil && err != sql.ErrNoRows {
+				return run, fmt.Errorf("loading checkpoint: %w", err)
+			}
+			if _, err := db.Exec("UPDATE fetch_runs SET status=$2, finished_at=NULL WHERE id=$1", run.ID, runRunning); err != nil {
+				return run, fmt.Errorf("reopening run %d: %w", run.ID, err)
+			}
+			return run, nil
+		}
+	}
+
+	if err := db.QueryRow("INSERT INTO fetch_runs (status) VALUES ($1) RETURNING id", runRunning).Scan(&run.ID); err != nil {
+		return run, fmt.Errorf("creating run: %w", err)
+	}
+	return run, nil
+}
+
+// commitCheckpoint records the page and its cursor inside the page's
+// transaction, together with the counts and newest rating time that page
+// contributed.
+func commitCheckpoint(tx *sql.Tx, run fetchRun, page int, nextKey string, counts fetchSummary, newest sql.NullTime) error {
+	if _, err := tx.Exec(
+		"INSERT INTO fetch_checkpoints (run_id, page, next_page) VALUES ($1, $2, $3)",
+		run.ID, page, nextKey,
+	); err != nil {
+		return fmt.Errorf("insert checkpoint: %w", err)
+	}
+	if _, err := tx.Exec(
+		"UPDATE fetch_runs SET inserted=inserted+$2, updated=updated+$3, unchanged=unchanged+$4, failed=failed+$5, newest_time=CASE WHEN newest_time IS NULL OR $6 > newest_time THEN $6 ELSE newest_time END WHERE id=$1",
+		run.ID, counts.Inserted, counts.Updated, counts.Unchanged, counts.Failed, newest,
+	); err != nil {
+		return fmt.Errorf("update run counts: %w", err)
+	}
+	return nil
+}
+
+// finishFetchRun marks the run as completed or failed.
+func finishFetchRun(db *sql.DB, run fetchRun, status string) error {
+	if _, err := db.Exec("UPDATE fetch_runs SET status=$2, finished_at=CURRENT_TIMESTAMP WHERE id=$1", run.ID, status); err != nil {
+		return fmt.Errorf("finishing run %d: %w", run.ID, err)
+	}
+	return nil
+}
+
+// loadWatermark returns the newest rating time stored by a completed run
+// against source; ok is false when the source has never been fetched.
+func loadWatermark(db *sql.DB, source string) (t time.Time, ok bool, err error) {
+	err = db.QueryRow("SELECT newest_time FROM fetch_watermarks WHERE source=$1", source).Scan(&t)
+	if err == sql.ErrNoRows {
+		return t, false, nil
+	} else if err != nil {
+		return t, false, fmt.Errorf("loading watermark: %w", err)
+	}
+	return t, true, nil
+}
+
+// advanceWatermark moves the source watermark up to the newest rating time
+// seen by a completed run. It is only called on completion so a failed run
+// never hides pages it did not store.
+func advanceWatermark(db *sql.DB, source string, run fetchRun) error {
+	if _, err := db.Exec(`
+		INSERT INTO fetch_watermarks (source, newest_time)
+		SELECT $1, newest_time FROM fetch_runs WHERE id=$2 AND newest_time IS NOT NULL
+		ON CONFLICT (source) DO UPDATE SET newest_time = CASE
+			WHEN EXCLUDED.newest_time > fetch_watermarks.newest_time THEN EXCLUDED.newest_time
+			ELSE fetch_watermarks.newest_time END
+	`, source, run.ID); err != nil {
+		return fmt.Errorf("advancing watermark: %w", err)
+	}
+	return nil
+}
+
+// newestItemTime returns the latest parseable Time among items.
+func newestItemTime(items []StockItem) sql.NullTime {
+	var newest sql.NullTime
+	for _, item := range items {
+		t, err := time.Parse(time.RFC3339Nano, item.Time)
+		if err != nil {
+			continue
+		}
+		if !newest.Valid || t.After(newest.Time) {
+			newest = sql.NullTime{Time: t.UTC(), Valid: true}
+		}
+	}
+	return newest
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubUpstream points APIEndpoint at a test server, returning a restore func.
+func stubUpstream(handler http.HandlerFunc) (restore func()) {
+	ts := httptest.NewServer(handler)
+	oldEndpoint := APIEndpoint
+	APIEndpoint = ts.URL
+	return func() {
+		ts.Close()
+		APIEndpoint = oldEndpoint
+	}
+}
+
+// testPrices is an offline price source for fetch tests.
+var testPrices = &fileProvider{prices: map[string]float64{"TCK": 10.0}}
+
+const onePage = `{"items":[{"ticker":"TCK","company":"Comp","brokerage":"Brok","action":"Act","rating_from":"Hold","rating_to":"Buy","target_from":"$1","target_to":"$2","time":"2025-01-13T00:30:05Z"}],"next_page":""}`
+
+func TestFetchAndStoreAllPages_ResumesFromCheckpoint(t *testing.T) {
+	var gotCursor string
+	restore := stubUpstream(func(w http.ResponseWriter, r *http.Request) {
+		gotCursor = r.URL.Query().Get("next_page")
+		fmt.Fprint(w, onePage)
+	})
+	defer restore()
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO stock_info")
+	mock.ExpectQuery("FROM fetch_runs").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "inserted", "updated", "unchanged", "failed"}).AddRow(7, runFailed, 5, 0, 0, 0))
+	mock.ExpectQuery("FROM fetch_checkpoints").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"page", "next_page"}).AddRow(2, "abc"))
+	mock.ExpectExec("UPDATE fetch_runs SET status").WithArgs(7, runRunning).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO stock_info").WillReturnRows(sqlmock.NewRows([]string{"inserted"}).AddRow(true))
+	mock.ExpectExec("RELEASE SAVEPOINT item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO securities").WithArgs("TCK", "Comp").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO fetch_checkpoints").WithArgs(7, 3, "").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE fetch_runs SET inserted").WithArgs(7, 1, 0, 0, 0, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("UPDATE fetch_runs SET status").WithArgs(7, runCompleted).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO fetch_watermarks").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	prep, err := db.Prepare(insertStmt)
+	assert.NoError(t, err)
+
+	summary, err := fetchAndStoreAllPages(db, prep, fetchOptions{Resume: true, Prices: testPrices})
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", gotCursor)
+	assert.Equal(t, 6, summary.Inserted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStartFetchRun_IgnoresRunsBeforeLastCompleted(t *testing.T) {
+	db := openTestSQLite(t)
+	_, err := db.Exec("INSERT INTO fetch_runs (id, status) VALUES (1, $1), (2, $2)", runFailed, runCompleted)
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO fetch_checkpoints (run_id, page, next_page) VALUES (1, 4, 'stale')")
+	require.NoError(t, err)
+
+	run, err := startFetchRun(db, true)
+	require.NoError(t, err)
+	assert.Equal(t, fetchRun{ID: 3}, run, "a new run from the first page")
+
+	// Until it completes, the new run is the one to resume
+	_, err = db.Exec("INSERT INTO fetch_checkpoints (run_id, page, next_page) VALUES (3, 1, 'next')")
+	require.NoError(t, err)
+	run, err = startFetchRun(db, true)
+	require.NoError(t, err)
+	assert.Equal(t, fetchRun{ID: 3, Page: 1, NextKey: "next"}, run)
+}
+
+func TestFetchAndStoreAllPages_RollsBackFailedPage(t *testing.T) {
+	restore := stubUpstream(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, onePage)
+	})
+	defer restore()
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO stock_info")
+	mock.ExpectQuery("INSERT INTO fetch_runs").WithArgs(runRunning).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO stock_info").WillReturnError(fmt.Errorf("connection reset"))
+	mock.ExpectRollback()
+	mock.ExpectExec("UPDATE fetch_runs SET status").WithArgs(8, runFailed).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	prep, err := db.Prepare(insertStmt)
+	assert.NoError(t, err)
+
+	_, err = fetchAndStoreAllPages(db, prep, fetchOptions{Prices: testPrices})
+	assert.ErrorIs(t, err, errExecInsert)
+	assert.Contains(t, err.Error(), "page 1")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFetchAndStoreAllPages_IncrementalStopsAtWatermark(t *testing.T) {
+	requests := 0
+	restore := stubUpstream(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, strings.Replace(onePage, `"next_page":""`, `"next_page":"more"`, 1))
+	})
+	defer restore()
+
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO stock_info")
+	mock.ExpectQuery("FROM fetch_watermarks").WithArgs(APIEndpoint).
+		WillReturnRows(sqlmock.NewRows([]string{"newest_time"}).AddRow(time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)))
+	mock.ExpectQuery("INSERT INTO fetch_runs").WithArgs(runRunning).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec("UPDATE fetch_runs SET status").WithArgs(9, runCompleted).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO fetch_watermarks").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	prep, err := db.Prepare(insertStmt)
+	assert.NoError(t, err)
+
+	summary, err := fetchAndStoreAllPages(db, prep, fetchOptions{Incremental: true, Prices: testPrices})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, fetchSummary{}, summary)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// loadFXRatesCSV reads "currency,usd_rate" rows (a header row is optional),
+// where usd_rate is the USD value of one unit of the currency.
+func loadFXRatesCSV(r io.Reader) (map[string]float64, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading FX rates: %w", err)
+	}
+	rates := map[string]float64{}
+	for i, rec := range records {
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rec[1]), 64)
+		if err != nil {
+			if i == 0 {
+				continue // header
+			}
+			return nil, fmt.Errorf("FX rates line %d: %w", i+1, err)
+		}
+		code := strings.ToUpper(strings.TrimSpace(rec[0]))
+		if len(code) != 3 || rate <= 0 {
+			return nil, fmt.Errorf("FX rates line %d: invalid rate %s=%v", i+1, code, rate)
+		}
+		rates[code] = rate
+	}
+	return rates, nil
+}
+
+// storeFXRates upserts rates into fx_rates in one transaction.
+func storeFXRates(db *sql.DB, rates map[string]float64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin FX rates: %w", err)
+	}
+	defer tx.Rollback()
+	for code, rate := range rates {
+		if _, err := tx.Exec(`
+			INSERT INTO fx_rates (currency, usd_rate, updated_at) VALUES ($1, $2, CURRENT_TIMESTAMP)
+			ON CONFLICT (currency) DO UPDATE SET usd_rate = excluded.usd_rate, updated_at = excluded.updated_at`,
+			code, rate,
+		); err != nil {
+			return fmt.Errorf("storing FX rate %s: %w", code, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// queryFXRates reads fx_rates; the base currency is always present at 1.
+func queryFXRates(db *sql.DB) (map[string]float64, error) {
+	rows, err := db.Query("SELECT currency, usd_rate FROM fx_rates")
+	if err != nil {
+		return nil, fmt.Errorf("loading FX rates: %w", err)
+	}
+	defer rows.Close()
+	rates := map[string]float64{baseCurrency: 1}
+	for rows.Next() {
+		var code string
+		var rate float64
+		if err := rows.Scan(&code, &rate); err != nil {
+			return nil, fmt.Errorf("scanning FX rate: %w", err)
+		}
+		rates[code] = rate
+	}
+	return rates, rows.Err()
+}
+
+// toUSD converts amount from currency with rates; ok is false when no rate
+// is known.
+func toUSD(rates map[string]float64, amount float64, currency string) (float64, bool) {
+	if currency == "" || currency == baseCurrency {
+		return amount, true
+	}
+	rate, ok := rates[currency]
+	if !ok {
+		return 0, false
+	}
+	return amount * rate, true
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// importChunkSize is how many imported items are stored per transaction.
+const importChunkSize = 500
+
+// lineError is an imported line that could not be read or stored.
+type lineError struct {
+	Line int
+	Err  error
+}
+
+func (e *lineError) Error() string { return fmt.Sprintf("line %d: %v", e.Line, e.Err) }
+func (e *lineError) Unwrap() error { return e.Err }
+
+// importLine is an item read from an import file with the line it started on.
+type importLine struct {
+	Line int
+	Item StockItem
+}
+
+// importReader yields the items of an import file. Next returns io.EOF at
+// the end and a *lineError for a line it could not read, after which reading
+// continues; any other error is fatal.
+type importReader interface {
+	Next() (importLine, error)
+}
+
+// importFormat picks the reader for path: format when set, else the file
+// extension.
+func importFormat(path, format string) (string, error) {
+	if format != "" {
+		format = strings.ToLower(format)
+	} else {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".jsonl", ".ndjson":
+			format = "jsonl"
+		case ".json":
+			format = "json"
+		case ".csv":
+			format = "csv"
+		}
+	}
+	if format != "jsonl" && format != "json" && format != "csv" {
+		return "", fmt.Errorf("unknown import format for %s; use -format=jsonl, -format=json or -format=csv", path)
+	}
+	return format, nil
+}
+
+// decodeImportJSON decodes one JSON value of an import file: an APIResponse
+// page, whose items it returns, or a single StockItem.
+func decodeImportJSON(b []byte) ([]StockItem, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("decoding JSON: %w", err)
+	}
+	if _, isPage := fields["items"]; isPage {
+		var page APIResponse
+		if err := json.Unmarshal(b, &page); err != nil {
+			return nil, fmt.Errorf("decoding page: %w", err)
+		}
+		return page.Items, nil
+	}
+	var item StockItem
+	if err := json.Unmarshal(b, &item); err != nil {
+		return nil, fmt.Errorf("decoding item: %w", err)
+	}
+	return []StockItem{item}, nil
+}
+
+// jsonlReader reads one StockItem or one APIResponse page per line. Blank
+// lines are skipped.
+type jsonlReader struct {
+	sc      *bufio.Scanner
+	line    int
+	pending []StockItem // rest of the current page
+}
+
+func newJSONLReader(r io.Reader) *jsonlReader {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024) // a page dump can be one long line
+	return &jsonlReader{sc: sc}
+}
+
+func (j *jsonlReader) Next() (importLine, error) {
+	for len(j.pending) == 0 {
+		if !j.sc.Scan() {
+			if err := j.sc.Err(); err != nil {
+				return importLine{}, fmt.Errorf("reading line %d: %w", j.line+1, err)
+			}
+			return importLine{}, io.EOF
+		}
+		j.line++
+		b := []byte(strings.TrimSpace(j.sc.Text()))
+		if len(b) == 0 {
+			continue
+		}
+		items, err := decodeImportJSON(b)
+		if err != nil {
+			return importLine{}, &lineError{j.line, err}
+		}
+		j.pending = items
+	}
+	item := j.pending[0]
+	j.pending = j.pending[1:]
+	return importLine{Line: j.line, Item: item}, nil
+}
+
+// jsonReader reads a JSON document: an array of StockItems or APIResponse
+// pages, or a single page. Each item is numbered by the line its array
+// element starts on.
+type jsonReader struct {
+	data    []byte
+	dec     *json.Decoder
+	array   bool
+	done    bool
+	line    int
+	pending []StockItem // rest of the current element
+}
+
+func newJSONReader(r io.Reader) (*jsonReader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading JSON: %w", err)
+	}
+	j := &jsonReader{data: data, dec: json.NewDecoder(bytes.NewReader(data)), line: 1}
+	switch trimmed := bytes.TrimSpace(data); {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		j.array = true
+		if _, err := j.dec.Token(); err != nil {
+			return nil, fmt.Errorf("reading JSON: %w", err)
+		}
+	case !bytes.HasPrefix(trimmed, []byte("{")):
+		return nil, errors.New("a JSON import holds an array of items or pages, or one page; use -format=jsonl for one value per line")
+	}
+	return j, nil
+}
+
+func (j *jsonReader) Next() (importLine, error) {
+	for len(j.pending) == 0 {
+		if j.done {
+			return importLine{}, io.EOF
+		}
+		if !j.array {
+			j.done = true
+			items, err := decodeImportJSON(bytes.TrimSpace(j.data))
+			if err != nil {
+				return importLine{}, &lineError{j.line, err}
+			}
+			j.pending = items
+			continue
+		}
+		if !j.dec.More() {
+			j.done = true
+			continue
+		}
+		var raw json.RawMessage
+		if err := j.dec.Decode(&raw); err != nil {
+			// A malformed document has no next element to resume at
+			return importLine{}, fmt.Errorf("reading JSON after line %d: %w", j.line, err)
+		}
+		start := int(j.dec.InputOffset()) - len(raw)
+		j.line = 1 + bytes.Count(j.data[:start], []byte("\n"))
+		items, err := decodeImportJSON(raw)
+		if err != nil {
+			return importLine{}, &lineError{j.line, err}
+		}
+		j.pending = items
+	}
+	item := j.pending[0]
+	j.pending = j.pending[1:]
+	return importLine{Line: j.line, Item: item}, nil
+}
+
+// importFields are the StockItem fields a CSV column can map to, by JSON key.
+var importFields = map[string]func(*StockItem, string){
+	"ticker":      func(s *StockItem, v string) { s.Ticker = v },
+	"company":     func(s *StockItem, v string) { s.Company = v },
+	"brokerage":   func(s *StockItem, v string) { s.Brokerage = v },
+	"action":      func(s *StockItem, v string) { s.Action = v },
+	"rating_from": func(s *StockItem, v string) { s.RatingFrom = v },
+	"rating_to":   func(s *StockItem, v string) { s.RatingTo = v },
+	"target_from": func(s *StockItem, v string) { s.TargetFrom = v },
+	"target_to":   func(s *StockItem, v string) { s.TargetTo = v },
+	"time":        func(s *StockItem, v string) { s.Time = v },
+	"currency":    func(s *StockItem, v string) { s.Currency = v },
+}
+
+// parseColumnMapping parses "field=Column,..." pairs mapping StockItem JSON
+// keys to CSV header names.
+func parseColumnMapping(spec string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, pair := range splitParam(spec) {
+		field, column, ok := strings.Cut(pair, "=")
+		field = strings.ToLower(strings.TrimSpace(field))
+		if !ok || strings.TrimSpace(column) == "" {
+			return nil, fmt.Errorf("invalid column mapping %q; use field=Column", pair)
+		}
+		if _, known := importFields[field]; !known {
+			return nil, fmt.Errorf("invalid column mapping %q: unknown field %q", pair, field)
+		}
+		mapping[field] = strings.TrimSpace(column)
+	}
+	return mapping, nil
+}
+
+// csvReader reads items from a CSV with a header row. Each field is read
+// from the column mapping names, or from the column named like the field;
+// other columns are ignored.
+type csvReader struct {
+	cr   *csv.Reader
+	cols map[string]int // field -> column index
+}
+
+func newCSVReader(r io.Reader, mapping map[string]string) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // short rows are reported per line
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %w", err)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	cols := map[string]int{}
+	for field := range importFields {
+		column, mapped := mapping[field]
+		if !mapped {
+			column = field
+		}
+		i, ok := index[strings.ToLower(column)]
+		if !ok && mapped {
+			return nil, fmt.Errorf("CSV header has no column %q for %s", column, field)
+		}
+		if ok {
+			cols[field] = i
+		}
+	}
+	for _, required := range []string{"ticker", "time"} {
+		if _, ok := cols[required]; !ok {
+			return nil, fmt.Errorf("CSV header has no %s column; map one with -columns %s=Column", required, required)
+		}
+	}
+	return &csvReader{cr: cr, cols: cols}, nil
+}
+
+func (c *csvReader) Next() (importLine, error) {
+	rec, err := c.cr.Read()
+	if err == io.EOF {
+		return importLine{}, io.EOF
+	}
+	line, _ := c.cr.FieldPos(0)
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return importLine{}, &lineError{parseErr.StartLine, parseErr.Err}
+	} else if err != nil {
+		return importLine{}, fmt.Errorf("reading CSV: %w", err)
+	}
+
+	var item StockItem
+	for field, i := range c.cols {
+		if i >= len(rec) {
+			return importLine{}, &lineError{line, fmt.Errorf("%d columns, %s is column %d", len(rec), field, i+1)}
+		}
+		importFields[field](&item, strings.TrimSpace(rec[i]))
+	}
+	return importLine{Line: line, Item: item}, nil
+}
+
+// importReport is the outcome of an import. Failed counts Errors.
+type importReport struct {
+	fetchSummary
+	Errors []*lineError
+}
+
+// importItems stores everything r yields in chunks, with the parsing and
+// lookups of a fetch. Lines that cannot be read, parsed or stored are
+// reported in the report's Errors; other database errors stop the import
+// after the chunks already committed.
+func importItems(f *pageFetcher, r importReader) (importReport, error) {
+	var report importReport
+	var chunk []importLine
+	for {
+		line, err := r.Next()
+		var lerr *lineError
+		switch {
+		case err == io.EOF:
+			err := f.importChunk(chunk, &report)
+			// Unreadable lines were reported before the chunk around them
+			slices.SortStableFunc(report.Errors, func(a, b *lineError) int { return cmp.Compare(a.Line, b.Line) })
+			return report, err
+		case errors.As(err, &lerr):
+			report.Errors = append(report.Errors, lerr)
+			report.Failed++
+			continue
+		case err != nil:
+			return report, err
+		}
+		if chunk = append(chunk, line); len(chunk) == importChunkSize {
+			if err := f.importChunk(chunk, &report); err != nil {
+				return report, err
+			}
+			chunk = chunk[:0]
+		}
+	}
+}
+
+// importChunk stores lines in one transaction, recording their outcomes in
+// report.
+func (f *pageFetcher) importChunk(lines []importLine, report *importReport) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	items := make([]StockItem, len(lines))
+	for i, l := range lines {
+		items[i] = l.Item
+	}
+	if err := f.prefetch(items); err != nil {
+		return err
+	}
+
+	tx, err := f.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin import: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt := tx.Stmt(f.prep)
+	var counts fetchSummary
+	var errs []*lineError
+	var stored []StockItem
+	for i := range items {
+		outcome, itemErr, err := upsertItem(tx, stmt, &items[i], f.lookups())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lines[i].Line, err)
+		}
+		if itemErr != nil {
+			errs = append(errs, &lineError{lines[i].Line, itemErr})
+			counts.Failed++
+			continue
+		}
+		counts.record(outcome)
+		stored = append(stored, items[i])
+	}
+	if err := syncSecurities(tx, stored); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit import: %w", err)
+	}
+	report.add(counts)
+	report.Errors = append(report.Errors, errs...)
+	return nil
+}
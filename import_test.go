@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAll drains r, keeping line errors apart from items.
+func readAll(t *testing.T, r importReader) ([]importLine, []string) {
+	t.Helper()
+	var lines []importLine
+	var errs []string
+	for {
+		l, err := r.Next()
+		if err == io.EOF {
+			return lines, errs
+		}
+		if err != nil {
+			var lerr *lineError
+			require.ErrorAs(t, err, &lerr, "only line errors are recoverable")
+			errs = append(errs, err.Error())
+			continue
+		}
+		lines = append(lines, l)
+	}
+}
+
+func TestJSONLReader(t *testing.T) {
+	lines, errs := readAll(t, newJSONLReader(strings.NewReader(
+		`{"ticker":"AAA","time":"2025-01-13T00:00:00Z"}`+"\n"+
+			"\n"+
+			`{"items":[{"ticker":"BBB"},{"ticker":"CCC"}],"next_page":"x"}`+"\n"+
+			`{"ticker":`+"\n"+
+			`{"ticker":"DDD"}`)))
+
+	var got []string
+	for _, l := range lines {
+		got = append(got, l.Item.Ticker)
+	}
+	assert.Equal(t, []string{"AAA", "BBB", "CCC", "DDD"}, got)
+	assert.Equal(t, []int{1, 3, 3, 5}, []int{lines[0].Line, lines[1].Line, lines[2].Line, lines[3].Line})
+	assert.Equal(t, `{"ticker":"BBB"}`, string(lines[1].Item.raw), "page items keep their raw JSON")
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0], "line 4: decoding JSON")
+}
+
+func TestJSONReader(t *testing.T) {
+	r, err := newJSONReader(strings.NewReader(`[
+  {
+    "ticker": "AAA",
+    "time": "2025-01-13T00:00:00Z"
+  },
+  {"items": [{"ticker": "BBB"}, {"ticker": "CCC"}], "next_page": "x"},
+  {"ticker": 5},
+  "DDD",
+  {"ticker": "EEE"}
+]`))
+	require.NoError(t, err)
+	lines, errs := readAll(t, r)
+	var got []string
+	var at []int
+	for _, l := range lines {
+		got, at = append(got, l.Item.Ticker), append(at, l.Line)
+	}
+	assert.Equal(t, []string{"AAA", "BBB", "CCC", "EEE"}, got)
+	assert.Equal(t, []int{2, 6, 6, 9}, at, "numbered by the line each element starts on")
+	require.Len(t, errs, 2)
+	assert.Contains(t, errs[0], "line 7: decoding item")
+	assert.Contains(t, errs[1], "line 8: decoding JSON")
+
+	r, err = newJSONReader(strings.NewReader(`{"items": [{"ticker": "AAA"}], "next_page": ""}`))
+	require.NoError(t, err)
+	lines, errs = readAll(t, r)
+	require.Len(t, lines, 1, "a single page")
+	assert.Equal(t, "AAA", lines[0].Item.Ticker)
+	assert.Empty(t, errs)
+
+	_, err = newJSONReader(strings.NewReader(`"AAA"`))
+	assert.ErrorContains(t, err, "use -format=jsonl")
+	r, err = newJSONReader(strings.NewReader(`[{"ticker": "AAA"}, {"ticker":`))
+	require.NoError(t, err)
+	_, err = r.Next()
+	require.NoError(t, err)
+	_, err = r.Next()
+	var lerr *lineError
+	assert.False(t, errors.As(err, &lerr), "a truncated document stops the import: %v", err)
+}
+
+func TestCSVReader_ColumnMapping(t *testing.T) {
+	mapping, err := parseColumnMapping("ticker=Symbol, target_to=PT New ,time=Date")
+	require.NoError(t, err)
+	r, err := newCSVReader(strings.NewReader(
+		"Symbol,Brokerage,PT New,Date,Notes\n"+
+			"AAA,Brok,$5,2025-01-13T00:00:00Z,ignored\n"+
+			"BBB,Brok\n"+
+			"\"CCC\",\"Multi\nLine\",$6,2025-01-14T00:00:00Z,\n"), mapping)
+	require.NoError(t, err)
+	lines, errs := readAll(t, r)
+	require.Len(t, lines, 2)
+	assert.Equal(t, StockItem{Ticker: "AAA", Brokerage: "Brok", TargetTo: "$5", Time: "2025-01-13T00:00:00Z"}, lines[0].Item)
+	assert.Equal(t, 2, lines[0].Line)
+	assert.Equal(t, "Multi\nLine", lines[1].Item.Brokerage)
+	assert.Equal(t, 4, lines[1].Line)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0], "line 3: 2 columns")
+
+	_, err = newCSVReader(strings.NewReader("Symbol,Date\n"), map[string]string{"ticker": "Ticker"})
+	assert.ErrorContains(t, err, `no column "Ticker" for ticker`)
+	_, err = newCSVReader(strings.NewReader("ticker,when\n"), nil)
+	assert.ErrorContains(t, err, "no time column")
+	_, err = parseColumnMapping("isin=ISIN")
+	assert.ErrorContains(t, err, `unknown field "isin"`)
+	_, err = parseColumnMapping("ticker")
+	assert.ErrorContains(t, err, "use field=Column")
+}
+
+func TestImportFormat(t *testing.T) {
+	for path, want := range map[string]string{"dump.jsonl": "jsonl", "DUMP.CSV": "csv", "pages.json": "json"} {
+		got, err := importFormat(path, "")
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, path)
+	}
+	got, err := importFormat("dump.txt", "CSV")
+	assert.NoError(t, err)
+	assert.Equal(t, "csv", got)
+	_, err = importFormat("dump.txt", "")
+	assert.Error(t, err)
+}
+
+func TestImportItems_SQLite(t *testing.T) {
+	db := openTestSQLite(t)
+	prep, err := db.Prepare(sqliteDialect.InsertStmt)
+	require.NoError(t, err)
+	defer prep.Close()
+	res, err := loadBrokerageResolver(db)
+	require.NoError(t, err)
+	f := &pageFetcher{db: db, prep: prep, prices: newPriceCache(testPrices.CurrentPrice), brokerages: res}
+
+	const dump = "ticker,company,brokerage,action,rating_from,rating_to,target_from,target_to,time\n" +
+		"TCK,Tick,Goldman Sachs,upgraded by,Hold,Buy,$1,$2,2025-01-13T00:00:00Z\n" +
+		"TCK,Tick,Barclays,reiterated by,Buy,Buy,TBD,$2,2025-01-13T00:00:00Z\n" +
+		"TCK,Tick,UBS,initiated by,,Buy,,$3,yesterday\n" +
+		"NEW,New Co,UBS,initiated by,,Buy,,€3,2025-01-14T00:00:00Z\n"
+	r, err := newCSVReader(strings.NewReader(dump), nil)
+	require.NoError(t, err)
+	report, err := importItems(f, r)
+	require.NoError(t, err)
+	assert.Equal(t, fetchSummary{Inserted: 2, Failed: 2}, report.fetchSummary)
+	require.Len(t, report.Errors, 2)
+	assert.Equal(t, 3, report.Errors[0].Line)
+	assert.Contains(t, report.Errors[0].Error(), `parsing TargetFrom "TBD"`)
+	assert.Equal(t, 4, report.Errors[1].Line)
+	assert.Contains(t, report.Errors[1].Error(), `parsing Time "yesterday"`)
+
+	repo := newSQLiteRepository(db)
+	latest, err := repo.LatestForTicker("TCK")
+	require.NoError(t, err)
+	assert.Equal(t, actionUpgrade, latest.ActionType)
+	assert.NotNil(t, latest.BrokerageID, "brokerages are resolved like a fetch")
+	assert.Equal(t, 10.0, *latest.CurrentPrice)
+	sec, err := repo.Security("NEW")
+	require.NoError(t, err)
+	assert.Equal(t, "New Co", sec.Company)
+
+	// Importing the same dump again changes nothing
+	r, err = newCSVReader(strings.NewReader(dump), nil)
+	require.NoError(t, err)
+	report, err = importItems(f, r)
+	require.NoError(t, err)
+	assert.Equal(t, fetchSummary{Unchanged: 2, Failed: 2}, report.fetchSummary)
+}
@@ -3,23 +3,22 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
-const ()
-
 // enableCors wraps an http.Handler to add CORS headers
 func enableCors(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -36,15 +35,52 @@ func enableCors(h http.Handler) http.Handler {
 }
 
 type StockItem struct {
-	Ticker     string `json:"ticker"`
-	Company    string `json:"company"`
-	Brokerage  string `json:"brokerage"`
-	Action     string `json:"action"`
+	Ticker    string `json:"ticker"`
+	Company   string `json:"company"`
+	Brokerage string `json:"brokerage"`
+	Action    string `json:"action"`
+	// Classified Action, only set on API responses
+	ActionType string `json:"action_type,omitempty"`
 	RatingFrom string `json:"rating_from"`
 	RatingTo   string `json:"rating_to"`
 	TargetFrom string `json:"target_from"` // e.g. "$4.20"
 	TargetTo   string `json:"target_to"`   // e.g. "$4.70"
 	Time       string `json:"time"`        // e.g. "2025-01-13T00:30:05.813548892Z"
+	// ISO code of bare target amounts: upstream omits it (USD); API
+	// responses set it and render the targets as plain numbers.
+	Currency string `json:"currency,omitempty"`
+	// Canonical brokerage and rating levels, only set on API responses
+	BrokerageID         *int64 `json:"brokerage_id,omitempty"`
+	RatingFromCanonical string `json:"rating_from_canonical,omitempty"`
+	RatingToCanonical   string `json:"rating_to_canonical,omitempty"`
+
+	// Stored prices, only set on API responses
+	CurrentPrice  *float64 `json:"current_price,omitempty"`
+	PriceAtRating *float64 `json:"price_at_rating,omitempty"` // close as of Time
+
+	// Reference data of Ticker, only set on API responses
+	Security *Security `json:"security,omitempty"`
+
+	raw json.RawMessage // as decoded, kept for rejected_items
+}
+
+// UnmarshalJSON decodes the item and keeps its original bytes.
+func (s *StockItem) UnmarshalJSON(b []byte) error {
+	type plain StockItem
+	if err := json.Unmarshal(b, (*plain)(s)); err != nil {
+		return err
+	}
+	s.raw = append(json.RawMessage(nil), b...)
+	return nil
+}
+
+// rawJSON returns the item as it was decoded, or re-encoded when it was
+// built in code.
+func (s *StockItem) rawJSON() ([]byte, error) {
+	if s.raw != nil {
+		return s.raw, nil
+	}
+	return json.Marshal(s)
 }
 
 type APIResponse struct {
@@ -52,28 +88,77 @@ type APIResponse struct {
 	NextPage string      `json:"next_page"`
 }
 
+// insertStmt upserts a rating on its natural key (the stock_info_rating_key
+// unique index).
 var insertStmt = `
 		INSERT INTO stock_info (
 		ticker, company, brokerage, action,
 		rating_from, rating_to, target_from, target_to,
-		time, current_price
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		time, current_price, price_at_rating, price_updated_at, target_currency,
+		rating_from_canonical, rating_to_canonical, brokerage_id, action_type
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)` + upsertClause
+
+// upsertClause resolves conflicts on the rating key for insertStmt and the
+// COPY merge. The RETURNING clause yields true for a fresh insert and false
+// for an update; when the stored row already matches, the WHERE clause skips
+// the update and no row is returned.
+const upsertClause = `
+		ON CONFLICT (ticker, brokerage, time, rating_to, target_to) DO UPDATE SET
+			company         = EXCLUDED.company,
+			action          = EXCLUDED.action,
+			rating_from     = EXCLUDED.rating_from,
+			target_from     = EXCLUDED.target_from,
+			target_currency = EXCLUDED.target_currency,
+			rating_from_canonical = EXCLUDED.rating_from_canonical,
+			rating_to_canonical   = EXCLUDED.rating_to_canonical,
+			brokerage_id    = EXCLUDED.brokerage_id,
+			action_type     = EXCLUDED.action_type,
+			price_at_rating = COALESCE(stock_info.price_at_rating, EXCLUDED.price_at_rating)
+		WHERE (stock_info.company, stock_info.action, stock_info.rating_from, stock_info.target_from, stock_info.target_currency,
+				stock_info.rating_from_canonical, stock_info.rating_to_canonical, stock_info.brokerage_id, stock_info.action_type)
+			IS DISTINCT FROM (EXCLUDED.company, EXCLUDED.action, EXCLUDED.rating_from, EXCLUDED.target_from, EXCLUDED.target_currency,
+				EXCLUDED.rating_from_canonical, EXCLUDED.rating_to_canonical, EXCLUDED.brokerage_id, EXCLUDED.action_type)
+			OR (stock_info.price_at_rating IS NULL AND EXCLUDED.price_at_rating IS NOT NULL)
+		RETURNING (xmax = 0) AS inserted
 	`
-var ratingScore = map[string]int{
-	"Strong-Buy":        2,
-	"Outperform":        2,
-	"Market Outperform": 2,
-	"Sector Outperform": 2,
-	"Buy":               1,
-	"Overweight":        1,
-	"Equal Weight":      0,
-	"Market Perform":    0,
-	"Sector Perform":    0,
-	"Hold":              0,
-	"Unchanged":         0,
-	"Underweight":       -1,
-	"Sell":              -1,
-	"Underperform":      -2,
+
+// upsertOutcome tells what insertStockItem did with a rating.
+type upsertOutcome int
+
+const (
+	outcomeInserted upsertOutcome = iota
+	outcomeUpdated
+	outcomeUnchanged
+)
+
+// errExecInsert marks insertStockItem failures coming from the database rather
+// than from parsing the item.
+var errExecInsert = errors.New("exec insert")
+
+// fetchSummary accumulates per-run upsert counts.
+type fetchSummary struct {
+	Inserted  int
+	Updated   int
+	Unchanged int
+	Failed    int
+}
+
+func (s *fetchSummary) record(o upsertOutcome) {
+	switch o {
+	case outcomeInserted:
+		s.Inserted++
+	case outcomeUpdated:
+		s.Updated++
+	case outcomeUnchanged:
+		s.Unchanged++
+	}
+}
+
+func (s *fetchSummary) add(o fetchSummary) {
+	s.Inserted += o.Inserted
+	s.Updated += o.Updated
+	s.Unchanged += o.Unchanged
+	s.Failed += o.Failed
 }
 
 var APIEndpoint, BearerToken, DBConnString = "", "", ""
@@ -81,14 +166,21 @@ var APIEndpoint, BearerToken, DBConnString = "", "", ""
 type RecResult struct {
 	Ticker       string  `json:"ticker"`
 	Company      string  `json:"company"`
+	Sector       string  `json:"sector,omitempty"`
 	Brokerage    string  `json:"brokerage"`
+	ActionType   string  `json:"action_type,omitempty"`
 	RatingFrom   string  `json:"rating_from"`
 	RatingTo     string  `json:"rating_to"`
 	TargetFrom   float64 `json:"target_from"`
 	TargetTo     float64 `json:"target_to"`
+	Currency     string  `json:"currency"` // of the targets; prices are in the listing currency
 	CurrentPrice float64 `json:"current_price"`
-	UpsidePct    float64 `json:"upside_pct"`
-	Composite    float64 `json:"composite"`
+	// Close on the rating date; UpsidePct is measured against it when known
+	PriceAtRating *float64 `json:"price_at_rating,omitempty"`
+	UpsidePct     float64  `json:"upside_pct"`
+	Composite     float64  `json:"composite"`
+	// When CurrentPrice was last refreshed
+	PriceUpdatedAt *time.Time `json:"price_updated_at,omitempty"`
 }
 
 func main() {
@@ -101,50 +193,468 @@ func main() {
 	BearerToken = os.Getenv("BEARER_TOKEN")
 	DBConnString = os.Getenv("DB_CONN_STRING")
 
-	mode := flag.String("mode", "serve", "Mode to run: 'fetch' to load data, 'serve' to start HTTP API")
+	mode := flag.String("mode", "serve", "Mode to run: 'migrate' to apply schema migrations, 'fetch' to load data, 'prices' to backfill daily price history, 'refresh-prices' to re-price stored tickers, 'reprocess-rejects' to retry rejected items, 'load-fx' to load FX rates from -file, 'merge-brokerage' to fold brokerage -from into -into, 'load-securities' to load the securities reference CSV from -file, 'import' to store ratings from a JSONL, JSON or CSV -file, 'export' to write the ratings matching -query to -file as CSV, JSONL or Parquet, 'fake-upstream' to serve a local ratings API and Yahoo chart endpoint, 'seed' to store generated ratings, securities and prices, 'serve' to start HTTP API")
+	resume := flag.Bool("resume", false, "With -mode=fetch, continue the last unfinished run from its checkpoint")
+	incremental := flag.Bool("incremental", false, "With -mode=fetch, stop paging once ratings are older than the last stored watermark")
+	priceProvider := flag.String("price-provider", os.Getenv("PRICE_PROVIDER"), "Comma-separated price sources tried in order: 'yahoo' (default), 'stooq', 'file:<path>'; 'yahoo:<url>' or 'stooq:<url>' call another host")
+	since := flag.String("since", "", "With -mode=prices, backfill from this date (YYYY-MM-DD) instead of each ticker's first rating")
+	priceWorkers := flag.Int("price-workers", 8, "With -mode=fetch or refresh-prices, number of concurrent price lookups")
+	batchSize := flag.Int("batch-size", 500, "With -mode=fetch on Postgres, ratings per COPY batch (0 upserts row by row)")
+	direction := flag.String("direction", "up", "With -mode=migrate: 'up', 'down' or 'status'")
+	steps := flag.Int("steps", 0, "With -mode=migrate, number of migrations to apply (0 = all pending) or roll back (default 1)")
+	file := flag.String("file", "", "With -mode=load-fx, CSV of currency,usd_rate rows; with -mode=load-securities, CSV with a ticker,company,exchange,sector,industry,currency,active header; with -mode=import, JSONL or a JSON array of items or pages, or CSV; with -mode=export, the output (default stdout); with -mode=fake-upstream, the items to serve (default: generated)")
+	format := flag.String("format", "", "With -mode=import, 'jsonl', 'json' or 'csv'; with -mode=export, 'csv', 'jsonl' or 'parquet' (default: from the -file extension)")
+	query := flag.String("query", "", "With -mode=export, /stocks query parameters selecting and ordering the ratings, e.g. 'sector=Technology&sort=time&order=desc'")
+	columns := flag.String("columns", "", "With -mode=import of a CSV, field=Column pairs mapping item fields to header names, e.g. 'ticker=Symbol,target_to=PT'")
+	addr := flag.String("addr", ":8090", "With -mode=fake-upstream, address to listen on")
+	pageSize := flag.Int("page-size", 20, "With -mode=fake-upstream, items per ratings page")
+	faults := flag.String("faults", "", "With -mode=fake-upstream, kind=rate pairs of injected failures, e.g. '429=0.1,timeout=0.05,malformed=0.02'")
+	faultDelay := flag.Duration("fault-delay", 35*time.Second, "With -mode=fake-upstream, how long a 'timeout' fault stalls the response")
+	seed := flag.Uint64("seed", 1, "With -mode=fake-upstream or seed, seed of the generated items (and of the injected failures)")
+	tickers := flag.Int("tickers", 50, "With -mode=fake-upstream or seed, tickers of the generated items")
+	ratingsCount := flag.Int("ratings", 1000, "With -mode=fake-upstream or seed, number of generated items")
+	mergeFrom := flag.Int64("from", 0, "With -mode=merge-brokerage, id of the brokerage to merge away")
+	mergeInto := flag.Int64("into", 0, "With -mode=merge-brokerage, id of the brokerage to keep")
+	record := flag.String("record", "", "Record every ratings API and price request to this JSONL cassette, e.g. with -mode=fetch")
+	replay := flag.String("replay", "", "Answer ratings API and price requests from this cassette instead of the network")
+	migrateOnStart := flag.Bool("migrate-on-start", os.Getenv("MIGRATE_ON_START") == "true", "With -mode=serve, apply pending migrations before listening")
+	upstream := defaultUpstreamConfig
+	flag.DurationVar(&upstream.Timeout, "upstream-timeout", upstream.Timeout, "Per-request timeout for the ratings API")
+	flag.IntVar(&upstream.MaxRetries, "upstream-retries", upstream.MaxRetries, "Retries on 429/5xx or network errors from the ratings API")
+	flag.DurationVar(&upstream.BaseBackoff, "upstream-backoff", upstream.BaseBackoff, "Initial retry backoff for the ratings API, doubled per attempt")
+	flag.DurationVar(&upstream.MaxBackoff, "upstream-max-backoff", upstream.MaxBackoff, "Maximum retry backoff for the ratings API")
+	flag.DurationVar(&upstream.MaxRetryAfter, "upstream-max-retry-after", upstream.MaxRetryAfter, "Longest Retry-After delay honoured from the ratings API")
+	flag.Float64Var(&upstream.RatePerSec, "upstream-rate", upstream.RatePerSec, "Maximum ratings API requests per second (0 disables)")
+	flag.IntVar(&upstream.Burst, "upstream-burst", upstream.Burst, "Burst size for the ratings API rate limit")
 	flag.Parse()
-	// Open DB connection
-	db, err := sql.Open("postgres", DBConnString)
+	switch {
+	case *record != "" && *replay != "":
+		log.Fatal("-record and -replay are exclusive")
+	case *record != "":
+		rec, err := recordCassette(*record, http.DefaultTransport)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer rec.Close()
+		httpTransport = rec
+		log.Printf("Recording HTTP exchanges to %s", *record)
+	case *replay != "":
+		player, err := openCassette(*replay)
+		if err != nil {
+			log.Fatal(err)
+		}
+		httpTransport = player
+		log.Printf("Replaying HTTP exchanges from %s", *replay)
+	}
+	// Open DB connection; the scheme picks Postgres or SQLite
+	db, d, err := openDatabase(DBConnString)
 	if err != nil {
 		log.Fatalf("DB open error: %v", err)
 	}
 	defer db.Close()
 
 	switch *mode {
+	case "migrate":
+		executeMigrate(db, d, *direction, *steps)
 	case "fetch":
-		executeFetch(db)
+		prices, err := newPriceProvider(*priceProvider)
+		if err != nil {
+			log.Fatalf("Price provider error: %v", err)
+		}
+		executeFetch(db, d, fetchOptions{
+			Resume:       *resume,
+			Incremental:  *incremental,
+			PriceWorkers: *priceWorkers,
+			BatchSize:    *batchSize,
+			Upstream:     upstream,
+			Prices:       prices,
+		})
+	case "prices":
+		prices, err := newPriceProvider(*priceProvider)
+		if err != nil {
+			log.Fatalf("Price provider error: %v", err)
+		}
+		var sinceDate time.Time
+		if *since != "" {
+			if sinceDate, err = time.Parse(time.DateOnly, *since); err != nil {
+				log.Fatalf("Invalid -since %q: %v", *since, err)
+			}
+		}
+		executePrices(db, prices, sinceDate)
+	case "refresh-prices":
+		prices, err := newPriceProvider(*priceProvider)
+		if err != nil {
+			log.Fatalf("Price provider error: %v", err)
+		}
+		executeRefreshPrices(db, prices, *priceWorkers)
+	case "reprocess-rejects":
+		prices, err := newPriceProvider(*priceProvider)
+		if err != nil {
+			log.Fatalf("Price provider error: %v", err)
+		}
+		executeReprocessRejects(db, d, prices)
+	case "load-fx":
+		executeLoadFX(db, *file)
+	case "merge-brokerage":
+		executeMergeBrokerage(db, *mergeFrom, *mergeInto)
+	case "load-securities":
+		executeLoadSecurities(db, *file)
+	case "import":
+		prices, err := newPriceProvider(*priceProvider)
+		if err != nil {
+			log.Fatalf("Price provider error: %v", err)
+		}
+		executeImport(db, d, prices, *file, *format, *columns, *priceWorkers)
+	case "export":
+		executeExport(d.repository(db), *file, *format, *query)
+	case "fake-upstream":
+		executeFakeUpstream(*addr, *file, *format, *columns, fakeOptions{
+			PageSize: *pageSize, Faults: *faults, FaultDelay: *faultDelay,
+			Seed: *seed, Tickers: *tickers, Ratings: *ratingsCount,
+		})
+	case "seed":
+		executeSeed(db, d, *seed, *tickers, *ratingsCount)
 	case "serve":
-		startServer(db)
+		prices, err := newPriceProvider(*priceProvider)
+		if err != nil {
+			log.Fatalf("Price provider error: %v", err)
+		}
+		if *migrateOnStart {
+			executeMigrate(db, d, "up", 0)
+		}
+		startServer(d.repository(db), newPriceRefresher(db, prices, *priceWorkers))
+	default:
+		log.Fatalf("Unknown mode '%s'; use 'migrate', 'fetch', 'prices', 'refresh-prices', 'reprocess-rejects', 'load-fx', 'merge-brokerage', 'load-securities', 'import', 'export', 'fake-upstream', 'seed' or 'serve'", *mode)
+	}
+}
+func executeMigrate(db *sql.DB, d dialect, direction string, steps int) {
+	migs, err := embeddedMigrations(d)
+	if err != nil {
+		log.Fatalf("Load migrations error: %v", err)
+	}
+
+	switch direction {
+	case "up":
+		done, err := migrateUp(db, d, migs, steps)
+		if err != nil {
+			log.Fatalf("Migrate up error: %v", err)
+		}
+		log.Printf("Schema up to date (%d migrations applied)", len(done))
+	case "down":
+		if steps < 1 {
+			steps = 1
+		}
+		done, err := migrateDown(db, d, migs, steps)
+		if err != nil {
+			log.Fatalf("Migrate down error: %v", err)
+		}
+		log.Printf("Rolled back %d migrations", len(done))
+	case "status":
+		applied, err := migrationStatus(db, d, migs)
+		if err != nil {
+			log.Fatalf("Migration status error: %v", err)
+		}
+		for _, mig := range migs {
+			state := "pending"
+			if applied[mig.Version] {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", mig.Version, mig.Name, state)
+		}
 	default:
-		log.Fatalf("Unknown mode '%s'; use 'fetch' or 'serve'", *mode)
+		log.Fatalf("Unknown -direction '%s'; use 'up', 'down' or 'status'", direction)
 	}
 }
-func executeFetch(db *sql.DB) {
+func executeFetch(db *sql.DB, d dialect, opts fetchOptions) {
 	log.Println("Starting data fetch...")
 	// Prepare statement
-	prep, err := db.Prepare(insertStmt)
+	prep, err := db.Prepare(d.InsertStmt)
 	if err != nil {
 		log.Fatalf("Prepare insert error: %v", err)
 	}
 	defer prep.Close()
+	if opts.Ratings, err = queryRatingTaxonomy(db); err != nil {
+		log.Fatalf("Rating taxonomy error: %v", err)
+	}
+	if opts.Brokerages, err = loadBrokerageResolver(db); err != nil {
+		log.Fatalf("Brokerage aliases error: %v", err)
+	}
+	// Ratings stored before brokerages existed get their ids first
+	if n, err := backfillBrokerageIDs(db, opts.Brokerages); err != nil {
+		log.Fatalf("Brokerage backfill error: %v", err)
+	} else if n > 0 {
+		log.Printf("Resolved the brokerage of %d stored ratings", n)
+	}
+	if !d.CopyIn && opts.BatchSize > 0 {
+		log.Printf("%s has no COPY; upserting row by row", d.Driver)
+		opts.BatchSize = 0
+	}
 
 	// Load all pages
-	if err := fetchAndStoreAllPages(db, prep); err != nil {
+	if _, err := fetchAndStoreAllPages(db, prep, opts); err != nil {
 		log.Fatalf("Fetch/store error: %v", err)
 	}
 	log.Println("Data fetch complete.")
 }
-func startServer(db *sql.DB) {
+func executePrices(db *sql.DB, prices PriceProvider, since time.Time) {
+	log.Println("Starting price history backfill...")
+	hp, ok := prices.(HistoryProvider)
+	if !ok {
+		log.Fatalf("Price provider %s has no daily history", prices.Name())
+	}
+
+	n, err := backfillPriceHistory(db, hp, since, time.Now())
+	if err != nil {
+		log.Fatalf("Backfill error: %v", err)
+	}
+	log.Printf("Price history backfill complete: %d bars stored.", n)
+}
+func executeRefreshPrices(db *sql.DB, prices PriceProvider, workers int) {
+	log.Println("Starting price refresh...")
+
+	summary, err := refreshPrices(db, prices, workers, time.Now())
+	if err != nil {
+		log.Fatalf("Price refresh error: %v", err)
+	}
+	log.Printf("Price refresh complete: %d tickers, %d updated, %d failed.", summary.Tickers, summary.Updated, summary.Failed)
+}
+func executeReprocessRejects(db *sql.DB, d dialect, prices PriceProvider) {
+	log.Println("Reprocessing rejected items...")
+	prep, err := db.Prepare(d.InsertStmt)
+	if err != nil {
+		log.Fatalf("Prepare insert error: %v", err)
+	}
+	defer prep.Close()
+
+	taxonomy, err := queryRatingTaxonomy(db)
+	if err != nil {
+		log.Fatalf("Rating taxonomy error: %v", err)
+	}
+	brokerages, err := loadBrokerageResolver(db)
+	if err != nil {
+		log.Fatalf("Brokerage aliases error: %v", err)
+	}
+	f := &pageFetcher{prices: newPriceCache(prices.CurrentPrice), ratings: taxonomy, brokerages: brokerages}
+	if hp, ok := prices.(HistoryProvider); ok {
+		f.atRating = newRatingPriceCache(hp)
+	}
+	summary, err := reprocessRejects(db, prep, f.lookups())
+	if err != nil {
+		log.Fatalf("Reprocess error: %v", err)
+	}
+	log.Printf("Reprocess complete: %d inserted, %d updated, %d unchanged, %d still rejected",
+		summary.Inserted, summary.Updated, summary.Unchanged, summary.Failed)
+}
+func executeLoadFX(db *sql.DB, path string) {
+	if path == "" {
+		log.Fatal("-mode=load-fx needs -file")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Open FX file error: %v", err)
+	}
+	defer f.Close()
+
+	rates, err := loadFXRatesCSV(f)
+	if err != nil {
+		log.Fatalf("Load FX rates error: %v", err)
+	}
+	if err := storeFXRates(db, rates); err != nil {
+		log.Fatalf("Store FX rates error: %v", err)
+	}
+	log.Printf("Loaded %d FX rates from %s", len(rates), path)
+}
+func executeMergeBrokerage(db *sql.DB, from, into int64) {
+	if from == 0 || into == 0 {
+		log.Fatal("-mode=merge-brokerage needs -from and -into")
+	}
+	if err := mergeBrokerage(db, from, into); err != nil {
+		log.Fatalf("Merge brokerage error: %v", err)
+	}
+	log.Printf("Merged brokerage %d into %d", from, into)
+}
+func executeLoadSecurities(db *sql.DB, path string) {
+	if path == "" {
+		log.Fatal("-mode=load-securities needs -file")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Open securities file error: %v", err)
+	}
+	defer f.Close()
+
+	secs, err := loadSecuritiesCSV(f)
+	if err != nil {
+		log.Fatalf("Load securities error: %v", err)
+	}
+	if err := storeSecurities(db, secs); err != nil {
+		log.Fatalf("Store securities error: %v", err)
+	}
+	log.Printf("Loaded %d securities from %s", len(secs), path)
+}
+func executeImport(db *sql.DB, d dialect, prices PriceProvider, path, format, columns string, workers int) {
+	if path == "" {
+		log.Fatal("-mode=import needs -file")
+	}
+	format, err := importFormat(path, format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Open import file error: %v", err)
+	}
+	defer in.Close()
+
+	var r importReader
+	switch format {
+	case "csv":
+		mapping, err := parseColumnMapping(columns)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if r, err = newCSVReader(in, mapping); err != nil {
+			log.Fatalf("Import error: %v", err)
+		}
+	case "json":
+		if r, err = newJSONReader(in); err != nil {
+			log.Fatalf("Import error: %v", err)
+		}
+	default:
+		r = newJSONLReader(in)
+	}
+
+	prep, err := db.Prepare(d.InsertStmt)
+	if err != nil {
+		log.Fatalf("Prepare insert error: %v", err)
+	}
+	defer prep.Close()
+	f := &pageFetcher{db: db, prep: prep, prices: newPriceCache(prices.CurrentPrice), workers: workers}
+	if f.ratings, err = queryRatingTaxonomy(db); err != nil {
+		log.Fatalf("Rating taxonomy error: %v", err)
+	}
+	if f.brokerages, err = loadBrokerageResolver(db); err != nil {
+		log.Fatalf("Brokerage aliases error: %v", err)
+	}
+	if hp, ok := prices.(HistoryProvider); ok {
+		f.atRating = newRatingPriceCache(hp)
+	}
+
+	log.Printf("Importing %s as %s...", path, format)
+	report, err := importItems(f, r)
+	for _, lerr := range report.Errors {
+		log.Printf("%s: %v", path, lerr)
+	}
+	log.Printf("Import summary: %d inserted, %d updated, %d unchanged, %d failed",
+		report.Inserted, report.Updated, report.Unchanged, report.Failed)
+	if err != nil {
+		log.Fatalf("Import error: %v", err)
+	}
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+func executeExport(repo StockRepository, path, format, query string) {
+	format, err := exportFormat(path, format)
+	if err != nil {
+		log.Fatal(err)
+	}
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		log.Fatalf("Invalid -query: %v", err)
+	}
+	f, err := ratingFilterFromQuery(q)
+	if err != nil {
+		log.Fatalf("Invalid -query: %v", err)
+	}
+
+	out := os.Stdout
+	if path != "" {
+		if out, err = os.Create(path); err != nil {
+			log.Fatalf("Create export file error: %v", err)
+		}
+	}
+	w, err := newExportWriter(out, format)
+	if err != nil {
+		log.Fatalf("Export error: %v", err)
+	}
+	n, err := exportRatings(repo, f, w)
+	if err != nil {
+		log.Fatalf("Export error after %d ratings: %v", n, err)
+	}
+	if path != "" {
+		if err := out.Close(); err != nil {
+			log.Fatalf("Close export file error: %v", err)
+		}
+	}
+	log.Printf("Exported %d ratings as %s", n, format)
+}
+func executeSeed(db *sql.DB, d dialect, seed uint64, tickers, ratings int) {
+	log.Printf("Seeding %d ratings over %d tickers from seed %d...", ratings, tickers, seed)
+	report, err := seedSyntheticData(db, d, seed, tickers, ratings)
+	for _, lerr := range report.Errors {
+		log.Printf("seed: %v", lerr)
+	}
+	log.Printf("Seed summary: %d inserted, %d updated, %d unchanged, %d failed; %d securities, %d daily bars",
+		report.Inserted, report.Updated, report.Unchanged, report.Failed, report.Securities, report.Bars)
+	if err != nil {
+		log.Fatalf("Seed error: %v", err)
+	}
+}
+func executeFakeUpstream(addr, path, format, columns string, opts fakeOptions) {
+	faults, err := parseFaults(opts.Faults, opts.FaultDelay)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var items []StockItem
+	if path == "" {
+		if err := checkSyntheticSize(opts.Tickers, opts.Ratings); err != nil {
+			log.Fatal(err)
+		}
+		items = syntheticItems(opts.Seed, opts.Tickers, opts.Ratings)
+		log.Printf("Generated %d items over %d tickers with seed %d", len(items), opts.Tickers, opts.Seed)
+	} else if items, err = readFakeItems(path, format, columns); err != nil {
+		log.Fatalf("Fake upstream items error: %v", err)
+	}
+
+	fake := newFakeUpstream(items, opts.PageSize, BearerToken, faults, opts.Seed)
+	base := "http://localhost" + addr
+	if !strings.HasPrefix(addr, ":") {
+		base = "http://" + addr
+	}
+	log.Printf("Fake upstream on %s; fetch with API_ENDPOINT=%s/list -price-provider=yahoo:%s", addr, base, base)
+	log.Fatal(http.ListenAndServe(addr, fake.Handler()))
+}
+func startServer(repo StockRepository, refresher *priceRefresher) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/stocks", func(w http.ResponseWriter, r *http.Request) {
-		handleStocks(w, r, db)
+		handleStocks(w, r, repo)
 	})
 	mux.HandleFunc("/stocks/", func(w http.ResponseWriter, r *http.Request) {
-		handleStock(w, r, db)
+		handleStock(w, r, repo)
+	})
+	mux.HandleFunc("/export", func(w http.ResponseWriter, r *http.Request) {
+		handleExport(w, r, repo)
 	})
 	mux.HandleFunc("/recommend", func(w http.ResponseWriter, r *http.Request) {
-		handleRecommend(w, r, db)
+		handleRecommend(w, r, repo)
+	})
+	mux.HandleFunc("/ratings/unmapped", func(w http.ResponseWriter, r *http.Request) {
+		handleUnmappedRatings(w, r, repo)
+	})
+	mux.HandleFunc("/securities", func(w http.ResponseWriter, r *http.Request) {
+		handleSecurities(w, r, repo)
+	})
+	mux.HandleFunc("/securities/", func(w http.ResponseWriter, r *http.Request) {
+		handleSecurity(w, r, repo)
+	})
+	mux.HandleFunc("/brokerages", func(w http.ResponseWriter, r *http.Request) {
+		handleBrokerages(w, r, repo)
 	})
+	mux.HandleFunc("/brokerages/suggestions", func(w http.ResponseWriter, r *http.Request) {
+		handleBrokerageSuggestions(w, r, repo)
+	})
+	mux.Handle("/admin/refresh-prices", refresher)
 
 	addr := ":8081"
 
@@ -152,332 +662,459 @@ func startServer(db *sql.DB) {
 	handler := enableCors(mux)
 	log.Fatal(http.ListenAndServe(addr, handler))
 }
-func fetchAndStoreAllPages(db *sql.DB, prep *sql.Stmt) error {
 
-	nextKey := ""
-	for {
-		// Build URL (if nextKey is empty, call without query param)
-		url := APIEndpoint
-		if nextKey != "" {
-			url = APIEndpoint + "?next_page=" + nextKey
-		}
+// fetchOptions tunes a fetch run.
+type fetchOptions struct {
+	Resume       bool // continue the last unfinished run from its checkpoint
+	Incremental  bool // stop paging once a page is older than the stored watermark
+	PriceWorkers int  // concurrent price lookups per page
+	BatchSize    int  // ratings per COPY batch; 0 upserts row by row
+	Upstream     upstreamConfig
+	Prices       PriceProvider
+	Ratings      *ratingTaxonomy    // nil stores ratings unmapped
+	Brokerages   *brokerageResolver // nil stores ratings without brokerage_id
+}
 
-		// Perform HTTP GET
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return fmt.Errorf("creating request: %w", err)
-		}
-		req.Header.Set("Authorization", "Bearer "+BearerToken)
-		req.Header.Set("Content-Type", "application/json")
+// pageFetcher carries the per-run dependencies used while walking pages.
+type pageFetcher struct {
+	db         *sql.DB
+	prep       *sql.Stmt
+	client     *upstreamClient
+	prices     *priceCache
+	atRating   *priceCache // nil when the provider has no daily history
+	ratings    *ratingTaxonomy
+	brokerages *brokerageResolver
+	workers    int
+	batchSize  int
+	stopBefore time.Time // non-zero in incremental mode
+}
 
-		resp, err := http.DefaultClient.Do(req)
+// fetchAndStoreAllPages walks every upstream page and upserts its items,
+// logging and returning the inserted/updated/unchanged counts. Each page is
+// committed in one transaction together with its pagination checkpoint.
+func fetchAndStoreAllPages(db *sql.DB, prep *sql.Stmt, opts fetchOptions) (fetchSummary, error) {
+	source := APIEndpoint
+
+	// Upstream returns newest ratings first, so in incremental mode a page
+	// entirely older than the watermark means the rest is already stored.
+	var stopBefore time.Time
+	if opts.Incremental {
+		watermark, ok, err := loadWatermark(db, source)
 		if err != nil {
-			return fmt.Errorf("http request error: %w", err)
+			return fetchSummary{}, err
 		}
-		if resp.StatusCode != http.StatusOK {
-			resp.Body.Close()
-			return fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+		if ok {
+			log.Printf("Incremental fetch: stopping at ratings older than %s", watermark.Format(time.RFC3339))
+			stopBefore = watermark
+		}
+	}
+
+	run, err := startFetchRun(db, opts.Resume)
+	if err != nil {
+		return fetchSummary{}, err
+	}
+	if run.Page > 0 {
+		log.Printf("Resuming fetch run %d after page %d", run.ID, run.Page)
+	}
+
+	f := &pageFetcher{
+		db:         db,
+		prep:       prep,
+		client:     newUpstreamClient(opts.Upstream),
+		prices:     newPriceCache(opts.Prices.CurrentPrice),
+		workers:    opts.PriceWorkers,
+		batchSize:  opts.BatchSize,
+		stopBefore: stopBefore,
+		ratings:    opts.Ratings,
+		brokerages: opts.Brokerages,
+	}
+	if hp, ok := opts.Prices.(HistoryProvider); ok {
+		f.atRating = newRatingPriceCache(hp)
+	}
+
+	status := runCompleted
+	err = f.fetchRunPages(&run)
+	if err != nil {
+		status = runFailed
+	}
+	if ferr := finishFetchRun(db, run, status); ferr != nil {
+		log.Printf("warning: %v", ferr)
+	}
+	if err == nil {
+		if werr := advanceWatermark(db, source, run); werr != nil {
+			log.Printf("warning: %v", werr)
 		}
+	}
+
+	summary := run.Summary
+	log.Printf("Fetch summary: %d inserted, %d updated, %d unchanged, %d failed",
+		summary.Inserted, summary.Updated, summary.Unchanged, summary.Failed)
+	if unmapped := opts.Ratings.unmappedRatings(); len(unmapped) > 0 {
+		log.Printf("Unmapped ratings (add them to rating_aliases): %s", formatRatingCounts(unmapped))
+	}
+	return summary, err
+}
 
-		var apiResp APIResponse
-		if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-			resp.Body.Close()
-			return fmt.Errorf("decoding JSON: %w", err)
+// fetchRunPages pages through upstream starting at the run's cursor until
+// the API stops returning items or a next_page token. A non-zero stopBefore
+// ends paging at the first page whose ratings are all older than it.
+func (f *pageFetcher) fetchRunPages(run *fetchRun) error {
+	// An empty cursor after a committed page means upstream was exhausted
+	for run.Page == 0 || run.NextKey != "" {
+		apiResp, err := fetchPage(f.client, run.NextKey)
+		if err != nil {
+			return err
 		}
-		resp.Body.Close()
 
 		// If no items, we’re done
 		if len(apiResp.Items) == 0 {
 			break
 		}
 
-		// Insert each item
-		for _, item := range apiResp.Items {
-			if err := insertStockItem(prep, &item); err != nil {
-				log.Printf("warning: failed to insert ticker %s: %v", item.Ticker, err)
-			}
+		newest := newestItemTime(apiResp.Items)
+		if !f.stopBefore.IsZero() && newest.Valid && newest.Time.Before(f.stopBefore) {
+			log.Printf("Reached watermark after page %d; stopping", run.Page)
+			break
+		}
 
+		if err := f.prefetch(apiResp.Items); err != nil {
+			return err
+		}
+		if err := f.storePage(run, apiResp, newest); err != nil {
+			return fmt.Errorf("page %d: %w", run.Page+1, err)
 		}
 
 		// If next_page is empty, break; otherwise, loop again
 		if apiResp.NextPage == "" {
 			break
 		}
-		nextKey = apiResp.NextPage
 	}
-
 	return nil
 }
 
-// insertStockItem parses fields and executes the prepared INSERT statement.
-func insertStockItem(prep *sql.Stmt, item *StockItem) error {
-	// Parse the target_from string (strip "$")
-	var tf *float64
-	if item.TargetFrom != "" {
-		cleaned := strings.ReplaceAll(item.TargetFrom, ",", "")
-		cleaned = strings.TrimPrefix(cleaned, "$")
-		parsed, err := strconv.ParseFloat(cleaned, 64)
-		if err != nil {
-			return fmt.Errorf("parsing TargetFrom %q: %w", item.TargetFrom, err)
+// prefetch prices the items' distinct tickers in parallel and resolves their
+// brokerages, before the transaction that stores them is opened.
+func (f *pageFetcher) prefetch(items []StockItem) error {
+	tickers := make([]string, 0, len(items))
+	ratingKeys := make([]string, 0, len(items))
+	brokerages := make([]string, 0, len(items))
+	for _, item := range items {
+		tickers = append(tickers, item.Ticker)
+		brokerages = append(brokerages, item.Brokerage)
+		if t, err := time.Parse(time.RFC3339Nano, item.Time); err == nil {
+			ratingKeys = append(ratingKeys, ratingPriceKey(item.Ticker, t))
 		}
-		tf = &parsed
 	}
-	//  Parse the target_to string
-	var tt *float64
-	if item.TargetTo != "" {
-		cleaned := strings.ReplaceAll(item.TargetTo, ",", "")
-		cleaned = strings.TrimPrefix(cleaned, "$")
-		parsed, err := strconv.ParseFloat(cleaned, 64)
-		if err != nil {
-			return fmt.Errorf("parsing TargetTo %q: %w", item.TargetTo, err)
-		}
-		tt = &parsed
+	f.prices.Prefetch(tickers, f.workers)
+	if f.atRating != nil {
+		f.atRating.Prefetch(ratingKeys, f.workers)
 	}
+	// New brokerages are created outside the page transaction too
+	return f.brokerages.Resolve(brokerages)
+}
 
-	// Parse the raw time
-	parsedTime, err := time.Parse(time.RFC3339Nano, item.Time)
-	if err != nil {
-		return fmt.Errorf("parsing Time %q: %w", item.Time, err)
+// lookups exposes the run's price caches, rating taxonomy and brokerages to
+// insertStockItem.
+func (f *pageFetcher) lookups() ingestLookups {
+	l := ingestLookups{Current: f.prices.Get, Ratings: f.ratings, Brokerages: f.brokerages}
+	if f.atRating != nil {
+		l.AtRating = func(ticker string, t time.Time) (float64, error) {
+			return f.atRating.Get(ratingPriceKey(ticker, t))
+		}
 	}
+	return l
+}
 
-	cp, err := fetchCurrentPrice(item.Ticker)
-	if err != nil {
-		log.Printf("warning: no pude obtener precio para %s: %v", item.Ticker, err)
-		cp = 0.0
-	}
+// fetchPage requests one page of ratings from upstream through client.
+func fetchPage(client *upstreamClient, nextKey string) (APIResponse, error) {
+	var apiResp APIResponse
 
-	//  Execute the INSERT (using nil for DECIMAL columns if parsing failed or was empty)
-	_, err = prep.Exec(
-		item.Ticker,
-		item.Company,
-		item.Brokerage,
-		item.Action,
-		item.RatingFrom,
-		item.RatingTo,
-		tf,
-		tt,
-		parsedTime,
-		cp,
-	)
-	if err != nil {
-		return fmt.Errorf("exec insert: %w", err)
+	// Build URL (if nextKey is empty, call without query param)
+	url := APIEndpoint
+	if nextKey != "" {
+		url = APIEndpoint + "?next_page=" + nextKey
 	}
-	return nil
-}
-func fetchCurrentPrice(ticker string) (float64, error) {
-	url := fmt.Sprintf(
-		"https://query1.finance.yahoo.com/v8/finance/chart/%s?region=US&lang=en-US&includePrePost=false&interval=1d&range=1d",
-		ticker,
-	)
 
-	// 1) Creamos la petición con User-Agent
+	// Perform HTTP GET
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return 0, fmt.Errorf("crear request: %w", err)
+		return apiResp, fmt.Errorf("creating request: %w", err)
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; AcmeInc/1.0)")
+	req.Header.Set("Authorization", "Bearer "+BearerToken)
+	req.Header.Set("Content-Type", "application/json")
 
-	// 2) Ejecutamos con timeout
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return 0, fmt.Errorf("http get: %w", err)
+		return apiResp, fmt.Errorf("http request error: %w", err)
 	}
 	defer resp.Body.Close()
-
-	// 3) Si no es 200, devolvemos el body como error
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return 0, fmt.Errorf("status %d: %s", resp.StatusCode, string(bodyBytes))
+		return apiResp, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
 	}
 
-	// 4) Decodificamos JSON
-	var payload struct {
-		Chart struct {
-			Result []struct {
-				Meta struct {
-					RegularMarketPrice float64 `json:"regularMarketPrice"`
-				} `json:"meta"`
-			} `json:"result"`
-			Error interface{} `json:"error"`
-		} `json:"chart"`
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return apiResp, fmt.Errorf("decoding JSON: %w", err)
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		// leemos el body completo para diagnóstico
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return 0, fmt.Errorf("decode JSON: %w – body: %s", err, string(bodyBytes))
+	return apiResp, nil
+}
+
+// storePage upserts a page of items and its checkpoint in one transaction,
+// in COPY batches when a batch size is set. Items that fail to parse or that
+// the database refuses are moved to rejected_items; any other database error
+// rolls back the whole page so a resumed run picks it up again.
+func (f *pageFetcher) storePage(run *fetchRun, apiResp APIResponse, newest sql.NullTime) error {
+	start := time.Now()
+	tx, err := f.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin page: %w", err)
+	}
+	defer tx.Rollback()
+
+	var counts fetchSummary
+	var stored []StockItem
+	if f.batchSize > 0 {
+		counts, stored, err = f.copyItems(tx, run.ID, apiResp.Items)
+	} else {
+		counts, stored, err = f.upsertItems(tx, run.ID, apiResp.Items)
+	}
+	if err != nil {
+		return err
+	}
+	if err := syncSecurities(tx, stored); err != nil {
+		return err
 	}
 
-	// 5) Manejo de error en la respuesta de Yahoo
-	if payload.Chart.Error != nil {
-		return 0, fmt.Errorf("yahoo error: %v", payload.Chart.Error)
+	page := run.Page + 1
+	if err := commitCheckpoint(tx, *run, page, apiResp.NextPage, counts, newest); err != nil {
+		return err
 	}
-	if len(payload.Chart.Result) == 0 {
-		return 0, fmt.Errorf("sin resultado para %s", ticker)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit page: %w", err)
 	}
 
-	return payload.Chart.Result[0].Meta.RegularMarketPrice, nil
+	run.Page = page
+	run.NextKey = apiResp.NextPage
+	run.Summary.add(counts)
+
+	elapsed := time.Since(start)
+	log.Printf("Page %d: %d items in %s (%.0f items/s)",
+		page, len(apiResp.Items), elapsed.Round(time.Millisecond), float64(len(apiResp.Items))/elapsed.Seconds())
+	return nil
 }
 
-// handleStocks returns a list of stocks, supports search, sort, pagination.
-func handleStocks(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	q := r.URL.Query()
+// upsertItems writes a page's items one prepared upsert at a time, moving
+// the ones that fail to rejected_items, and returns the items stored.
+func (f *pageFetcher) upsertItems(tx *sql.Tx, runID int64, items []StockItem) (fetchSummary, []StockItem, error) {
+	stmt := tx.Stmt(f.prep)
+	var counts fetchSummary
+	var stored []StockItem
+	for _, item := range items {
+		outcome, itemErr, err := upsertItem(tx, stmt, &item, f.lookups())
+		if err != nil {
+			return counts, nil, err
+		}
+		if itemErr != nil {
+			if err := rejectItem(tx, runID, &item, itemErr); err != nil {
+				return counts, nil, err
+			}
+			counts.Failed++
+			continue
+		}
+		counts.record(outcome)
+		stored = append(stored, item)
+	}
+	return counts, stored, nil
+}
 
-	// Search across multiple text fields
-	search := q.Get("search")
+// upsertItem runs insertStockItem under a savepoint, so an item that fails to
+// parse or that the database refuses is returned in itemErr without aborting
+// the transaction. Any other error is returned in err.
+func upsertItem(tx *sql.Tx, stmt *sql.Stmt, item *StockItem, lookups ingestLookups) (outcome upsertOutcome, itemErr, err error) {
+	if _, err := tx.Exec("SAVEPOINT item"); err != nil {
+		return 0, nil, fmt.Errorf("%w: savepoint: %w", errExecInsert, err)
+	}
+	outcome, err = insertStockItem(stmt, item, lookups)
+	switch {
+	case err == nil:
+	case errors.Is(err, errExecInsert) && !isItemError(err):
+		return 0, nil, err
+	case isItemError(err):
+		if _, rerr := tx.Exec("ROLLBACK TO SAVEPOINT item"); rerr != nil {
+			return 0, nil, fmt.Errorf("%w: rollback to savepoint: %w", errExecInsert, rerr)
+		}
+		itemErr = err
+	default:
+		itemErr = err // parse error: nothing was written
+	}
+	if _, err := tx.Exec("RELEASE SAVEPOINT item"); err != nil {
+		return 0, nil, fmt.Errorf("%w: release savepoint: %w", errExecInsert, err)
+	}
+	return outcome, itemErr, nil
+}
 
-	// Faceted filters (comma-separated lists)
-	actions := splitParam(q.Get("action"))
-	brokerages := splitParam(q.Get("brokerage"))
-	ratingFrom := splitParam(q.Get("rating_from"))
-	ratingTo := splitParam(q.Get("rating_to"))
+// ingestLookups resolves what parseStockItem adds to an upstream item: the
+// prices stored alongside the rating, its canonical rating levels and its
+// brokerage. AtRating may be nil when no historical source is available,
+// Ratings when no taxonomy is loaded and Brokerages when none are resolved.
+type ingestLookups struct {
+	Current    func(ticker string) (float64, error)
+	AtRating   func(ticker string, t time.Time) (float64, error)
+	Ratings    *ratingTaxonomy
+	Brokerages *brokerageResolver
+}
 
-	// Numeric range filters for target_from/to
-	minTFStr := q.Get("min_target_from")
-	maxTFStr := q.Get("max_target_from")
-	minTTStr := q.Get("min_target_to")
-	maxTTStr := q.Get("max_target_to")
+// insertStockItem parses fields and executes the prepared upsert statement,
+// reporting whether the rating was inserted, updated or left unchanged.
+// Current and as-of-rating prices are resolved through prices.
+func insertStockItem(prep *sql.Stmt, item *StockItem, lookups ingestLookups) (upsertOutcome, error) {
+	r, err := parseStockItem(item, lookups)
+	if err != nil {
+		return 0, err
+	}
+	return scanUpsert(prep.QueryRow(r.upsertArgs()...))
+}
 
-	// Date range filters (ISO8601 format)
-	dateFromStr := q.Get("date_from")
-	dateToStr := q.Get("date_to")
+// parseStockItem converts an upstream item into a Rating, parsing targets and
+// time and resolving its prices. Unknown prices are left nil.
+func parseStockItem(item *StockItem, lookups ingestLookups) (Rating, error) {
+	r := Rating{
+		Ticker:     normalizeTicker(item.Ticker),
+		Company:    item.Company,
+		Brokerage:  item.Brokerage,
+		Action:     item.Action,
+		RatingFrom: item.RatingFrom,
+		RatingTo:   item.RatingTo,
+		// Resolved by Brokerages.Resolve before the page is stored
+		BrokerageID: lookups.Brokerages.id(item.Brokerage),
+		// Unknown ratings are stored as-is and reported as unmapped
+		RatingFromCanonical: lookups.Ratings.normalize(item.RatingFrom),
+		RatingToCanonical:   lookups.Ratings.normalize(item.RatingTo),
+	}
 
-	// Sorting and pagination parameters
-	sortBy := q.Get("sort")
-	if sortBy == "" {
-		sortBy = "ticker"
+	// Targets carry their own currency ("€12.50", "12.5 USD"); both must agree
+	def := item.Currency
+	if def == "" {
+		def = baseCurrency
 	}
-	order := strings.ToUpper(q.Get("order"))
-	if order != "DESC" {
-		order = "ASC"
+	from, hasFrom, err := parseMoney(item.TargetFrom, def)
+	if err != nil {
+		return Rating{}, &fieldError{Field: "target_from", Err: fmt.Errorf("parsing TargetFrom %q: %w", item.TargetFrom, err)}
 	}
-
-	limit := 100
-	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
-		limit = v
+	to, hasTo, err := parseMoney(item.TargetTo, def)
+	if err != nil {
+		return Rating{}, &fieldError{Field: "target_to", Err: fmt.Errorf("parsing TargetTo %q: %w", item.TargetTo, err)}
 	}
-	offset := 0
-	if v, err := strconv.Atoi(q.Get("offset")); err == nil && v >= 0 {
-		offset = v
+	if hasFrom && hasTo && from.Currency != to.Currency {
+		return Rating{}, &fieldError{Field: "target_to", Err: fmt.Errorf("TargetTo %q is in %s but TargetFrom %q is in %s", item.TargetTo, to.Currency, item.TargetFrom, from.Currency)}
 	}
-
-	// Build WHERE clauses dynamically
-	filters := []string{}
-	args := []interface{}{}
-	argPos := 1
-
-	if search != "" {
-		pattern := "%" + search + "%"
-		// Search multiple fields with case-insensitive match
-		filters = append(filters, fmt.Sprintf("(ticker ILIKE $%d OR company ILIKE $%d OR brokerage ILIKE $%d OR action ILIKE $%d OR rating_from ILIKE $%d OR rating_to ILIKE $%d)",
-			argPos, argPos+1, argPos+2, argPos+3, argPos+4, argPos+5))
-		for i := 0; i < 6; i++ {
-			args = append(args, pattern)
-		}
-		argPos += 6
+	r.Currency, _ = currencyUnit(def)
+	if hasFrom {
+		r.TargetFrom, r.Currency = &from.Amount, from.Currency
+	}
+	if hasTo {
+		r.TargetTo, r.Currency = &to.Amount, to.Currency
 	}
+	// "target set by" needs the parsed targets to tell raises from cuts
+	r.ActionType = classifyAction(item.Action, r.TargetFrom, r.TargetTo)
 
-	// Helper to add IN(...) filters
-	addInFilter := func(field string, vals []string) {
-		if len(vals) == 0 {
-			return
-		}
-		var ph []string
-		for _, v := range vals {
-			args = append(args, v)
-			ph = append(ph, fmt.Sprintf("$%d", len(args)))
-		}
-		filters = append(filters, fmt.Sprintf("%s IN (%s)", field, strings.Join(ph, ",")))
+	// Parse the raw time
+	parsedTime, err := time.Parse(time.RFC3339Nano, item.Time)
+	if err != nil {
+		return Rating{}, &fieldError{Field: "time", Err: fmt.Errorf("parsing Time %q: %w", item.Time, err)}
 	}
-	addInFilter("action", actions)
-	addInFilter("brokerage", brokerages)
-	addInFilter("rating_from", ratingFrom)
-	addInFilter("rating_to", ratingTo)
+	r.Time = parsedTime.UTC()
 
-	// Numeric filters
-	if minTFStr != "" {
-		if v, err := strconv.ParseFloat(minTFStr, 64); err == nil {
-			filters = append(filters, fmt.Sprintf("target_from >= $%d", argPos))
-			args = append(args, v)
-			argPos++
-		}
+	// Unknown prices are stored as NULL rather than 0
+	if p, err := lookups.Current(item.Ticker); err != nil {
+		log.Printf("warning: no pude obtener precio para %s: %v", item.Ticker, err)
+	} else {
+		now := time.Now().UTC()
+		r.CurrentPrice, r.PriceUpdatedAt = &p, &now
 	}
-	if maxTFStr != "" {
-		if v, err := strconv.ParseFloat(maxTFStr, 64); err == nil {
-			filters = append(filters, fmt.Sprintf("target_from <= $%d", argPos))
-			args = append(args, v)
-			argPos++
+	if lookups.AtRating != nil {
+		if p, err := lookups.AtRating(item.Ticker, parsedTime); err != nil {
+			log.Printf("warning: no pude obtener precio de %s al %s: %v", item.Ticker, parsedTime.Format(time.DateOnly), err)
+		} else {
+			r.PriceAtRating = &p
 		}
 	}
-	if minTTStr != "" {
-		if v, err := strconv.ParseFloat(minTTStr, 64); err == nil {
-			filters = append(filters, fmt.Sprintf("target_to >= $%d", argPos))
-			args = append(args, v)
-			argPos++
-		}
+	return r, nil
+}
+
+// ratingFilterFromQuery reads the /stocks filter, sort and paging
+// parameters. A missing limit is left at 0 (every match).
+func ratingFilterFromQuery(q url.Values) (RatingFilter, error) {
+	f := RatingFilter{
+		// Search across multiple text fields
+		Search: q.Get("search"),
+
+		// Faceted filters (comma-separated lists)
+		Actions:     splitParam(q.Get("action")),
+		ActionTypes: splitParam(q.Get("action_type")),
+		Brokerages:  splitParam(q.Get("brokerage")),
+		// Canonical brokerages match every alias of the firm
+		BrokerageIDs: int64Params(q.Get("brokerage_id")),
+		RatingFrom:   splitParam(q.Get("rating_from")),
+		RatingTo:     splitParam(q.Get("rating_to")),
+		// Reference data of the joined security
+		Sectors:   splitParam(q.Get("sector")),
+		Exchanges: splitParam(q.Get("exchange")),
+
+		// Numeric range filters for target_from/to
+		MinTargetFrom: floatParam(q.Get("min_target_from")),
+		MaxTargetFrom: floatParam(q.Get("max_target_from")),
+		MinTargetTo:   floatParam(q.Get("min_target_to")),
+		MaxTargetTo:   floatParam(q.Get("max_target_to")),
+
+		// Date range filters (ISO8601 format)
+		DateFrom: timeParam(q.Get("date_from")),
+		DateTo:   timeParam(q.Get("date_to")),
 	}
-	if maxTTStr != "" {
-		if v, err := strconv.ParseFloat(maxTTStr, 64); err == nil {
-			filters = append(filters, fmt.Sprintf("target_to <= $%d", argPos))
-			args = append(args, v)
-			argPos++
+
+	for _, a := range f.ActionTypes {
+		if !slices.Contains(ratingActions, ratingAction(a)) {
+			return RatingFilter{}, errors.New("invalid action_type")
 		}
 	}
 
-	// Date filters
-	if dateFromStr != "" {
-		if t, err := time.Parse(time.RFC3339, dateFromStr); err == nil {
-			filters = append(filters, fmt.Sprintf("time >= $%d", argPos))
-			args = append(args, t)
-			argPos++
-		}
+	// Sorting and pagination parameters
+	f.Sort = q.Get("sort")
+	if f.Sort == "" {
+		f.Sort = "ticker"
 	}
-	if dateToStr != "" {
-		if t, err := time.Parse(time.RFC3339, dateToStr); err == nil {
-			filters = append(filters, fmt.Sprintf("time <= $%d", argPos))
-			args = append(args, t)
-			argPos++
-		}
+	if !ratingSortColumns[f.Sort] {
+		return RatingFilter{}, errors.New("invalid sort")
 	}
+	f.Desc = strings.ToUpper(q.Get("order")) == "DESC"
 
-	where := ""
-	if len(filters) > 0 {
-		where = "WHERE " + strings.Join(filters, " AND ")
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 {
+		f.Limit = v
 	}
+	if v, err := strconv.Atoi(q.Get("offset")); err == nil && v >= 0 {
+		f.Offset = v
+	}
+	return f, nil
+}
 
-	// Final SQL
-	sqlQuery := fmt.Sprintf(
-		"SELECT ticker, company, brokerage, action, rating_from, rating_to, target_from, target_to, time FROM stock_info %s ORDER BY %s %s LIMIT $%d OFFSET $%d",
-		where, sortBy, order, len(args)+1, len(args)+2,
-	)
-	args = append(args, limit, offset)
+// handleStocks returns a list of stocks, supports search, sort, pagination.
+func handleStocks(w http.ResponseWriter, r *http.Request, repo StockRepository) {
+	f, err := ratingFilterFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Limit == 0 {
+		f.Limit = 100
+	}
 
-	rows, err := db.Query(sqlQuery, args...)
+	ratings, err := repo.ListRatings(f)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	results := []StockItem{}
-	for rows.Next() {
-		var s StockItem
-		var tf, tt sql.NullString
-		var t time.Time
-		if err := rows.Scan(
-			&s.Ticker, &s.Company, &s.Brokerage, &s.Action,
-			&s.RatingFrom, &s.RatingTo, &tf, &tt, &t,
-		); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if tf.Valid {
-			s.TargetFrom = tf.String
-		}
-		if tt.Valid {
-			s.TargetTo = tt.String
-		}
-		s.Time = t.Format(time.RFC3339Nano)
-		results = append(results, s)
+	results := make([]StockItem, len(ratings))
+	for i, rt := range ratings {
+		results[i] = rt.item()
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -485,36 +1122,126 @@ func handleStocks(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 }
 
 // handleStock returns the latest record for a given ticker.
-func handleStock(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	ticker := strings.TrimPrefix(r.URL.Path, "/stocks/")
+func handleStock(w http.ResponseWriter, r *http.Request, repo StockRepository) {
+	ticker := normalizeTicker(strings.TrimPrefix(r.URL.Path, "/stocks/"))
+	if ticker == "" {
+		http.Error(w, "ticker required", http.StatusBadRequest)
+		return
+	}
+	rt, err := repo.LatestForTicker(ticker)
+	if errors.Is(err, errNotFound) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rt.item())
+}
+
+// handleUnmappedRatings lists stored raw ratings that no rating_aliases row
+// maps, most frequent first, so they can be added to the taxonomy.
+func handleUnmappedRatings(w http.ResponseWriter, r *http.Request, repo StockRepository) {
+	counts, err := repo.UnmappedRatings()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"items": counts}); err != nil {
+		log.Printf("encode json: %v", err)
+	}
+}
+
+// handleSecurities lists the securities master.
+func handleSecurities(w http.ResponseWriter, r *http.Request, repo StockRepository) {
+	secs, err := repo.Securities()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"items": secs}); err != nil {
+		log.Printf("encode json: %v", err)
+	}
+}
+
+// handleSecurity returns the reference data of one ticker.
+func handleSecurity(w http.ResponseWriter, r *http.Request, repo StockRepository) {
+	ticker := normalizeTicker(strings.TrimPrefix(r.URL.Path, "/securities/"))
 	if ticker == "" {
 		http.Error(w, "ticker required", http.StatusBadRequest)
 		return
 	}
-	var s StockItem
-	var tf, tt sql.NullString
-	var t time.Time
-	err := db.QueryRow(
-		"SELECT ticker, company, brokerage, action, rating_from, rating_to, target_from, target_to, time FROM stock_info WHERE ticker=$1 ORDER BY time DESC LIMIT 1",
-		ticker,
-	).Scan(&s.Ticker, &s.Company, &s.Brokerage, &s.Action, &s.RatingFrom, &s.RatingTo, &tf, &tt, &t)
-	if err == sql.ErrNoRows {
+	sec, err := repo.Security(ticker)
+	if errors.Is(err, errNotFound) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	} else if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if tf.Valid {
-		s.TargetFrom = tf.String
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sec); err != nil {
+		log.Printf("encode json: %v", err)
+	}
+}
+
+// handleBrokerages lists the canonical brokerages and their aliases.
+func handleBrokerages(w http.ResponseWriter, r *http.Request, repo StockRepository) {
+	brokerages, err := repo.Brokerages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	if tt.Valid {
-		s.TargetTo = tt.String
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"items": brokerages}); err != nil {
+		log.Printf("encode json: %v", err)
 	}
-	s.Time = t.Format(time.RFC3339Nano)
+}
 
+// handleBrokerageSuggestions lists brokerages created at ingest that look
+// like an existing one, for review with -mode=merge-brokerage.
+func handleBrokerageSuggestions(w http.ResponseWriter, r *http.Request, repo StockRepository) {
+	suggestions, err := repo.BrokerageSuggestions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(s)
+	if err := json.NewEncoder(w).Encode(map[string]any{"items": suggestions}); err != nil {
+		log.Printf("encode json: %v", err)
+	}
+}
+
+// floatParam parses an optional numeric query parameter; invalid values are
+// ignored like missing ones.
+func floatParam(v string) *float64 {
+	f, err := strconv.ParseFloat(v, 64)
+	if v == "" || err != nil {
+		return nil
+	}
+	return &f
+}
+
+// timeParam parses an optional RFC 3339 query parameter; invalid values are
+// ignored like missing ones.
+func timeParam(v string) *time.Time {
+	t, err := time.Parse(time.RFC3339, v)
+	if v == "" || err != nil {
+		return nil
+	}
+	return &t
+}
+
+// nullFloatPtr maps a nullable column to an optional JSON number.
+func nullFloatPtr(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Float64
 }
 
 func splitParam(v string) []string {
@@ -531,69 +1258,124 @@ func splitParam(v string) []string {
 	return out
 }
 
-func handleRecommend(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+// int64Params parses a comma-separated list of ids, skipping invalid ones.
+func int64Params(v string) []int64 {
+	var out []int64
+	for _, p := range splitParam(v) {
+		if id, err := strconv.ParseInt(p, 10, 64); err == nil {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func handleRecommend(w http.ResponseWriter, r *http.Request, repo StockRepository) {
+	// Optional staleness filter, e.g. max_price_age=24h
+	f := LatestFilter{Priced: true}
+	if v := r.URL.Query().Get("max_price_age"); v != "" {
+		age, err := time.ParseDuration(v)
+		if err != nil || age <= 0 {
+			http.Error(w, "invalid max_price_age", http.StatusBadRequest)
+			return
+		}
+		f.PricedSince = time.Now().Add(-age)
+	}
+
 	//  obtener el último informe de cada ticker
-	const sqlLatest = `
-	SELECT DISTINCT ON (ticker)
-		ticker,
-		company,
-		brokerage,
-		rating_from,
-		rating_to,
-		target_from::FLOAT,
-		target_to::FLOAT,
-		current_price
-	FROM stock_info
-	WHERE current_price <>0;
-    `
-	rows, err := db.Query(sqlLatest)
+	latest, err := repo.LatestPerTicker(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Prices are quoted in the listing currency, which need not be the
+	// targets' own
+	rates, err := repo.FXRates()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	taxonomy, err := repo.RatingTaxonomy()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
 	const alpha = 0.7 // peso para upside
 	const beta = 0.3
 
 	var recs []RecResult
-	for rows.Next() {
-		var (
-			ticker, company, brokerage, fromRating, toRating string
-			tf, tt, price                                    float64
-		)
-		if err := rows.Scan(&ticker, &company, &brokerage, &fromRating, &toRating, &tf, &tt, &price); err != nil {
-			log.Printf("scan row: %v", err)
-
+	for _, rt := range latest {
+		if rt.TargetFrom == nil || rt.TargetTo == nil || rt.CurrentPrice == nil {
+			continue
+		}
+		// A brokerage that dropped coverage no longer holds the view
+		if rt.ActionType == actionDropCoverage {
+			continue
 		}
+		// Delisted securities cannot be bought
+		if rt.Security != nil && !rt.Security.Active {
+			continue
+		}
+		tf, tt, price := *rt.TargetFrom, *rt.TargetTo, *rt.CurrentPrice
 
-		//  Calcular upside y rating norm
+		//  Calcular upside y rating norm, contra el precio del día del informe si lo hay
+		baseline := price
+		if rt.PriceAtRating != nil && *rt.PriceAtRating > 0 {
+			baseline = *rt.PriceAtRating
+		}
 		avgTarget := (tf + tt) / 2
-		upsidePct := (avgTarget - price) / price
-
-		deltaScore := float64(ratingScore[toRating] - ratingScore[fromRating])
+		// Only targets and prices in different currencies need rates to compare
+		if cur, priceCur := rt.currency(), rt.priceCurrency(); cur != priceCur {
+			targetUSD, okTarget := toUSD(rates, avgTarget, cur)
+			baselineUSD, okPrice := toUSD(rates, baseline, priceCur)
+			if !okTarget || !okPrice {
+				log.Printf("warning: no FX rate between %s and %s; skipping %s", cur, priceCur, rt.Ticker)
+				continue
+			}
+			avgTarget, baseline = targetUSD, baselineUSD
+		}
+		upsidePct := (avgTarget - baseline) / baseline
+
+		// The rating move is the signal; the action only stands in for it
+		// when the rating is unchanged or unmapped
+		deltaScore := actionScores[rt.ActionType]
+		from, fromOK := taxonomy.lookup(rt.RatingFrom)
+		to, toOK := taxonomy.lookup(rt.RatingTo)
+		if fromOK && toOK && from.Score != to.Score {
+			deltaScore = float64(to.Score - from.Score)
+		}
 
 		composite := alpha*upsidePct + beta*deltaScore
 
+		// The securities master names the company consistently across brokerages
+		company, sector := rt.Company, ""
+		if rt.Security != nil {
+			sector = rt.Security.Sector
+			if rt.Security.Company != "" {
+				company = rt.Security.Company
+			}
+		}
+
 		recs = append(recs, RecResult{
-			Ticker:       ticker,
-			Company:      company,
-			Brokerage:    brokerage,
-			RatingFrom:   fromRating,
-			RatingTo:     toRating,
-			TargetFrom:   tf,
-			TargetTo:     tt,
-			CurrentPrice: price,
-			UpsidePct:    upsidePct,
-			Composite:    composite,
+			Ticker:         rt.Ticker,
+			Company:        company,
+			Sector:         sector,
+			Brokerage:      rt.Brokerage,
+			ActionType:     string(rt.ActionType),
+			RatingFrom:     rt.RatingFrom,
+			RatingTo:       rt.RatingTo,
+			TargetFrom:     tf,
+			TargetTo:       tt,
+			Currency:       rt.currency(),
+			CurrentPrice:   price,
+			PriceAtRating:  rt.PriceAtRating,
+			UpsidePct:      upsidePct,
+			Composite:      composite,
+			PriceUpdatedAt: rt.PriceUpdatedAt,
 		})
 	}
 
-	// Revisar errores de iteración
-	if err := rows.Err(); err != nil {
-		log.Printf("rows error: %v", err)
-	}
-
 	// Ordenamos descendentemente por Composite
 	sort.Slice(recs, func(i, j int) bool {
 		return recs[i].Composite > recs[j].Composite
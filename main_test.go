@@ -7,72 +7,45 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
-	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-// --- fetchCurrentPrice tests ---
-// rewriteTransport redirects requests to our httptest.Server
-type rewriteTransport struct {
-	orig   http.RoundTripper
-	target *url.URL
-}
-
-func (r *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	// Copy original path+query
-	req.URL.Scheme = r.target.Scheme
-	req.URL.Host = r.target.Host
-	return r.orig.RoundTrip(req)
-}
-
-// It implements http.RoundTripper
-type RoundTripFunc func(req *http.Request) (*http.Response, error)
-
-func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
-	return f(req)
-}
-
-// stubHTTPClient replaces http.DefaultClient.Transport to return the given body and status
-func stubHTTPClient(body string, status int) (restore func()) {
-	oldClient := http.DefaultClient
-	http.DefaultClient = &http.Client{
-		Transport: RoundTripFunc(func(req *http.Request) (*http.Response, error) {
-			return &http.Response{
-				StatusCode: status,
-				Body:       io.NopCloser(strings.NewReader(body)),
-				Header:     http.Header{"Content-Type": {"application/json"}},
-			}, nil
-		}),
+// fixedPrice returns price lookups that always answer p.
+func fixedPrice(p float64) ingestLookups {
+	return ingestLookups{
+		Current:  func(string) (float64, error) { return p, nil },
+		AtRating: func(string, time.Time) (float64, error) { return p, nil },
 	}
-	return func() { http.DefaultClient = oldClient }
 }
 
 // --- Tests for insertStockItem ---
 func TestInsertStockItem_Success(t *testing.T) {
-	// stub HTTP to return a fixed price
-	body := `{"chart":{"result":[{"meta":{"regularMarketPrice":123.45}}],"error":null}}`
-	restore := stubHTTPClient(body, http.StatusOK)
-	defer restore()
-
 	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
 	defer db.Close()
 
-	// Expect prepare and exec with correct args
+	// Expect prepare and upsert with correct args
 	mock.ExpectPrepare("INSERT INTO stock_info").
-		ExpectExec().
+		ExpectQuery().
 		WithArgs(
 			"TCK", "Comp", "Brok", "Act", "RF", "RT",
 			sqlmock.AnyArg(), // parsed target_from
 			sqlmock.AnyArg(), // parsed target_to
 			sqlmock.AnyArg(), // parsed time.Time
 			sqlmock.AnyArg(), // fetched current_price
-		).WillReturnResult(sqlmock.NewResult(1, 1))
+			sqlmock.AnyArg(), // fetched price_at_rating
+			sqlmock.AnyArg(), // price_updated_at
+			"USD",            // currency of "$" targets
+			sqlmock.AnyArg(), // rating_from_canonical
+			sqlmock.AnyArg(), // rating_to_canonical
+			nil,              // brokerage_id
+			nil,              // action_type
+		).WillReturnRows(sqlmock.NewRows([]string{"inserted"}).AddRow(true))
 
 	stmt, err := db.Prepare("INSERT INTO stock_info")
 	assert.NoError(t, err)
@@ -89,38 +62,41 @@ func TestInsertStockItem_Success(t *testing.T) {
 		Time:       time.Now().Format(time.RFC3339Nano),
 	}
 
-	err = insertStockItem(stmt, item)
+	outcome, err := insertStockItem(stmt, item, fixedPrice(123.45))
 	assert.NoError(t, err)
+	assert.Equal(t, outcomeInserted, outcome)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestInsertStockItem_ParseError_TargetFrom(t *testing.T) {
 	item := &StockItem{TargetFrom: "not-a-number", Time: time.Now().Format(time.RFC3339Nano)}
-	err := insertStockItem(&sql.Stmt{}, item)
+	_, err := insertStockItem(&sql.Stmt{}, item, fixedPrice(1))
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "parsing TargetFrom")
 }
 
 func TestInsertStockItem_NoTargets(t *testing.T) {
-	// stub HTTP client
-	body := `{"chart":{"result":[{"meta":{"regularMarketPrice":50.0}}],"error":null}}`
-	restore := stubHTTPClient(body, http.StatusOK)
-	defer restore()
-
 	db, mock, err := sqlmock.New()
 	assert.NoError(t, err)
 	defer db.Close()
 
 	// Expect nil pointers for target columns
 	mock.ExpectPrepare("INSERT INTO stock_info").
-		ExpectExec().
+		ExpectQuery().
 		WithArgs(
 			"TCK", "Comp", "Brok", "Act", "RF", "RT",
 			nil,              // no target_from
 			nil,              // no target_to
 			sqlmock.AnyArg(), // parsed time.Time
 			sqlmock.AnyArg(), // fetched current_price
-		).WillReturnResult(sqlmock.NewResult(1, 1))
+			sqlmock.AnyArg(), // fetched price_at_rating
+			sqlmock.AnyArg(), // price_updated_at
+			"USD",            // currency of "$" targets
+			sqlmock.AnyArg(), // rating_from_canonical
+			sqlmock.AnyArg(), // rating_to_canonical
+			nil,              // brokerage_id
+			nil,              // action_type
+		).WillReturnRows(sqlmock.NewRows([]string{"inserted"}).AddRow(true))
 
 	stmt, err := db.Prepare("INSERT INTO stock_info")
 	assert.NoError(t, err)
@@ -137,80 +113,106 @@ func TestInsertStockItem_NoTargets(t *testing.T) {
 		Time:       time.Now().Format(time.RFC3339Nano),
 	}
 
-	err = insertStockItem(stmt, item)
+	outcome, err := insertStockItem(stmt, item, fixedPrice(50.0))
 	assert.NoError(t, err)
+	assert.Equal(t, outcomeInserted, outcome)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestFetchCurrentPrice_Success(t *testing.T) {
-	// Mock Yahoo Finance JSON
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, `{"chart":{"result":[{"meta":{"regularMarketPrice":123.45}}]}}`)
-	}))
-	defer ts.Close()
+func TestInsertStockItem_UnknownPriceIsNull(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectPrepare("INSERT INTO stock_info").
+		ExpectQuery().
+		WithArgs(
+			"TCK", "", "Brok", "", "", "Buy",
+			nil, nil,
+			sqlmock.AnyArg(), // parsed time.Time
+			nil,              // no price from any provider
+			nil,              // no history source
+			nil,              // never priced
+			"USD",            // bare amounts default to USD
+			sqlmock.AnyArg(), // rating_from_canonical
+			sqlmock.AnyArg(), // rating_to_canonical
+			nil,              // brokerage_id
+			nil,              // action_type
+		).WillReturnRows(sqlmock.NewRows([]string{"inserted"}).AddRow(true))
 
-	// Override transport
-	orig := http.DefaultTransport
-	defer func() { http.DefaultTransport = orig }()
-	tu, _ := url.Parse(ts.URL)
-	http.DefaultTransport = &rewriteTransport{orig: orig, target: tu}
+	stmt, err := db.Prepare("INSERT INTO stock_info")
+	assert.NoError(t, err)
 
-	price, err := fetchCurrentPrice("ANY")
+	item := &StockItem{Ticker: "TCK", Brokerage: "Brok", RatingTo: "Buy", Time: time.Now().Format(time.RFC3339Nano)}
+	noPrice := ingestLookups{Current: func(string) (float64, error) { return 0, fmt.Errorf("all providers failed") }}
+	_, err = insertStockItem(stmt, item, noPrice)
 	assert.NoError(t, err)
-	assert.Equal(t, 123.45, price)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestFetchCurrentPrice_HTTPError(t *testing.T) {
-	// Server returns 500
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintln(w, "internal error")
-	}))
-	defer ts.Close()
+func TestInsertStockItem_UpsertOutcomes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
 
-	orig := http.DefaultTransport
-	defer func() { http.DefaultTransport = orig }()
-	tu, _ := url.Parse(ts.URL)
-	http.DefaultTransport = &rewriteTransport{orig: orig, target: tu}
+	prep := mock.ExpectPrepare("INSERT INTO stock_info")
+	// Conflicting row with different fields: update returns inserted=false
+	prep.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"inserted"}).AddRow(false))
+	// Conflicting row already identical: the WHERE clause suppresses the row
+	prep.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"inserted"}))
 
-	_, err := fetchCurrentPrice("ANY")
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "status 500")
+	stmt, err := db.Prepare("INSERT INTO stock_info")
+	assert.NoError(t, err)
+
+	item := &StockItem{Ticker: "TCK", Brokerage: "Brok", RatingTo: "Buy", Time: time.Now().Format(time.RFC3339Nano)}
+
+	outcome, err := insertStockItem(stmt, item, fixedPrice(50.0))
+	assert.NoError(t, err)
+	assert.Equal(t, outcomeUpdated, outcome)
+
+	outcome, err = insertStockItem(stmt, item, fixedPrice(50.0))
+	assert.NoError(t, err)
+	assert.Equal(t, outcomeUnchanged, outcome)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 // --- handleStock tests ---
-func TestHandleStock_NotFound(t *testing.T) {
-	db, mock, _ := sqlmock.New()
-	defer db.Close()
-
-	// Expect QueryRow to return no rows
-	mock.ExpectQuery("SELECT ticker, company, brokerage").
-		WithArgs("ZZZ").
-		WillReturnError(sql.ErrNoRows)
+func ptr[T any](v T) *T { return &v }
+
+// testRatings seeds handler tests: two XYZ ratings and one priced ABC rating.
+func testRatings() *memoryRepository {
+	return newMemoryRepository(
+		Rating{
+			Ticker: "XYZ", Company: "X Co", Brokerage: "Brok", Action: "reiterated", ActionType: actionReiterate,
+			RatingFrom: "Buy", RatingTo: "Buy", TargetFrom: ptr(1.0), TargetTo: ptr(2.0),
+			Time: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), CurrentPrice: ptr(3.0),
+		},
+		Rating{
+			Ticker: "XYZ", Company: "X Co", Brokerage: "Brok", Action: "reiterated", ActionType: actionReiterate,
+			RatingFrom: "Hold", RatingTo: "Hold", TargetFrom: ptr(1.0), TargetTo: ptr(2.0),
+			Time: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), CurrentPrice: ptr(3.0), PriceAtRating: ptr(2.5),
+		},
+		Rating{
+			Ticker: "ABC", Company: "A Co", Brokerage: "Other", Action: "upgraded by", ActionType: actionUpgrade,
+			RatingFrom: "Sell", RatingTo: "Buy", TargetFrom: ptr(10.0), TargetTo: ptr(12.0),
+			Time: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), CurrentPrice: ptr(5.0),
+		},
+	)
+}
 
+func TestHandleStock_NotFound(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	req := httptest.NewRequest("GET", "/stocks/ZZZ", nil)
-	handleStock(recorder, req, db)
+	handleStock(recorder, req, testRatings())
 
 	res := recorder.Result()
 	assert.Equal(t, http.StatusNotFound, res.StatusCode)
 }
 
 func TestHandleStock_Success(t *testing.T) {
-	db, mock, _ := sqlmock.New()
-	defer db.Close()
-
-	// Provide a valid row
-	row := sqlmock.NewRows([]string{"ticker", "company", "brokerage", "action", "rating_from", "rating_to", "target_from", "target_to", "time"}).
-		AddRow("XYZ", "X Co", "Brok", "reiterated", "Hold", "Hold", "$1", "$2", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
-
-	mock.ExpectQuery("SELECT ticker, company, brokerage").
-		WithArgs("XYZ").
-		WillReturnRows(row)
-
 	recorder := httptest.NewRecorder()
 	req := httptest.NewRequest("GET", "/stocks/XYZ", nil)
-	handleStock(recorder, req, db)
+	handleStock(recorder, req, testRatings())
 
 	res := recorder.Result()
 	assert.Equal(t, http.StatusOK, res.StatusCode)
@@ -220,48 +222,67 @@ func TestHandleStock_Success(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "XYZ", item.Ticker)
 	assert.Equal(t, "X Co", item.Company)
-	assert.Equal(t, "Hold", item.RatingFrom)
+	assert.Equal(t, "Hold", item.RatingFrom, "latest rating wins")
+	assert.Equal(t, "1", item.TargetFrom)
+	assert.Equal(t, 3.0, *item.CurrentPrice)
+	assert.Equal(t, 2.5, *item.PriceAtRating)
 }
 
-// --- Tests for handleStock detail ---
-func TestHandleStock_DBError(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
+func TestHandleStock_LowerCaseTicker(t *testing.T) {
+	repo := testRatings()
+	repo.securities = map[string]Security{"XYZ": {Ticker: "XYZ", Company: "X Corp", Currency: "USD", Active: true}}
+
+	recorder := httptest.NewRecorder()
+	handleStock(recorder, httptest.NewRequest("GET", "/stocks/xyz", nil), repo)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var item StockItem
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&item))
+	assert.Equal(t, "XYZ", item.Ticker)
+
+	recorder = httptest.NewRecorder()
+	handleSecurity(recorder, httptest.NewRequest("GET", "/securities/xyz", nil), repo)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var sec Security
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&sec))
+	assert.Equal(t, "X Corp", sec.Company)
+}
 
-	mock.ExpectQuery("stock_info").
-		WithArgs("TCK").
-		WillReturnError(fmt.Errorf("detail error"))
+// failingRepo is a StockRepository whose every call fails.
+type failingRepo struct{ err error }
+
+func (f failingRepo) ListRatings(RatingFilter) ([]Rating, error)        { return nil, f.err }
+func (f failingRepo) EachRating(RatingFilter, func(Rating) error) error { return f.err }
+func (f failingRepo) LatestForTicker(string) (Rating, error)            { return Rating{}, f.err }
+func (f failingRepo) LatestPerTicker(LatestFilter) ([]Rating, error)    { return nil, f.err }
+func (f failingRepo) InsertRating(Rating) (upsertOutcome, error)        { return 0, f.err }
+func (f failingRepo) FXRates() (map[string]float64, error)              { return nil, f.err }
+func (f failingRepo) RatingTaxonomy() (*ratingTaxonomy, error)          { return nil, f.err }
+func (f failingRepo) UnmappedRatings() ([]ratingCount, error)           { return nil, f.err }
+func (f failingRepo) Brokerages() ([]Brokerage, error)                  { return nil, f.err }
+func (f failingRepo) BrokerageSuggestions() ([]brokerageSuggestion, error) {
+	return nil, f.err
+}
+func (f failingRepo) Securities() ([]Security, error)   { return nil, f.err }
+func (f failingRepo) Security(string) (Security, error) { return Security{}, f.err }
 
+// --- Tests for handleStock detail ---
+func TestHandleStock_DBError(t *testing.T) {
 	req := httptest.NewRequest("GET", "/stocks/TCK", nil)
 	w := httptest.NewRecorder()
-	handleStock(w, req, db)
+	handleStock(w, req, failingRepo{fmt.Errorf("detail error")})
 	res := w.Result()
 	defer res.Body.Close()
 
 	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
 	body, _ := io.ReadAll(res.Body)
 	assert.Contains(t, string(body), "detail error")
-	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 // --- Tests for handleStocks ---
 func TestHandleStocks_Success_NoFilters(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	rows := sqlmock.NewRows([]string{
-		"ticker", "company", "brokerage", "action",
-		"rating_from", "rating_to", "target_from", "target_to", "time",
-	}).AddRow(
-		"T1", "C1", "B1", "A1", "RF1", "RT1", "$1.00", "$2.00", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
-	)
-	mock.ExpectQuery("stock_info").WillReturnRows(rows)
-
 	req := httptest.NewRequest("GET", "/stocks", nil)
 	w := httptest.NewRecorder()
-	handleStocks(w, req, db)
+	handleStocks(w, req, testRatings())
 	res := w.Result()
 	defer res.Body.Close()
 
@@ -269,43 +290,283 @@ func TestHandleStocks_Success_NoFilters(t *testing.T) {
 	var resp struct {
 		Items []StockItem `json:"items"`
 	}
-	err = json.NewDecoder(res.Body).Decode(&resp)
+	err := json.NewDecoder(res.Body).Decode(&resp)
 	assert.NoError(t, err)
-	assert.Len(t, resp.Items, 1)
-	assert.Equal(t, "T1", resp.Items[0].Ticker)
-	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Len(t, resp.Items, 3)
+	assert.Equal(t, "ABC", resp.Items[0].Ticker)
+	assert.Nil(t, resp.Items[0].PriceAtRating)
+}
+
+func TestHandleStocks_FiltersSortAndPage(t *testing.T) {
+	get := func(query string) (int, []StockItem) {
+		w := httptest.NewRecorder()
+		handleStocks(w, httptest.NewRequest("GET", "/stocks?"+query, nil), testRatings())
+		var resp struct {
+			Items []StockItem `json:"items"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp.Items
+	}
+
+	_, items := get("search=x%20co&sort=time&order=desc")
+	assert.Len(t, items, 2)
+	assert.Equal(t, "Hold", items[0].RatingTo)
+
+	_, items = get("brokerage=Other,Nope&min_target_to=11")
+	assert.Len(t, items, 1)
+	assert.Equal(t, "ABC", items[0].Ticker)
+
+	_, items = get("date_from=2025-01-01T00:00:00Z&sort=time&limit=1&offset=1")
+	assert.Len(t, items, 1)
+	assert.Equal(t, "ABC", items[0].Ticker)
+
+	_, items = get("action_type=upgrade,target_raise")
+	assert.Len(t, items, 1)
+	assert.Equal(t, "upgrade", items[0].ActionType)
+
+	code, _ := get("sort=ticker%3BDROP%20TABLE%20stock_info")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("action_type=upgraded")
+	assert.Equal(t, http.StatusBadRequest, code)
 }
 
 // --- handleRecommend tests ---
 func TestHandleRecommend(t *testing.T) {
-	// Mock DB with sqlmock
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	// Prepare rows: two tickers with different composites
-	t1 := sqlmock.NewRows([]string{"ticker", "company", "brokerage", "rating_from", "rating_to", "target_from", "target_to", "current_price"}).
-		AddRow("A", "CoA", "B1", "Buy", "Buy", 10.0, 12.0, 5.0).
-		AddRow("A", "CoB", "B2", "Sell", "Sell", 20.0, 22.0, 10.0)
-	// Expect query
-	mock.ExpectQuery(`SELECT DISTINCT ON \(ticker\)`).WillReturnRows(t1)
+	pricedAt := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	repo := newMemoryRepository(
+		Rating{
+			Ticker: "A", Company: "CoA", Brokerage: "B1", RatingFrom: "Buy", RatingTo: "Buy",
+			TargetFrom: ptr(10.0), TargetTo: ptr(12.0), Time: pricedAt,
+			CurrentPrice: ptr(5.0), PriceUpdatedAt: &pricedAt,
+		},
+		Rating{
+			Ticker: "B", Company: "CoB", Brokerage: "B2", RatingFrom: "Sell", RatingTo: "Sell",
+			TargetFrom: ptr(20.0), TargetTo: ptr(22.0), Time: pricedAt,
+			CurrentPrice: ptr(10.0), PriceAtRating: ptr(20.0),
+		},
+		// Older rating of B, superseded by the one above
+		Rating{
+			Ticker: "B", Company: "CoB", Brokerage: "B2", RatingFrom: "Sell", RatingTo: "Buy",
+			TargetFrom: ptr(50.0), TargetTo: ptr(50.0), Time: pricedAt.AddDate(0, 0, -1),
+			CurrentPrice: ptr(10.0),
+		},
+		// Unpriced tickers are not recommended
+		Rating{
+			Ticker: "C", RatingFrom: "Sell", RatingTo: "Buy",
+			TargetFrom: ptr(50.0), TargetTo: ptr(50.0), Time: pricedAt,
+		},
+	)
 
 	recorder := httptest.NewRecorder()
 	req := httptest.NewRequest("GET", "/recommend", nil)
-	handleRecommend(recorder, req, db)
+	handleRecommend(recorder, req, repo)
 
 	res := recorder.Result()
 	defer res.Body.Close()
 	assert.Equal(t, http.StatusOK, res.StatusCode)
 
 	var recs []RecResult
-	err = json.NewDecoder(res.Body).Decode(&recs)
+	err := json.NewDecoder(res.Body).Decode(&recs)
 	assert.NoError(t, err)
-	// Should have 2 entries sorted by composite descending (AAA has higher upside)
+	// Should have 2 entries sorted by composite descending (A has higher upside)
 	assert.Len(t, recs, 2)
 	assert.Equal(t, "A", recs[0].Ticker)
-	assert.Equal(t, "A", recs[1].Ticker)
+	assert.Equal(t, "B", recs[1].Ticker)
+	// The second row is measured against its price at rating: (21-20)/20
+	assert.InDelta(t, 0.05, recs[1].UpsidePct, 1e-9)
+	assert.Equal(t, 20.0, *recs[1].PriceAtRating)
+	assert.True(t, pricedAt.Equal(*recs[0].PriceUpdatedAt))
+	assert.Nil(t, recs[1].PriceUpdatedAt)
+}
 
-	// Ensure expectations were met
-	assert.NoError(t, mock.ExpectationsWereMet())
+func TestHandleRecommend_MaxPriceAge(t *testing.T) {
+	repo := newMemoryRepository(
+		Rating{
+			Ticker: "OLD", TargetFrom: ptr(1.0), TargetTo: ptr(1.0), Time: time.Now(),
+			CurrentPrice: ptr(1.0), PriceUpdatedAt: ptr(time.Now().Add(-48 * time.Hour)),
+		},
+		Rating{
+			Ticker: "NEW", TargetFrom: ptr(1.0), TargetTo: ptr(1.0), Time: time.Now(),
+			CurrentPrice: ptr(1.0), PriceUpdatedAt: ptr(time.Now()),
+		},
+	)
+
+	recorder := httptest.NewRecorder()
+	handleRecommend(recorder, httptest.NewRequest("GET", "/recommend?max_price_age=24h", nil), repo)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var recs []RecResult
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&recs))
+	assert.Len(t, recs, 1)
+	assert.Equal(t, "NEW", recs[0].Ticker)
+
+	recorder = httptest.NewRecorder()
+	handleRecommend(recorder, httptest.NewRequest("GET", "/recommend?max_price_age=soon", nil), repo)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestHandleRecommend_ConvertsTargetsToUSD(t *testing.T) {
+	at := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	repo := newMemoryRepository(
+		// 12 EUR against an 11 USD price is 13.20 USD against 11 USD
+		Rating{Ticker: "EU", TargetFrom: ptr(12.0), TargetTo: ptr(12.0), Currency: "EUR", Time: at, CurrentPrice: ptr(11.0)},
+		// 12 EUR against a 10 EUR listing needs no rate
+		Rating{Ticker: "EL", TargetFrom: ptr(12.0), TargetTo: ptr(12.0), Currency: "EUR", Time: at, CurrentPrice: ptr(10.0)},
+		// Neither does CHF against a CHF listing
+		Rating{Ticker: "CH", TargetFrom: ptr(15.0), TargetTo: ptr(15.0), Currency: "CHF", Time: at, CurrentPrice: ptr(10.0)},
+		// No rate for CHF against a USD price: cannot be scored
+		Rating{Ticker: "CU", TargetFrom: ptr(12.0), TargetTo: ptr(12.0), Currency: "CHF", Time: at, CurrentPrice: ptr(10.0)},
+	)
+	repo.fx = map[string]float64{"EUR": 1.10}
+	repo.securities = map[string]Security{
+		"EL": {Ticker: "EL", Currency: "EUR", Active: true},
+		"CH": {Ticker: "CH", Currency: "CHF", Active: true},
+	}
+
+	recorder := httptest.NewRecorder()
+	handleRecommend(recorder, httptest.NewRequest("GET", "/recommend", nil), repo)
+	var recs []RecResult
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&recs))
+	upside := map[string]float64{}
+	for _, rec := range recs {
+		upside[rec.Ticker] = rec.UpsidePct
+	}
+	assert.Len(t, upside, 3)
+	assert.InDelta(t, 0.20, upside["EU"], 1e-9, "EUR targets against a USD price")
+	assert.InDelta(t, 0.20, upside["EL"], 1e-9)
+	assert.InDelta(t, 0.50, upside["CH"], 1e-9)
+	assert.Equal(t, "CH", recs[0].Ticker)
+	assert.Equal(t, "CHF", recs[0].Currency)
+	assert.Equal(t, 15.0, recs[0].TargetTo, "targets are reported as stored")
+	assert.Equal(t, 10.0, recs[0].CurrentPrice, "prices too")
+}
+
+func TestHandleRecommend_ScoresActions(t *testing.T) {
+	at := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	rating := func(ticker string, action ratingAction) Rating {
+		return Rating{
+			Ticker: ticker, RatingFrom: "Buy", RatingTo: "Buy", ActionType: action,
+			TargetFrom: ptr(11.0), TargetTo: ptr(11.0), Time: at, CurrentPrice: ptr(10.0),
+		}
+	}
+	repo := newMemoryRepository(
+		rating("LOW", actionTargetLower),
+		rating("FLAT", actionReiterate),
+		rating("RAISE", actionTargetRaise),
+		rating("GONE", actionDropCoverage),
+	)
+
+	recorder := httptest.NewRecorder()
+	handleRecommend(recorder, httptest.NewRequest("GET", "/recommend", nil), repo)
+	var recs []RecResult
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&recs))
+	assert.Len(t, recs, 3, "dropped coverage is not recommended")
+	assert.Equal(t, []string{"RAISE", "FLAT", "LOW"}, []string{recs[0].Ticker, recs[1].Ticker, recs[2].Ticker})
+	assert.Equal(t, "target_raise", recs[0].ActionType)
+	assert.InDelta(t, recs[1].Composite+0.15, recs[0].Composite, 1e-9)
+}
+
+func TestHandleRecommend_CountsUpgradesOnce(t *testing.T) {
+	at := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	rating := func(ticker, from, to string, action ratingAction) Rating {
+		return Rating{
+			Ticker: ticker, RatingFrom: from, RatingTo: to, ActionType: action,
+			TargetFrom: ptr(10.0), TargetTo: ptr(10.0), Time: at, CurrentPrice: ptr(10.0),
+		}
+	}
+	repo := newMemoryRepository(
+		rating("MOVE", "Hold", "Buy", actionUpgrade),
+		rating("JUMP", "Sell", "Buy", actionUpgrade),
+		rating("SAME", "Buy", "Buy", actionUpgrade),
+		rating("ODD", "Hold", "Spiffy", actionUpgrade),
+	)
+
+	recorder := httptest.NewRecorder()
+	handleRecommend(recorder, httptest.NewRequest("GET", "/recommend", nil), repo)
+	var recs []RecResult
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&recs))
+	composites := map[string]float64{}
+	for _, rec := range recs {
+		composites[rec.Ticker] = rec.Composite
+	}
+	// No upside, so the composite is beta times the delta
+	assert.InDelta(t, 0.3, composites["MOVE"], 1e-9, "one level, not one plus the upgrade")
+	assert.InDelta(t, 0.6, composites["JUMP"], 1e-9)
+	assert.InDelta(t, 0.3, composites["SAME"], 1e-9, "the action stands in for an unchanged rating")
+	assert.InDelta(t, 0.3, composites["ODD"], 1e-9, "and for an unmapped one")
+}
+
+func TestHandleRecommend_UsesSecurities(t *testing.T) {
+	at := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	repo := newMemoryRepository(
+		Rating{Ticker: "ACT", Company: "Act Inc", TargetFrom: ptr(2.0), TargetTo: ptr(2.0), Time: at, CurrentPrice: ptr(1.0)},
+		Rating{Ticker: "GONE", Company: "Gone Inc", TargetFrom: ptr(9.0), TargetTo: ptr(9.0), Time: at, CurrentPrice: ptr(1.0)},
+	)
+	repo.securities = map[string]Security{
+		"ACT":  {Ticker: "ACT", Company: "Active Holdings", Sector: "Energy", Currency: "USD", Active: true},
+		"GONE": {Ticker: "GONE", Company: "Gone Inc", Currency: "USD", Active: false},
+	}
+
+	recorder := httptest.NewRecorder()
+	handleRecommend(recorder, httptest.NewRequest("GET", "/recommend", nil), repo)
+	var recs []RecResult
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&recs))
+	assert.Len(t, recs, 1, "delisted securities are not recommended")
+	assert.Equal(t, "Active Holdings", recs[0].Company)
+	assert.Equal(t, "Energy", recs[0].Sector)
+}
+
+func TestHandleSecurities(t *testing.T) {
+	repo := testRatings()
+	repo.securities = map[string]Security{
+		"XYZ": {Ticker: "XYZ", Company: "X Corp", Sector: "Utilities", Currency: "USD", Active: true},
+		"ABC": {Ticker: "ABC", Company: "A Corp", Sector: "Energy", Currency: "USD", Active: true},
+	}
+
+	recorder := httptest.NewRecorder()
+	handleSecurities(recorder, httptest.NewRequest("GET", "/securities", nil), repo)
+	var list struct {
+		Items []Security `json:"items"`
+	}
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&list))
+	assert.Equal(t, []string{"ABC", "XYZ"}, []string{list.Items[0].Ticker, list.Items[1].Ticker})
+
+	recorder = httptest.NewRecorder()
+	handleSecurity(recorder, httptest.NewRequest("GET", "/securities/XYZ", nil), repo)
+	var sec Security
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&sec))
+	assert.Equal(t, "X Corp", sec.Company)
+
+	recorder = httptest.NewRecorder()
+	handleSecurity(recorder, httptest.NewRequest("GET", "/securities/ZZZ", nil), repo)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	// /stocks embeds the security and filters on it
+	recorder = httptest.NewRecorder()
+	handleStocks(recorder, httptest.NewRequest("GET", "/stocks?sector=Utilities", nil), repo)
+	var stocks struct {
+		Items []StockItem `json:"items"`
+	}
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&stocks))
+	assert.Len(t, stocks.Items, 2)
+	assert.Equal(t, "X Corp", stocks.Items[0].Security.Company)
+	assert.Equal(t, "X Co", stocks.Items[0].Company)
+}
+
+func TestHandleUnmappedRatings(t *testing.T) {
+	repo := newMemoryRepository(
+		Rating{Ticker: "A", RatingFrom: "Accumulate", RatingTo: "Conviction Buy", Time: time.Now()},
+		Rating{Ticker: "B", RatingFrom: "Conviction Buy", RatingTo: "Buy", Time: time.Now()},
+	)
+	recorder := httptest.NewRecorder()
+	handleUnmappedRatings(recorder, httptest.NewRequest("GET", "/ratings/unmapped", nil), repo)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var resp struct {
+		Items []ratingCount `json:"items"`
+	}
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&resp))
+	assert.Equal(t, []ratingCount{{"Conviction Buy", 2}}, resp.Items)
+
+	recorder = httptest.NewRecorder()
+	handleUnmappedRatings(recorder, httptest.NewRequest("GET", "/ratings/unmapped", nil), failingRepo{fmt.Errorf("down")})
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
 }
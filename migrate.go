@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// migration is one versioned schema change with its rollback.
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// migrationsTable records which versions have been applied.
+var migrationsTable = `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`
+
+// migrationLockID is the advisory lock key serializing concurrent migrators
+// (e.g. several serve instances starting at once).
+const migrationLockID = 74_201_311
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// loadMigrations reads NNNN_name.up.sql / NNNN_name.down.sql pairs from the
+// root of fsys, sorted by version. Both directions are required.
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations: %w", err)
+	}
+	byVersion := map[int]*migration{}
+	for _, e := range entries {
+		m := migrationFileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", e.Name(), err)
+		}
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	var migs []migration
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both up and down files", mig.Version, mig.Name)
+		}
+		migs = append(migs, *mig)
+	}
+	sort.Slice(migs, func(i, j int) bool { return migs[i].Version < migs[j].Version })
+	return migs, nil
+}
+
+// embeddedMigrations returns the migrations compiled into the binary for d.
+func embeddedMigrations(d dialect) ([]migration, error) {
+	sub, err := fs.Sub(migrationFiles, d.Migrations)
+	if err != nil {
+		return nil, err
+	}
+	return loadMigrations(sub)
+}
+
+// migrator applies migrations on a single connection holding the advisory
+// lock, each one in its own transaction with its schema_migrations row.
+type migrator struct {
+	conn *sql.Conn
+	migs []migration
+}
+
+// withMigrator runs fn with the migration lock of d held.
+func withMigrator(db *sql.DB, d dialect, migs []migration, fn func(m *migrator) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("migration connection: %w", err)
+	}
+	defer conn.Close()
+
+	if d.LockStmt != "" {
+		if _, err := conn.ExecContext(ctx, d.LockStmt, migrationLockID); err != nil {
+			return fmt.Errorf("migration lock: %w", err)
+		}
+		defer conn.ExecContext(ctx, d.UnlockStmt, migrationLockID)
+	}
+
+	if _, err := conn.ExecContext(ctx, migrationsTable); err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	return fn(&migrator{conn: conn, migs: migs})
+}
+
+// applied returns the set of versions recorded in schema_migrations.
+func (m *migrator) applied() (map[int]bool, error) {
+	rows, err := m.conn.QueryContext(context.Background(), "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	done := map[int]bool{}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("scan version: %w", err)
+		}
+		done[v] = true
+	}
+	return done, rows.Err()
+}
+
+// run executes one migration script and records (or forgets) its version.
+func (m *migrator) run(mig migration, up bool) error {
+	ctx := context.Background()
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script, record, args := mig.Down, "DELETE FROM schema_migrations WHERE version=$1", []any{mig.Version}
+	if up {
+		script, record, args = mig.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", []any{mig.Version, mig.Name}
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %04d_%s: %w", mig.Version, mig.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return fmt.Errorf("recording migration %04d: %w", mig.Version, err)
+	}
+	return tx.Commit()
+}
+
+// migrateUp applies pending migrations in version order, at most steps of
+// them when steps > 0. It returns the versions applied.
+func migrateUp(db *sql.DB, d dialect, migs []migration, steps int) ([]int, error) {
+	var done []int
+	err := withMigrator(db, d, migs, func(m *migrator) error {
+		applied, err := m.applied()
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migs {
+			if applied[mig.Version] {
+				continue
+			}
+			if steps > 0 && len(done) == steps {
+				break
+			}
+			if err := m.run(mig, true); err != nil {
+				return err
+			}
+			log.Printf("Applied migration %04d_%s", mig.Version, mig.Name)
+			done = append(done, mig.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// migrateDown rolls back the steps most recently applied migrations.
+func migrateDown(db *sql.DB, d dialect, migs []migration, steps int) ([]int, error) {
+	var done []int
+	err := withMigrator(db, d, migs, func(m *migrator) error {
+		applied, err := m.applied()
+		if err != nil {
+			return err
+		}
+		for i := len(m.migs) - 1; i >= 0 && len(done) < steps; i-- {
+			mig := m.migs[i]
+			if !applied[mig.Version] {
+				continue
+			}
+			if err := m.run(mig, false); err != nil {
+				return err
+			}
+			log.Printf("Rolled back migration %04d_%s", mig.Version, mig.Name)
+			done = append(done, mig.Version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// migrationStatus lists every known migration and whether it is applied.
+func migrationStatus(db *sql.DB, d dialect, migs []migration) (map[int]bool, error) {
+	var applied map[int]bool
+	err := withMigrator(db, d, migs, func(m *migrator) error {
+		var err error
+		applied, err = m.applied()
+		return err
+	})
+	return applied, err
+}
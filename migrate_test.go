@@ -0,0 +1,132 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations_SortsPairs(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0002_add_col.up.sql":   {Data: []byte("ALTER TABLE t ADD COLUMN c INT;")},
+		"0002_add_col.down.sql": {Data: []byte("ALTER TABLE t DROP COLUMN c;")},
+		"0001_init.up.sql":      {Data: []byte("CREATE TABLE t (id INT);")},
+		"0001_init.down.sql":    {Data: []byte("DROP TABLE t;")},
+		"README.md":             {Data: []byte("ignored")},
+	}
+	migs, err := loadMigrations(fsys)
+	assert.NoError(t, err)
+	assert.Len(t, migs, 2)
+	assert.Equal(t, 1, migs[0].Version)
+	assert.Equal(t, "init", migs[0].Name)
+	assert.Equal(t, "DROP TABLE t;", migs[0].Down)
+	assert.Equal(t, 2, migs[1].Version)
+}
+
+func TestLoadMigrations_RequiresDown(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_init.up.sql": {Data: []byte("CREATE TABLE t (id INT);")},
+	}
+	_, err := loadMigrations(fsys)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "0001_init")
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	pg, err := embeddedMigrations(postgresDialect)
+	assert.NoError(t, err)
+	for i, mig := range pg {
+		assert.Equal(t, i+1, mig.Version, "versions must be contiguous")
+	}
+	assert.Equal(t, "create_stock_info", pg[0].Name)
+
+	// Both dialects must describe the same schema history
+	lite, err := embeddedMigrations(sqliteDialect)
+	assert.NoError(t, err)
+	assert.Len(t, lite, len(pg))
+	for i := range lite {
+		assert.Equal(t, pg[i].Version, lite[i].Version)
+		assert.Equal(t, pg[i].Name, lite[i].Name)
+	}
+}
+
+var testMigrations = []migration{
+	{Version: 1, Name: "init", Up: "CREATE TABLE t (id INT)", Down: "DROP TABLE t"},
+	{Version: 2, Name: "add_col", Up: "ALTER TABLE t ADD COLUMN c INT", Down: "ALTER TABLE t DROP COLUMN c"},
+	{Version: 3, Name: "index", Up: "CREATE INDEX t_c ON t (c)", Down: "DROP INDEX t_c"},
+}
+
+func expectMigrationLock(mock sqlmock.Sqlmock, applied ...int) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).
+		WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version"})
+	for _, v := range applied {
+		rows.AddRow(v)
+	}
+	mock.ExpectQuery("SELECT version FROM schema_migrations").WillReturnRows(rows)
+}
+
+func TestMigrateUp_AppliesPendingOnly(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	expectMigrationLock(mock, 1)
+	for _, mig := range testMigrations[1:] {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(mig.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_migrations").
+			WithArgs(mig.Version, mig.Name).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).
+		WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	done, err := migrateUp(db, postgresDialect, testMigrations, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, done)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrateUp_StopsOnFailure(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	expectMigrationLock(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(testMigrations[0].Up)).WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	done, err := migrateUp(db, postgresDialect, testMigrations, 0)
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Contains(t, err.Error(), "0001_init")
+	assert.Empty(t, done)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrateDown_RollsBackNewestFirst(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	expectMigrationLock(mock, 1, 2, 3)
+	for _, mig := range []migration{testMigrations[2], testMigrations[1]} {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(mig.Down)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM schema_migrations WHERE version=$1")).
+			WithArgs(mig.Version).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	done, err := migrateDown(db, postgresDialect, testMigrations, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 2}, done)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
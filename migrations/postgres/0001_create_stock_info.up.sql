@@ -0,0 +1,15 @@
+-- Baseline ratings table. IF NOT EXISTS adopts databases created by hand
+-- before migrations existed.
+CREATE TABLE IF NOT EXISTS stock_info (
+	id            BIGSERIAL PRIMARY KEY,
+	ticker        TEXT NOT NULL,
+	company       TEXT NOT NULL,
+	brokerage     TEXT NOT NULL,
+	action        TEXT NOT NULL,
+	rating_from   TEXT NOT NULL,
+	rating_to     TEXT NOT NULL,
+	target_from   NUMERIC,
+	target_to     NUMERIC,
+	time          TIMESTAMPTZ NOT NULL,
+	current_price NUMERIC
+);
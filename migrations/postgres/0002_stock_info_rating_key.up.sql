@@ -0,0 +1,18 @@
+-- Natural rating identity used by the ingest upsert. Requires Postgres 15 or
+-- later: target_to may be NULL, and NULLS NOT DISTINCT makes NULLs compare
+-- equal.
+--
+-- Tables filled before the key existed hold the duplicates it forbids, so
+-- keep the first stored row of each key before building the index. A table
+-- adopted by 0001 may have no id column, so rows are told apart by ctid.
+DELETE FROM stock_info a
+	USING stock_info b
+	WHERE a.ticker = b.ticker
+		AND a.brokerage = b.brokerage
+		AND a.time = b.time
+		AND a.rating_to = b.rating_to
+		AND a.target_to IS NOT DISTINCT FROM b.target_to
+		AND a.ctid > b.ctid;
+
+CREATE UNIQUE INDEX IF NOT EXISTS stock_info_rating_key
+	ON stock_info (ticker, brokerage, time, rating_to, target_to) NULLS NOT DISTINCT;
@@ -0,0 +1,26 @@
+-- Run/checkpoint bookkeeping for -resume and the per-source watermark used by
+-- -incremental.
+CREATE TABLE IF NOT EXISTS fetch_runs (
+	id          BIGSERIAL PRIMARY KEY,
+	status      TEXT NOT NULL DEFAULT 'running',
+	started_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+	finished_at TIMESTAMPTZ,
+	inserted    INT NOT NULL DEFAULT 0,
+	updated     INT NOT NULL DEFAULT 0,
+	unchanged   INT NOT NULL DEFAULT 0,
+	failed      INT NOT NULL DEFAULT 0,
+	newest_time TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS fetch_checkpoints (
+	run_id       BIGINT NOT NULL REFERENCES fetch_runs(id),
+	page         INT NOT NULL,
+	next_page    TEXT NOT NULL,
+	committed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (run_id, page)
+);
+
+CREATE TABLE IF NOT EXISTS fetch_watermarks (
+	source      TEXT PRIMARY KEY,
+	newest_time TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,11 @@
+-- Daily OHLCV bars filled by -mode=prices.
+CREATE TABLE IF NOT EXISTS price_history (
+	ticker TEXT NOT NULL,
+	date   DATE NOT NULL,
+	open   NUMERIC,
+	high   NUMERIC,
+	low    NUMERIC,
+	close  NUMERIC NOT NULL,
+	volume BIGINT,
+	PRIMARY KEY (ticker, date)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE stock_info DROP COLUMN IF EXISTS price_updated_at;
+ALTER TABLE stock_info DROP COLUMN IF EXISTS price_at_rating;
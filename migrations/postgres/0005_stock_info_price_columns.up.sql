@@ -0,0 +1,3 @@
+-- Close as of the rating date, and when current_price was last refreshed.
+ALTER TABLE stock_info ADD COLUMN IF NOT EXISTS price_at_rating NUMERIC;
+ALTER TABLE stock_info ADD COLUMN IF NOT EXISTS price_updated_at TIMESTAMPTZ;
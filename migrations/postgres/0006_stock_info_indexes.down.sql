@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS stock_info_target_to_idx;
+DROP INDEX IF EXISTS stock_info_target_from_idx;
+DROP INDEX IF EXISTS stock_info_rating_to_idx;
+DROP INDEX IF EXISTS stock_info_rating_from_idx;
+DROP INDEX IF EXISTS stock_info_action_idx;
+DROP INDEX IF EXISTS stock_info_brokerage_idx;
+DROP INDEX IF EXISTS stock_info_company_idx;
+DROP INDEX IF EXISTS stock_info_time_idx;
+DROP INDEX IF EXISTS stock_info_ticker_time_idx;
@@ -0,0 +1,11 @@
+-- Indexes for the /stocks facets, range filters and sorts, and for the
+-- latest-rating-per-ticker lookups.
+CREATE INDEX IF NOT EXISTS stock_info_ticker_time_idx ON stock_info (ticker, time DESC);
+CREATE INDEX IF NOT EXISTS stock_info_time_idx ON stock_info (time);
+CREATE INDEX IF NOT EXISTS stock_info_company_idx ON stock_info (company);
+CREATE INDEX IF NOT EXISTS stock_info_brokerage_idx ON stock_info (brokerage);
+CREATE INDEX IF NOT EXISTS stock_info_action_idx ON stock_info (action);
+CREATE INDEX IF NOT EXISTS stock_info_rating_from_idx ON stock_info (rating_from);
+CREATE INDEX IF NOT EXISTS stock_info_rating_to_idx ON stock_info (rating_to);
+CREATE INDEX IF NOT EXISTS stock_info_target_from_idx ON stock_info (target_from);
+CREATE INDEX IF NOT EXISTS stock_info_target_to_idx ON stock_info (target_to);
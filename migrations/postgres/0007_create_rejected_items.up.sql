@@ -0,0 +1,14 @@
+-- Dead letters for items that failed to parse or were refused by the
+-- database, kept verbatim so -mode=reprocess-rejects can retry them.
+CREATE TABLE IF NOT EXISTS rejected_items (
+	id          BIGSERIAL PRIMARY KEY,
+	run_id      BIGINT REFERENCES fetch_runs(id),
+	raw         JSON NOT NULL,
+	field       TEXT,
+	error       TEXT NOT NULL,
+	attempts    INT NOT NULL DEFAULT 1,
+	rejected_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	resolved_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS rejected_items_pending_idx ON rejected_items (id) WHERE resolved_at IS NULL;
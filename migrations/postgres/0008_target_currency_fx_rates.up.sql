@@ -0,0 +1,9 @@
+-- ISO currency of target_from/target_to, and USD conversion rates used to
+-- score recommendations. Ratings stored so far were all parsed from "$".
+ALTER TABLE stock_info ADD COLUMN IF NOT EXISTS target_currency TEXT NOT NULL DEFAULT 'USD';
+
+CREATE TABLE IF NOT EXISTS fx_rates (
+	currency   TEXT PRIMARY KEY,
+	usd_rate   NUMERIC NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,48 @@
+-- Raw rating strings mapped to canonical levels and their recommendation
+-- scores. Aliases are stored lower-cased with hyphens as spaces
+-- (ratingAliasKey).
+CREATE TABLE IF NOT EXISTS rating_aliases (
+	alias     TEXT PRIMARY KEY,
+	canonical TEXT NOT NULL,
+	score     INT NOT NULL
+);
+
+INSERT INTO rating_aliases (alias, canonical, score) VALUES
+	('strong buy', 'Strong Buy', 2),
+	('outperform', 'Strong Buy', 2),
+	('market outperform', 'Strong Buy', 2),
+	('sector outperform', 'Strong Buy', 2),
+	('top pick', 'Strong Buy', 2),
+	('buy', 'Buy', 1),
+	('overweight', 'Buy', 1),
+	('positive', 'Buy', 1),
+	('accumulate', 'Buy', 1),
+	('moderate buy', 'Buy', 1),
+	('speculative buy', 'Buy', 1),
+	('hold', 'Hold', 0),
+	('neutral', 'Hold', 0),
+	('equal weight', 'Hold', 0),
+	('market perform', 'Hold', 0),
+	('sector perform', 'Hold', 0),
+	('sector weight', 'Hold', 0),
+	('peer perform', 'Hold', 0),
+	('in line', 'Hold', 0),
+	('unchanged', 'Hold', 0),
+	('sell', 'Sell', -1),
+	('underweight', 'Sell', -1),
+	('reduce', 'Sell', -1),
+	('negative', 'Sell', -1),
+	('moderate sell', 'Sell', -1),
+	('strong sell', 'Strong Sell', -2),
+	('underperform', 'Strong Sell', -2),
+	('market underperform', 'Strong Sell', -2),
+	('sector underperform', 'Strong Sell', -2)
+ON CONFLICT (alias) DO NOTHING;
+
+-- Canonical levels of rating_from/rating_to, NULL when unmapped at ingest.
+ALTER TABLE stock_info ADD COLUMN IF NOT EXISTS rating_from_canonical TEXT;
+ALTER TABLE stock_info ADD COLUMN IF NOT EXISTS rating_to_canonical TEXT;
+
+UPDATE stock_info SET
+	rating_from_canonical = (SELECT canonical FROM rating_aliases WHERE alias = LOWER(REPLACE(TRIM(rating_from), '-', ' '))),
+	rating_to_canonical   = (SELECT canonical FROM rating_aliases WHERE alias = LOWER(REPLACE(TRIM(rating_to), '-', ' ')));
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS stock_info_brokerage_id_idx;
+ALTER TABLE stock_info DROP COLUMN IF EXISTS brokerage_id;
+DROP TABLE IF EXISTS brokerage_suggestions;
+DROP TABLE IF EXISTS brokerage_aliases;
+DROP TABLE IF EXISTS brokerages;
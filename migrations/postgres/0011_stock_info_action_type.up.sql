@@ -0,0 +1,18 @@
+-- Structured classification of the free-text action; NULL when no rule
+-- matches. The CASE mirrors actionRules and classifyAction.
+ALTER TABLE stock_info ADD COLUMN IF NOT EXISTS action_type TEXT;
+CREATE INDEX IF NOT EXISTS stock_info_action_type_idx ON stock_info (action_type);
+
+UPDATE stock_info SET action_type = CASE
+	WHEN LOWER(action) LIKE '%drop%' OR LOWER(action) LIKE '%terminat%'
+		OR LOWER(action) LIKE '%discontinu%' OR LOWER(action) LIKE '%suspend%' THEN 'drop_coverage'
+	WHEN LOWER(action) LIKE '%upgrad%' THEN 'upgrade'
+	WHEN LOWER(action) LIKE '%downgrad%' THEN 'downgrade'
+	WHEN LOWER(action) LIKE '%initiat%' OR LOWER(action) LIKE '%resum%' OR LOWER(action) LIKE '%assum%' THEN 'initiate'
+	WHEN LOWER(action) LIKE '%rais%' OR LOWER(action) LIKE '%boost%' OR LOWER(action) LIKE '%lift%' THEN 'target_raise'
+	WHEN LOWER(action) LIKE '%lower%' OR LOWER(action) LIKE '%cut%' OR LOWER(action) LIKE '%reduc%' THEN 'target_lower'
+	WHEN LOWER(action) LIKE '%reiterat%' OR LOWER(action) LIKE '%maintain%' OR LOWER(action) LIKE '%affirm%' THEN 'reiterate'
+	WHEN LOWER(action) LIKE '%target%' AND target_to > target_from THEN 'target_raise'
+	WHEN LOWER(action) LIKE '%target%' AND target_to < target_from THEN 'target_lower'
+	WHEN LOWER(action) LIKE '%target%' AND target_to = target_from THEN 'reiterate'
+END;
@@ -0,0 +1,18 @@
+-- Baseline ratings table. Timestamps are stored as UTC text, which the
+-- driver parses back for TIMESTAMP columns.
+CREATE TABLE IF NOT EXISTS stock_info (
+	id            INTEGER PRIMARY KEY,
+	ticker        TEXT NOT NULL,
+	company       TEXT NOT NULL,
+	brokerage     TEXT NOT NULL,
+	action        TEXT NOT NULL,
+	rating_from   TEXT NOT NULL,
+	rating_to     TEXT NOT NULL,
+	target_from   NUMERIC,
+	target_to     NUMERIC,
+	time          TIMESTAMP NOT NULL,
+	current_price NUMERIC,
+	-- Set to 0 by the ingest upsert when it updates an existing row; SQLite
+	-- has no xmax to tell inserts from updates.
+	upsert_inserted BOOLEAN NOT NULL DEFAULT 1
+);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS stock_info_rating_key;
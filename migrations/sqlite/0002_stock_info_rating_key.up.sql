@@ -0,0 +1,14 @@
+-- Natural rating identity used by the ingest upsert. SQLite has no NULLS NOT
+-- DISTINCT, so a NULL target_to is indexed through IFNULL instead.
+--
+-- Tables filled before the key existed hold the duplicates it forbids, so
+-- keep the oldest row of each key before building the index. A table
+-- adopted by 0001 may have no id column, so rows are told apart by rowid.
+DELETE FROM stock_info
+	WHERE rowid NOT IN (
+		SELECT MIN(rowid) FROM stock_info
+		GROUP BY ticker, brokerage, time, rating_to, IFNULL(target_to, '')
+	);
+
+CREATE UNIQUE INDEX IF NOT EXISTS stock_info_rating_key
+	ON stock_info (ticker, brokerage, time, rating_to, IFNULL(target_to, ''));
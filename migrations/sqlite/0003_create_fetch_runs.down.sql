@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS fetch_watermarks;
+DROP TABLE IF EXISTS fetch_checkpoints;
+DROP TABLE IF EXISTS fetch_runs;
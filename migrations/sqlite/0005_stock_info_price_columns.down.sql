@@ -0,0 +1,2 @@
+ALTER TABLE stock_info DROP COLUMN price_updated_at;
+ALTER TABLE stock_info DROP COLUMN price_at_rating;
@@ -0,0 +1,14 @@
+-- Dead letters for items that failed to parse or were refused by the
+-- database, kept verbatim so -mode=reprocess-rejects can retry them.
+CREATE TABLE IF NOT EXISTS rejected_items (
+	id          INTEGER PRIMARY KEY,
+	run_id      INTEGER REFERENCES fetch_runs(id),
+	raw         TEXT NOT NULL,
+	field       TEXT,
+	error       TEXT NOT NULL,
+	attempts    INT NOT NULL DEFAULT 1,
+	rejected_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	resolved_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS rejected_items_pending_idx ON rejected_items (id) WHERE resolved_at IS NULL;
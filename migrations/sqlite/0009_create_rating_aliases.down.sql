@@ -0,0 +1,3 @@
+ALTER TABLE stock_info DROP COLUMN rating_to_canonical;
+ALTER TABLE stock_info DROP COLUMN rating_from_canonical;
+DROP TABLE IF EXISTS rating_aliases;
@@ -0,0 +1,57 @@
+-- Canonical brokerages. Raw names map to one through brokerage_aliases,
+-- keyed by brokerageKey; names no alias matches get a brokerage of their own
+-- at ingest, and a suggestion when they resemble a known one.
+CREATE TABLE IF NOT EXISTS brokerages (
+	id   INTEGER PRIMARY KEY,
+	name TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS brokerage_aliases (
+	key          TEXT PRIMARY KEY,
+	alias        TEXT NOT NULL,
+	brokerage_id INTEGER NOT NULL REFERENCES brokerages(id)
+);
+
+CREATE TABLE IF NOT EXISTS brokerage_suggestions (
+	brokerage_id INTEGER NOT NULL REFERENCES brokerages(id),
+	suggested_id INTEGER NOT NULL REFERENCES brokerages(id),
+	similarity   REAL NOT NULL,
+	created_at   TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (brokerage_id, suggested_id)
+);
+
+INSERT INTO brokerages (name) VALUES
+	('JPMorgan Chase & Co.'),
+	('Goldman Sachs'),
+	('Morgan Stanley'),
+	('Bank of America'),
+	('Citigroup'),
+	('Wells Fargo'),
+	('Barclays'),
+	('UBS Group'),
+	('Deutsche Bank'),
+	('RBC Capital Markets');
+
+INSERT INTO brokerage_aliases (key, alias, brokerage_id)
+SELECT v.column1, v.column2, b.id
+FROM (VALUES
+	('jpmorganchase', 'JPMorgan Chase & Co.', 'JPMorgan Chase & Co.'),
+	('jpmorgan', 'JP Morgan', 'JPMorgan Chase & Co.'),
+	('goldmansachs', 'Goldman Sachs', 'Goldman Sachs'),
+	('morganstanley', 'Morgan Stanley', 'Morgan Stanley'),
+	('bankofamerica', 'Bank of America', 'Bank of America'),
+	('bofasecurities', 'BofA Securities', 'Bank of America'),
+	('citigroup', 'Citigroup', 'Citigroup'),
+	('citi', 'Citi', 'Citigroup'),
+	('wellsfargo', 'Wells Fargo & Company', 'Wells Fargo'),
+	('barclays', 'Barclays', 'Barclays'),
+	('ubs', 'UBS Group', 'UBS Group'),
+	('deutschebank', 'Deutsche Bank', 'Deutsche Bank'),
+	('rbccapitalmarkets', 'RBC Capital Markets', 'RBC Capital Markets'),
+	('royalbankofcanada', 'Royal Bank of Canada', 'RBC Capital Markets')
+) v JOIN brokerages b ON b.name = v.column3;
+
+-- Stored ratings are resolved by the next fetch. No REFERENCES: SQLite
+-- cannot drop a column that is part of a foreign key.
+ALTER TABLE stock_info ADD COLUMN brokerage_id INTEGER;
+CREATE INDEX IF NOT EXISTS stock_info_brokerage_id_idx ON stock_info (brokerage_id);
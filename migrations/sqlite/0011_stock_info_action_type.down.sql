@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS stock_info_action_type_idx;
+ALTER TABLE stock_info DROP COLUMN action_type;
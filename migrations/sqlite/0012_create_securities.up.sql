@@ -0,0 +1,21 @@
+-- Securities master: one row per ticker with its reference data. Ingest
+-- registers new tickers; -mode=load-securities loads the reference CSV.
+CREATE TABLE IF NOT EXISTS securities (
+	ticker     TEXT PRIMARY KEY,
+	company    TEXT NOT NULL DEFAULT '',
+	exchange   TEXT,
+	sector     TEXT,
+	industry   TEXT,
+	currency   TEXT NOT NULL DEFAULT 'USD',
+	active     BOOLEAN NOT NULL DEFAULT TRUE,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS securities_sector_idx ON securities (sector);
+
+-- Tickers stored so far, named after their latest rating
+INSERT INTO securities (ticker, company)
+SELECT s.ticker, MAX(s.company)
+FROM stock_info s
+WHERE s.time = (SELECT MAX(l.time) FROM stock_info l WHERE l.ticker = s.ticker)
+GROUP BY s.ticker
+ON CONFLICT (ticker) DO NOTHING;
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Money is a parsed price target: an amount in an ISO 4217 currency.
+type Money struct {
+	Amount   float64
+	Currency string
+}
+
+// baseCurrency is assumed for bare amounts and is what recommendations are
+// scored in.
+const baseCurrency = "USD"
+
+// currencySymbols maps the symbols targets are written with to ISO codes.
+// Longer symbols come first so "C$" is not read as "$".
+var currencySymbols = []struct{ symbol, code string }{
+	{"US$", "USD"}, {"CA$", "CAD"}, {"AU$", "AUD"}, {"NZ$", "NZD"}, {"HK$", "HKD"}, {"MX$", "MXN"},
+	{"C$", "CAD"}, {"A$", "AUD"}, {"S$", "SGD"}, {"R$", "BRL"},
+	{"$", "USD"}, {"€", "EUR"}, {"£", "GBP"}, {"¥", "JPY"}, {"₹", "INR"}, {"₩", "KRW"}, {"₣", "CHF"},
+}
+
+// subunitCodes are quotes in a fraction of a currency, e.g. London prices in
+// pence. "GBp" and "ZAc" only match as written, since "GBP" is pounds; the
+// upper-case codes match in any case.
+var subunitCodes = map[string]struct {
+	code    string
+	divisor float64
+}{
+	"GBX": {"GBP", 100},
+	"GBp": {"GBP", 100},
+	"ZAC": {"ZAR", 100},
+	"ZAc": {"ZAR", 100},
+}
+
+// currencyUnit resolves a currency code as written to the upper-case code
+// amounts are stored in and the divisor that converts to it.
+func currencyUnit(code string) (string, float64) {
+	if sub, isSub := subunitCodes[code]; isSub {
+		return sub.code, sub.divisor
+	}
+	code = strings.ToUpper(code)
+	if sub, isSub := subunitCodes[code]; isSub {
+		return sub.code, sub.divisor
+	}
+	return code, 1
+}
+
+// noTargetValues are placeholders upstream uses for a missing target.
+var noTargetValues = map[string]bool{"": true, "-": true, "—": true, "n/a": true, "na": true, "none": true}
+
+var errNoAmount = errors.New("no amount")
+
+// parseMoney reads a target such as "$4.20", "€12,50", "C$18", "12.5 USD" or
+// "GBX 250". Bare amounts are in def, which may be a subunit such as "GBp".
+// ok is false for an empty or placeholder value ("-", "N/A").
+func parseMoney(s, def string) (m Money, ok bool, err error) {
+	s = strings.TrimSpace(s)
+	if noTargetValues[strings.ToLower(s)] {
+		return Money{}, false, nil
+	}
+
+	divisor := 1.0
+	amount := s
+	if code, rest, found := cutCurrencyCode(amount); found {
+		amount = strings.TrimSpace(rest)
+		m.Currency, divisor = currencyUnit(code)
+	}
+	// A symbol next to a code ("$12 USD") only confirms it
+	for _, cs := range currencySymbols {
+		rest, found := strings.CutPrefix(amount, cs.symbol)
+		if !found {
+			rest, found = strings.CutSuffix(amount, cs.symbol)
+		}
+		if found {
+			amount = rest
+			if m.Currency == "" {
+				m.Currency = cs.code
+			}
+			break
+		}
+	}
+	if m.Currency == "" {
+		m.Currency, divisor = currencyUnit(def)
+	}
+
+	amount = normalizeAmount(amount)
+	if amount == "" {
+		return Money{}, false, errNoAmount
+	}
+	v, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return Money{}, false, err
+	}
+	if v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+		return Money{}, false, fmt.Errorf("amount %v out of range", v)
+	}
+	m.Amount = v / divisor
+	return m, true, nil
+}
+
+// cutCurrencyCode splits a three-letter code off either end of s ("USD 12",
+// "12.5usd"), returning the code as written.
+func cutCurrencyCode(s string) (code, rest string, ok bool) {
+	isCode := func(c string) bool {
+		if len(c) != 3 {
+			return false
+		}
+		for _, r := range c {
+			if r > unicode.MaxASCII || !unicode.IsLetter(r) {
+				return false
+			}
+		}
+		return true
+	}
+	if len(s) > 3 && isCode(s[:3]) {
+		return s[:3], s[3:], true
+	}
+	if len(s) > 3 && isCode(s[len(s)-3:]) {
+		return s[len(s)-3:], s[:len(s)-3], true
+	}
+	return "", "", false
+}
+
+// normalizeAmount removes spaces and thousands separators and turns a decimal
+// comma ("12,50", "1.234,56") into a point. A lone comma followed by three
+// digits stays a thousands separator ("1,250").
+func normalizeAmount(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+	comma, dot := strings.LastIndex(s, ","), strings.LastIndex(s, ".")
+	switch {
+	case comma > dot && dot >= 0:
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.Replace(s, ",", ".", 1)
+	case comma >= 0 && dot < 0 && strings.Count(s, ",") == 1 && len(s)-comma-1 <= 2:
+		s = strings.Replace(s, ",", ".", 1)
+	default:
+		s = strings.ReplaceAll(s, ",", "")
+	}
+	return s
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMoney(t *testing.T) {
+	for _, tc := range []struct {
+		in       string
+		amount   float64
+		currency string
+	}{
+		{"$4.20", 4.20, "USD"},
+		{"$1,250.00", 1250, "USD"},
+		{"4.20", 4.20, "USD"},
+		{"€12.50", 12.50, "EUR"},
+		{"12,50 €", 12.50, "EUR"},
+		{"€1.234,56", 1234.56, "EUR"},
+		{"£4.20", 4.20, "GBP"},
+		{"C$18", 18, "CAD"},
+		{"A$ 7", 7, "AUD"},
+		{"US$3", 3, "USD"},
+		{"12.5 USD", 12.5, "USD"},
+		{"eur 9", 9, "EUR"},
+		{"$12 CAD", 12, "CAD"},
+		{"GBX 250", 2.50, "GBP"},
+		{"¥1,500", 1500, "JPY"},
+	} {
+		m, ok, err := parseMoney(tc.in, baseCurrency)
+		if assert.NoError(t, err, tc.in) && assert.True(t, ok, tc.in) {
+			assert.InDelta(t, tc.amount, m.Amount, 1e-9, tc.in)
+			assert.Equal(t, tc.currency, m.Currency, tc.in)
+		}
+	}
+
+	for _, none := range []string{"", " ", "-", "N/A", "—"} {
+		_, ok, err := parseMoney(none, baseCurrency)
+		assert.NoError(t, err, none)
+		assert.False(t, ok, none)
+	}
+	for _, bad := range []string{"TBD", "$", "abc12x", "-5", "NaN", "$1e999"} {
+		_, _, err := parseMoney(bad, baseCurrency)
+		assert.Error(t, err, bad)
+	}
+
+	m, _, err := parseMoney("7", "EUR")
+	assert.NoError(t, err)
+	assert.Equal(t, "EUR", m.Currency, "bare amounts take the default currency")
+}
+
+func TestParseStockItem_MixedCurrencies(t *testing.T) {
+	item := &StockItem{TargetFrom: "€10", TargetTo: "$12", Time: "2025-01-13T00:30:05Z"}
+	_, err := parseStockItem(item, fixedPrice(1))
+	var fe *fieldError
+	require.ErrorAs(t, err, &fe)
+	assert.Equal(t, "target_to", fe.Field)
+
+	item.TargetTo = "€12"
+	r, err := parseStockItem(item, fixedPrice(1))
+	require.NoError(t, err)
+	assert.Equal(t, "EUR", r.Currency)
+	assert.Equal(t, 12.0, *r.TargetTo)
+}
+
+func TestParseMoney_SubunitSpellings(t *testing.T) {
+	for _, tc := range []struct {
+		in, def  string
+		amount   float64
+		currency string
+	}{
+		{"GBP 250", baseCurrency, 250, "GBP"},
+		{"gbp 250", baseCurrency, 250, "GBP"},
+		{"GBp 250", baseCurrency, 2.50, "GBP"},
+		{"250GBp", baseCurrency, 2.50, "GBP"},
+		{"GBX 250", baseCurrency, 2.50, "GBP"},
+		{"gbx 250", baseCurrency, 2.50, "GBP"},
+		{"ZAR 1500", baseCurrency, 1500, "ZAR"},
+		{"ZAc 1500", baseCurrency, 15, "ZAR"},
+		{"ZAC 1500", baseCurrency, 15, "ZAR"},
+		{"250", "GBP", 250, "GBP"},
+		{"250", "gbp", 250, "GBP"},
+		{"250", "GBp", 2.50, "GBP"},
+		{"250", "GBX", 2.50, "GBP"},
+		{"£2.50", "GBp", 2.50, "GBP"},
+	} {
+		m, ok, err := parseMoney(tc.in, tc.def)
+		if assert.NoError(t, err, tc.in) && assert.True(t, ok, tc.in) {
+			assert.InDelta(t, tc.amount, m.Amount, 1e-9, "%s in %s", tc.in, tc.def)
+			assert.Equal(t, tc.currency, m.Currency, "%s in %s", tc.in, tc.def)
+		}
+	}
+
+	item := &StockItem{TargetTo: "250", Currency: "GBp", Time: "2025-01-13T00:30:05Z"}
+	r, err := parseStockItem(item, fixedPrice(1))
+	require.NoError(t, err)
+	assert.Equal(t, "GBP", r.Currency)
+	assert.Equal(t, 2.50, *r.TargetTo, "a pence column is stored in pounds")
+
+	item = &StockItem{Currency: "gbp", Time: "2025-01-13T00:30:05Z"}
+	r, err = parseStockItem(item, fixedPrice(1))
+	require.NoError(t, err)
+	assert.Equal(t, "GBP", r.Currency)
+}
+
+func TestFXRates_CSVRoundTrip(t *testing.T) {
+	rates, err := loadFXRatesCSV(strings.NewReader("currency,usd_rate\neur,1.08\nGBP, 1.27\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"EUR": 1.08, "GBP": 1.27}, rates)
+
+	_, err = loadFXRatesCSV(strings.NewReader("EUR,-1\n"))
+	assert.Error(t, err)
+
+	db := openTestSQLite(t)
+	require.NoError(t, storeFXRates(db, rates))
+	require.NoError(t, storeFXRates(db, map[string]float64{"EUR": 1.10}))
+	stored, err := newSQLiteRepository(db).FXRates()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"USD": 1, "EUR": 1.10, "GBP": 1.27}, stored)
+
+	usd, ok := toUSD(stored, 10, "GBP")
+	assert.True(t, ok)
+	assert.InDelta(t, 12.7, usd, 1e-9)
+	_, ok = toUSD(stored, 10, "CHF")
+	assert.False(t, ok)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// priceResult is a cached lookup outcome; failures are cached too so a
+// ticker that cannot be priced is only tried once per run.
+type priceResult struct {
+	price float64
+	err   error
+}
+
+// priceCache memoizes price lookups by key for the duration of one fetch run.
+type priceCache struct {
+	fetch func(ticker string) (float64, error)
+
+	mu     sync.Mutex
+	prices map[string]priceResult
+}
+
+func newPriceCache(fetch func(ticker string) (float64, error)) *priceCache {
+	return &priceCache{fetch: fetch, prices: map[string]priceResult{}}
+}
+
+// Get returns the cached price for ticker, looking it up on a miss.
+func (c *priceCache) Get(ticker string) (float64, error) {
+	c.mu.Lock()
+	res, ok := c.prices[ticker]
+	c.mu.Unlock()
+	if ok {
+		return res.price, res.err
+	}
+
+	price, err := c.fetch(ticker)
+	c.mu.Lock()
+	c.prices[ticker] = priceResult{price: price, err: err}
+	c.mu.Unlock()
+	return price, err
+}
+
+// Prefetch resolves every distinct, not yet cached ticker using at most
+// workers concurrent lookups.
+func (c *priceCache) Prefetch(tickers []string, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Deduplicate and drop tickers already resolved this run
+	seen := map[string]bool{}
+	var todo []string
+	c.mu.Lock()
+	for _, t := range tickers {
+		if _, cached := c.prices[t]; cached || seen[t] {
+			continue
+		}
+		seen[t] = true
+		todo = append(todo, t)
+	}
+	c.mu.Unlock()
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(todo); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range jobs {
+				c.Get(t)
+			}
+		}()
+	}
+	for _, t := range todo {
+		jobs <- t
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// ratingPriceKey identifies the close known at t, that of lastClosedDay, in
+// a cache built by newRatingPriceCache.
+func ratingPriceKey(ticker string, t time.Time) string {
+	return ticker + "|" + lastClosedDay(t).Format(time.DateOnly)
+}
+
+// newRatingPriceCache memoizes closes as of a rating date, keyed by
+// ratingPriceKey.
+func newRatingPriceCache(hp HistoryProvider) *priceCache {
+	return newPriceCache(func(key string) (float64, error) {
+		ticker, day, _ := strings.Cut(key, "|")
+		t, err := time.Parse(time.DateOnly, day)
+		if err != nil {
+			return 0, err
+		}
+		return closeOnOrBefore(hp, ticker, t)
+	})
+}
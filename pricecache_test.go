@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriceCache_PrefetchDeduplicatesAndBounds(t *testing.T) {
+	var calls, inFlight, maxInFlight int32
+	var mu sync.Mutex
+	fetch := func(ticker string) (float64, error) {
+		atomic.AddInt32(&calls, 1)
+		n := atomic.AddInt32(&inFlight, 1)
+		mu.Lock()
+		if n > maxInFlight {
+			maxInFlight = n
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		if ticker == "BAD" {
+			return 0, fmt.Errorf("no quote")
+		}
+		return float64(len(ticker)), nil
+	}
+
+	cache := newPriceCache(fetch)
+	cache.Prefetch([]string{"A", "BB", "A", "CCC", "BB", "DDDD", "BAD", "A"}, 2)
+
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls))
+	assert.LessOrEqual(t, maxInFlight, int32(2))
+
+	// Cached hits, including the cached failure, do not refetch
+	price, err := cache.Get("CCC")
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, price)
+	_, err = cache.Get("BAD")
+	assert.Error(t, err)
+	cache.Prefetch([]string{"A", "BB"}, 4)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&calls))
+}
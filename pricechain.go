@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errNoQuote marks a provider that answered but has no price for the
+// ticker. It moves on to the next provider without tripping the breaker.
+var errNoQuote = errors.New("no quote")
+
+// Breaker defaults: open after this many consecutive failures, then let a
+// single trial request through once the cooldown has elapsed.
+const (
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+)
+
+// chainProvider asks each provider in order, skipping those whose circuit
+// breaker is open, and returns the first price found.
+type chainProvider struct {
+	providers []PriceProvider
+	breakers  []*circuitBreaker
+}
+
+func newChainProvider(providers ...PriceProvider) *chainProvider {
+	c := &chainProvider{providers: providers}
+	for range providers {
+		c.breakers = append(c.breakers, newCircuitBreaker(breakerThreshold, breakerCooldown))
+	}
+	return c
+}
+
+func (c *chainProvider) Name() string {
+	names := make([]string, len(c.providers))
+	for i, p := range c.providers {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (c *chainProvider) CurrentPrice(ticker string) (float64, error) {
+	var price float64
+	err := c.try(nil, func(p PriceProvider) error {
+		var err error
+		price, err = p.CurrentPrice(ticker)
+		return err
+	})
+	return price, err
+}
+
+// try runs call against each provider in order until one succeeds, skipping
+// providers rejected by accepts (when non-nil) or whose breaker is open, and
+// recording the outcome on each breaker.
+func (c *chainProvider) try(accepts func(p PriceProvider) bool, call func(p PriceProvider) error) error {
+	var errs []error
+	for i, p := range c.providers {
+		if accepts != nil && !accepts(p) {
+			continue
+		}
+		b := c.breakers[i]
+		if !b.allow() {
+			errs = append(errs, fmt.Errorf("%s: circuit open", p.Name()))
+			continue
+		}
+		err := call(p)
+		switch {
+		case err == nil:
+			b.success()
+			return nil
+		case errors.Is(err, errNoQuote):
+			b.success()
+		default:
+			if b.failure() {
+				log.Printf("warning: price provider %s failing, circuit open for %s", p.Name(), b.cooldown)
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("no price provider in %s can serve this request", c.Name())
+	}
+	return errors.Join(errs...)
+}
+
+// circuitBreaker tracks consecutive failures of one price source.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	trial     bool // a half-open trial request is in flight
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a request may go through. Once the cooldown of an
+// open breaker elapses, exactly one trial request is let through.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.trial || b.now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.trial = false
+}
+
+// failure records a failed request and reports whether it (re)opened the
+// breaker.
+func (b *circuitBreaker) failure() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.failures < b.threshold {
+		return false
+	}
+	b.openUntil = b.now().Add(b.cooldown)
+	return true
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingProvider is a PriceProvider stub that counts calls.
+type countingProvider struct {
+	name  string
+	price float64
+	err   error
+	calls int
+}
+
+func (p *countingProvider) Name() string { return p.name }
+
+func (p *countingProvider) CurrentPrice(string) (float64, error) {
+	p.calls++
+	return p.price, p.err
+}
+
+func TestChainProvider_FallsBack(t *testing.T) {
+	primary := &countingProvider{name: "primary", err: fmt.Errorf("status 429")}
+	backup := &countingProvider{name: "backup", price: 42}
+	chain := newChainProvider(primary, backup)
+
+	price, err := chain.CurrentPrice("TCK")
+	assert.NoError(t, err)
+	assert.Equal(t, 42.0, price)
+	assert.Equal(t, "primary,backup", chain.Name())
+}
+
+func TestChainProvider_OpenBreakerSkipsProvider(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	primary := &countingProvider{name: "primary", err: fmt.Errorf("status 429")}
+	backup := &countingProvider{name: "backup", price: 42}
+	chain := newChainProvider(primary, backup)
+	chain.breakers[0].now = func() time.Time { return now }
+
+	for i := 0; i < breakerThreshold+3; i++ {
+		_, err := chain.CurrentPrice("TCK")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, breakerThreshold, primary.calls)
+
+	// After the cooldown a single trial goes through; success closes the breaker
+	now = now.Add(breakerCooldown)
+	primary.err, primary.price = nil, 7
+	price, err := chain.CurrentPrice("TCK")
+	assert.NoError(t, err)
+	assert.Equal(t, 7.0, price)
+	assert.True(t, chain.breakers[0].allow())
+}
+
+func TestChainProvider_NoQuoteDoesNotTrip(t *testing.T) {
+	primary := &countingProvider{name: "primary", err: fmt.Errorf("%w for TCK", errNoQuote)}
+	backup := &countingProvider{name: "backup", err: fmt.Errorf("%w for TCK", errNoQuote)}
+	chain := newChainProvider(primary, backup)
+
+	for i := 0; i < breakerThreshold+1; i++ {
+		_, err := chain.CurrentPrice("TCK")
+		assert.ErrorIs(t, err, errNoQuote)
+	}
+	assert.Equal(t, breakerThreshold+1, primary.calls)
+}
+
+func TestNewPriceProvider_Chain(t *testing.T) {
+	p, err := newPriceProvider("yahoo, stooq")
+	assert.NoError(t, err)
+	assert.Equal(t, "yahoo,stooq", p.Name())
+}
+
+func TestNewPriceProvider_SingleSourceHasBreaker(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	p, err := newPriceProvider("yahoo:" + ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "yahoo", p.Name())
+	for range breakerThreshold + 3 {
+		_, err = p.CurrentPrice("TCK")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, breakerThreshold, calls, "the dead source is not called once the circuit opens")
+	assert.ErrorContains(t, err, "circuit open")
+}
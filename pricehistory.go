@@ -0,0 +1,275 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PriceBar is one daily OHLCV bar.
+type PriceBar struct {
+	Date   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume int64
+}
+
+// HistoryProvider is implemented by price sources that can return daily
+// bars for a date range (both ends inclusive).
+type HistoryProvider interface {
+	DailyBars(ticker string, from, to time.Time) ([]PriceBar, error)
+}
+
+var insertBarStmt = `
+		INSERT INTO price_history (ticker, date, open, high, low, close, volume)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (ticker, date) DO UPDATE SET
+			open = EXCLUDED.open, high = EXCLUDED.high, low = EXCLUDED.low,
+			close = EXCLUDED.close, volume = EXCLUDED.volume
+	`
+
+// backfillRangesQuery lists every rated ticker with its first rating and the
+// last bar already stored, which bound the range still to backfill.
+const backfillRangesQuery = `
+	SELECT r.ticker, r.first_rating, h.last_bar
+	FROM (SELECT ticker, MIN(time) AS first_rating FROM stock_info GROUP BY ticker) r
+	LEFT JOIN (SELECT ticker, MAX(date) AS last_bar FROM price_history GROUP BY ticker) h
+		ON h.ticker = r.ticker
+	ORDER BY r.ticker
+	`
+
+// backfillPriceHistory stores daily bars for every ticker in stock_info,
+// from its first rating (or since, when set) up to today, resuming after the
+// last stored bar. Tickers that fail are logged and skipped.
+func backfillPriceHistory(db *sql.DB, hp HistoryProvider, since, today time.Time) (int, error) {
+	rows, err := db.Query(backfillRangesQuery)
+	if err != nil {
+		return 0, fmt.Errorf("listing tickers: %w", err)
+	}
+	type tickerRange struct {
+		ticker string
+		from   time.Time
+	}
+	var todo []tickerRange
+	for rows.Next() {
+		var ticker string
+		var first, last dbTime
+		if err := rows.Scan(&ticker, &first, &last); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan ticker range: %w", err)
+		}
+		from := truncateDay(first.Time)
+		if !since.IsZero() {
+			from = truncateDay(since)
+		}
+		if last.Valid && !truncateDay(last.Time).Before(from) {
+			from = truncateDay(last.Time).AddDate(0, 0, 1)
+		}
+		todo = append(todo, tickerRange{ticker, from})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("listing tickers: %w", err)
+	}
+
+	prep, err := db.Prepare(insertBarStmt)
+	if err != nil {
+		return 0, fmt.Errorf("prepare bar insert: %w", err)
+	}
+	defer prep.Close()
+
+	to := truncateDay(today)
+	written := 0
+	for _, tr := range todo {
+		if tr.from.After(to) {
+			continue
+		}
+		bars, err := hp.DailyBars(tr.ticker, tr.from, to)
+		if err != nil {
+			log.Printf("warning: no pude obtener historial para %s: %v", tr.ticker, err)
+			continue
+		}
+		for _, bar := range bars {
+			if _, err := prep.Exec(tr.ticker, bar.Date, bar.Open, bar.High, bar.Low, bar.Close, bar.Volume); err != nil {
+				return written, fmt.Errorf("insert bar %s %s: %w", tr.ticker, bar.Date.Format(time.DateOnly), err)
+			}
+			written++
+		}
+	}
+	return written, nil
+}
+
+// sessionCloseUTC is when a daily bar's session is over, as an offset from
+// its Date: 16:00 in New York is 21:00 UTC at the latest.
+const sessionCloseUTC = 21 * time.Hour
+
+// lastClosedDay returns the day of the last session that closed strictly
+// before t, so a rating published before or during a session is priced at
+// the previous close rather than one it could not have seen.
+func lastClosedDay(t time.Time) time.Time {
+	day := truncateDay(t)
+	if !day.Add(sessionCloseUTC).Before(t) {
+		day = day.AddDate(0, 0, -1)
+	}
+	return day
+}
+
+// closeAsOf returns the last daily close known at t, that of lastClosedDay
+// or earlier.
+func closeAsOf(hp HistoryProvider, ticker string, t time.Time) (float64, error) {
+	return closeOnOrBefore(hp, ticker, lastClosedDay(t))
+}
+
+// closeOnOrBefore returns the last daily close on or before day, looking
+// back a week to cover weekends and market holidays.
+func closeOnOrBefore(hp HistoryProvider, ticker string, day time.Time) (float64, error) {
+	bars, err := hp.DailyBars(ticker, day.AddDate(0, 0, -7), day)
+	if err != nil {
+		return 0, err
+	}
+	var best *PriceBar
+	for i := range bars {
+		if bars[i].Date.After(day) {
+			continue
+		}
+		if best == nil || bars[i].Date.After(best.Date) {
+			best = &bars[i]
+		}
+	}
+	if best == nil {
+		return 0, fmt.Errorf("%w for %s on %s", errNoQuote, ticker, day.Format(time.DateOnly))
+	}
+	return best.Close, nil
+}
+
+// truncateDay drops the time of day, in UTC.
+func truncateDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// DailyBars reads daily bars from the chart endpoint's period1/period2 range.
+// Days Yahoo reports without a close (halts, holidays) are skipped.
+func (p *yahooProvider) DailyBars(ticker string, from, to time.Time) ([]PriceBar, error) {
+	params := url.Values{
+		"period1": {strconv.FormatInt(truncateDay(from).Unix(), 10)},
+		"period2": {strconv.FormatInt(truncateDay(to).AddDate(0, 0, 1).Unix(), 10)},
+	}
+	result, err := p.chart(ticker, params)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Indicators.Quote) == 0 {
+		return nil, nil
+	}
+	q := result.Indicators.Quote[0]
+
+	var bars []PriceBar
+	for i, ts := range result.Timestamp {
+		c, ok := valueAt(q.Close, i)
+		if !ok {
+			continue
+		}
+		bar := PriceBar{
+			// Timestamps are the session open; shift to exchange time for the date
+			Date:  truncateDay(time.Unix(ts+result.Meta.GMTOffset, 0)),
+			Close: c,
+		}
+		bar.Open, _ = valueAt(q.Open, i)
+		bar.High, _ = valueAt(q.High, i)
+		bar.Low, _ = valueAt(q.Low, i)
+		bar.Volume, _ = valueAt(q.Volume, i)
+		bars = append(bars, bar)
+	}
+	return bars, nil
+}
+
+// valueAt returns s[i] when it exists and is not null.
+func valueAt[T any](s []*T, i int) (T, bool) {
+	var zero T
+	if i >= len(s) || s[i] == nil {
+		return zero, false
+	}
+	return *s[i], true
+}
+
+// DailyBars reads Stooq's daily CSV download
+// (columns Date,Open,High,Low,Close,Volume).
+func (p *stooqProvider) DailyBars(ticker string, from, to time.Time) ([]PriceBar, error) {
+	u := fmt.Sprintf("%s/q/d/l/?s=%s&d1=%s&d2=%s&i=d", p.baseURL, url.QueryEscape(stooqSymbol(ticker)),
+		from.UTC().Format("20060102"), to.UTC().Format("20060102"))
+
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	r := csv.NewReader(resp.Body)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("decode CSV: %w", err)
+	}
+	// Unknown symbols or empty ranges come back as a bare "No data" line
+	if len(records) == 0 || !strings.EqualFold(records[0][0], "Date") {
+		return nil, fmt.Errorf("%w for %s", errNoQuote, ticker)
+	}
+	cols := map[string]int{}
+	for i, h := range records[0] {
+		cols[strings.ToLower(h)] = i
+	}
+	field := func(rec []string, name string) string {
+		if i, ok := cols[name]; ok && i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+
+	var bars []PriceBar
+	for _, rec := range records[1:] {
+		date, err := time.Parse(time.DateOnly, field(rec, "date"))
+		if err != nil {
+			return nil, fmt.Errorf("parsing date %q: %w", field(rec, "date"), err)
+		}
+		c, err := strconv.ParseFloat(field(rec, "close"), 64)
+		if err != nil {
+			continue
+		}
+		bar := PriceBar{Date: date, Close: c}
+		bar.Open, _ = strconv.ParseFloat(field(rec, "open"), 64)
+		bar.High, _ = strconv.ParseFloat(field(rec, "high"), 64)
+		bar.Low, _ = strconv.ParseFloat(field(rec, "low"), 64)
+		bar.Volume, _ = strconv.ParseInt(field(rec, "volume"), 10, 64)
+		bars = append(bars, bar)
+	}
+	return bars, nil
+}
+
+// DailyBars asks the providers that support history, in order.
+func (c *chainProvider) DailyBars(ticker string, from, to time.Time) ([]PriceBar, error) {
+	var bars []PriceBar
+	supportsHistory := func(p PriceProvider) bool {
+		_, ok := p.(HistoryProvider)
+		return ok
+	}
+	err := c.try(supportsHistory, func(p PriceProvider) error {
+		var err error
+		bars, err = p.(HistoryProvider).DailyBars(ticker, from, to)
+		return err
+	})
+	return bars, err
+}
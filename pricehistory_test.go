@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubHistory serves fixed bars for any ticker.
+type stubHistory struct {
+	bars      []PriceBar
+	requested map[string][2]time.Time
+}
+
+func (s *stubHistory) DailyBars(ticker string, from, to time.Time) ([]PriceBar, error) {
+	s.requested[ticker] = [2]time.Time{from, to}
+	return s.bars, nil
+}
+
+func TestYahooProvider_DailyBars(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "1736726400", r.URL.Query().Get("period1"))
+		// 2025-01-13 and 2025-01-14 at 14:30 UTC, second day without a close
+		fmt.Fprint(w, `{"chart":{"result":[{"meta":{"gmtoffset":-18000},
+			"timestamp":[1736778600,1736865000],
+			"indicators":{"quote":[{"open":[1.0,2.0],"high":[1.5,2.5],"low":[0.5,1.5],"close":[1.2,null],"volume":[100,200]}]}}],"error":null}}`)
+	}))
+	defer ts.Close()
+
+	from := time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC)
+	bars, err := newYahooProvider(ts.URL).DailyBars("TCK", from, from.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Len(t, bars, 1)
+	assert.Equal(t, from, bars[0].Date)
+	assert.Equal(t, 1.2, bars[0].Close)
+	assert.Equal(t, int64(100), bars[0].Volume)
+}
+
+func TestStooqProvider_DailyBars(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("s") != "tck.us" {
+			fmt.Fprint(w, "No data")
+			return
+		}
+		assert.Equal(t, "20250113", r.URL.Query().Get("d1"))
+		fmt.Fprint(w, "Date,Open,High,Low,Close,Volume\n2025-01-13,1,2,0.5,1.5,1000\n2025-01-14,1.5,2.5,1,2,2000\n")
+	}))
+	defer ts.Close()
+
+	p := newStooqProvider(ts.URL)
+	from := time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC)
+	bars, err := p.DailyBars("TCK", from, from.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Len(t, bars, 2)
+	assert.Equal(t, 2.0, bars[1].Close)
+	assert.Equal(t, int64(2000), bars[1].Volume)
+
+	_, err = p.DailyBars("ZZZ", from, from)
+	assert.ErrorIs(t, err, errNoQuote)
+}
+
+func TestChainProvider_DailyBarsSkipsProvidersWithoutHistory(t *testing.T) {
+	hist := &stubHistory{bars: []PriceBar{{Close: 3}}, requested: map[string][2]time.Time{}}
+	chain := newChainProvider(&fileProvider{}, struct {
+		*countingProvider
+		HistoryProvider
+	}{&countingProvider{name: "hist"}, hist})
+
+	bars, err := chain.DailyBars("TCK", time.Now(), time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, bars, 1)
+}
+
+func TestBackfillPriceHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	today := time.Date(2025, 1, 20, 15, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT r.ticker, r.first_rating, h.last_bar").
+		WillReturnRows(sqlmock.NewRows([]string{"ticker", "first_rating", "last_bar"}).
+			AddRow("NEW", time.Date(2025, 1, 10, 8, 0, 0, 0, time.UTC), nil).
+			AddRow("OLD", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC)).
+			AddRow("DONE", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC)))
+	prep := mock.ExpectPrepare("INSERT INTO price_history")
+	bar := PriceBar{Date: time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC), Open: 1, High: 2, Low: 0.5, Close: 1.5, Volume: 10}
+	prep.ExpectExec().WithArgs("NEW", bar.Date, 1.0, 2.0, 0.5, 1.5, int64(10)).WillReturnResult(sqlmock.NewResult(0, 1))
+	prep.ExpectExec().WithArgs("OLD", bar.Date, 1.0, 2.0, 0.5, 1.5, int64(10)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	hist := &stubHistory{bars: []PriceBar{bar}, requested: map[string][2]time.Time{}}
+	n, err := backfillPriceHistory(db, hist, time.Time{}, today)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+	assert.Equal(t, [2]time.Time{day(10), day(20)}, hist.requested["NEW"])
+	assert.Equal(t, [2]time.Time{day(18), day(20)}, hist.requested["OLD"])
+	assert.NotContains(t, hist.requested, "DONE")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCloseAsOf_UsesLastSessionClosedBeforeRating(t *testing.T) {
+	fri := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	hist := &stubHistory{
+		bars: []PriceBar{
+			{Date: fri.AddDate(0, 0, -1), Close: 9},
+			{Date: fri, Close: 10},
+		},
+		requested: map[string][2]time.Time{},
+	}
+
+	// A rating published on Sunday takes Friday's close
+	price, err := closeAsOf(hist, "TCK", time.Date(2025, 1, 12, 18, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, 10.0, price)
+	assert.Equal(t, [2]time.Time{fri.AddDate(0, 0, -6), fri.AddDate(0, 0, 1)}, hist.requested["TCK"])
+
+	// Before Friday's session closed, only Thursday's close was known
+	for _, at := range []time.Time{fri.Add(30 * time.Minute), fri.Add(13 * time.Hour), fri.Add(21 * time.Hour)} {
+		price, err = closeAsOf(hist, "TCK", at)
+		assert.NoError(t, err)
+		assert.Equal(t, 9.0, price, at)
+	}
+	price, err = closeAsOf(hist, "TCK", fri.Add(21*time.Hour+time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 10.0, price, "after the close")
+
+	hist.bars = nil
+	_, err = closeAsOf(hist, "TCK", fri)
+	assert.ErrorIs(t, err, errNoQuote)
+}
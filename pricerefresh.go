@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// refreshSummary reports the outcome of a price refresh.
+type refreshSummary struct {
+	Tickers int `json:"tickers"`
+	Updated int `json:"updated"`
+	Failed  int `json:"failed"`
+}
+
+// refreshPrices re-prices every distinct ticker in stock_info through
+// prices, using up to workers concurrent lookups. Tickers that cannot be
+// priced keep their previous price and timestamp.
+func refreshPrices(db *sql.DB, prices PriceProvider, workers int, now time.Time) (refreshSummary, error) {
+	var summary refreshSummary
+
+	rows, err := db.Query("SELECT DISTINCT ticker FROM stock_info ORDER BY ticker")
+	if err != nil {
+		return summary, fmt.Errorf("listing tickers: %w", err)
+	}
+	var tickers []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			rows.Close()
+			return summary, fmt.Errorf("scan ticker: %w", err)
+		}
+		tickers = append(tickers, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return summary, fmt.Errorf("listing tickers: %w", err)
+	}
+	summary.Tickers = len(tickers)
+
+	cache := newPriceCache(prices.CurrentPrice)
+	cache.Prefetch(tickers, workers)
+
+	for _, t := range tickers {
+		price, err := cache.Get(t)
+		if err != nil {
+			log.Printf("warning: no pude obtener precio para %s: %v", t, err)
+			summary.Failed++
+			continue
+		}
+		if _, err := db.Exec(
+			"UPDATE stock_info SET current_price=$2, price_updated_at=$3 WHERE ticker=$1",
+			t, price, now.UTC(),
+		); err != nil {
+			return summary, fmt.Errorf("update price %s: %w", t, err)
+		}
+		summary.Updated++
+	}
+	return summary, nil
+}
+
+// priceRefresher backs the admin trigger, allowing one refresh at a time.
+type priceRefresher struct {
+	db      *sql.DB
+	prices  PriceProvider
+	workers int
+	token   string // required bearer token; empty disables the endpoint
+	running atomic.Bool
+}
+
+func newPriceRefresher(db *sql.DB, prices PriceProvider, workers int) *priceRefresher {
+	return &priceRefresher{db: db, prices: prices, workers: workers, token: os.Getenv("ADMIN_TOKEN")}
+}
+
+// ServeHTTP handles POST /admin/refresh-prices, running the refresh
+// synchronously and returning its summary.
+func (p *priceRefresher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !p.authorized(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if !p.running.CompareAndSwap(false, true) {
+		http.Error(w, "price refresh already running", http.StatusConflict)
+		return
+	}
+	defer p.running.Store(false)
+
+	summary, err := refreshPrices(p.db, p.prices, p.workers, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+func (p *priceRefresher) authorized(r *http.Request) bool {
+	if p.token == "" {
+		return false
+	}
+	got := r.Header.Get("Authorization")
+	return subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+p.token)) == 1
+}
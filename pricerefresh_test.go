@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshPrices(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT DISTINCT ticker FROM stock_info").
+		WillReturnRows(sqlmock.NewRows([]string{"ticker"}).AddRow("AAA").AddRow("ZZZ"))
+	mock.ExpectExec("UPDATE stock_info SET current_price").
+		WithArgs("AAA", 12.5, now).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	prices := &fileProvider{prices: map[string]float64{"AAA": 12.5}}
+	summary, err := refreshPrices(db, prices, 2, now)
+	assert.NoError(t, err)
+	assert.Equal(t, refreshSummary{Tickers: 2, Updated: 1, Failed: 1}, summary)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPriceRefresher_Handler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	refresher := &priceRefresher{db: db, prices: &fileProvider{}, workers: 1, token: "secret"}
+
+	// Wrong method and missing token are rejected before touching the DB
+	rec := httptest.NewRecorder()
+	refresher.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/refresh-prices", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	refresher.ServeHTTP(rec, httptest.NewRequest("POST", "/admin/refresh-prices", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	mock.ExpectQuery("SELECT DISTINCT ticker FROM stock_info").
+		WillReturnRows(sqlmock.NewRows([]string{"ticker"}))
+	req := httptest.NewRequest("POST", "/admin/refresh-prices", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	refresher.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var summary refreshSummary
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&summary))
+	assert.Equal(t, refreshSummary{}, summary)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// With no ADMIN_TOKEN configured the endpoint stays closed
+	refresher.token = ""
+	rec = httptest.NewRecorder()
+	refresher.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
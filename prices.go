@@ -0,0 +1,277 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PriceProvider resolves the current market price of a ticker.
+type PriceProvider interface {
+	Name() string
+	CurrentPrice(ticker string) (float64, error)
+}
+
+const (
+	yahooBaseURL = "https://query1.finance.yahoo.com"
+	stooqBaseURL = "https://stooq.com"
+)
+
+// priceHTTPTimeout bounds every request made by the HTTP price providers.
+const priceHTTPTimeout = 10 * time.Second
+
+// newPriceProvider builds the provider described by spec, a comma-separated
+// fallback chain of "yahoo", "stooq" or "file:<path>" (a local CSV/JSON
+// fixture). "yahoo:<url>" and "stooq:<url>" call another host, such as
+// -mode=fake-upstream. Network sources go through a chainProvider, even
+// alone, so a per-source circuit breaker stops calling one that is down; a
+// lone file fixture is returned as is.
+func newPriceProvider(spec string) (PriceProvider, error) {
+	var providers []PriceProvider
+	for _, part := range strings.Split(spec, ",") {
+		p, err := newSinglePriceProvider(part)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	if _, local := providers[0].(*fileProvider); len(providers) == 1 && local {
+		return providers[0], nil
+	}
+	return newChainProvider(providers...), nil
+}
+
+func newSinglePriceProvider(spec string) (PriceProvider, error) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	switch name {
+	case "", "yahoo":
+		return newYahooProvider(cmp.Or(arg, yahooBaseURL)), nil
+	case "stooq":
+		return newStooqProvider(cmp.Or(arg, stooqBaseURL)), nil
+	case "file":
+		if arg == "" {
+			return nil, fmt.Errorf("price provider %q: missing file path", spec)
+		}
+		return loadFileProvider(arg)
+	default:
+		return nil, fmt.Errorf("unknown price provider %q; use 'yahoo[:<url>]', 'stooq[:<url>]' or 'file:<path>'", spec)
+	}
+}
+
+// yahooProvider reads the regular market price from Yahoo's chart endpoint.
+type yahooProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newYahooProvider(baseURL string) *yahooProvider {
+	return &yahooProvider{baseURL: baseURL, client: &http.Client{Timeout: priceHTTPTimeout, Transport: httpTransport}}
+}
+
+func (p *yahooProvider) Name() string { return "yahoo" }
+
+func (p *yahooProvider) CurrentPrice(ticker string) (float64, error) {
+	params := url.Values{"range": {"1d"}}
+	result, err := p.chart(ticker, params)
+	if err != nil {
+		return 0, err
+	}
+	return result.Meta.RegularMarketPrice, nil
+}
+
+// yahooChartResult is the part of a chart endpoint result we consume.
+type yahooChartResult struct {
+	Meta struct {
+		RegularMarketPrice float64 `json:"regularMarketPrice"`
+		GMTOffset          int64   `json:"gmtoffset"`
+	} `json:"meta"`
+	Timestamp  []int64 `json:"timestamp"`
+	Indicators struct {
+		Quote []struct {
+			Open   []*float64 `json:"open"`
+			High   []*float64 `json:"high"`
+			Low    []*float64 `json:"low"`
+			Close  []*float64 `json:"close"`
+			Volume []*int64   `json:"volume"`
+		} `json:"quote"`
+	} `json:"indicators"`
+}
+
+// chart calls the daily chart endpoint for ticker with extra query params
+// (range or period1/period2) and returns its first result.
+func (p *yahooProvider) chart(ticker string, params url.Values) (yahooChartResult, error) {
+	var none yahooChartResult
+	params.Set("region", "US")
+	params.Set("lang", "en-US")
+	params.Set("includePrePost", "false")
+	params.Set("interval", "1d")
+	u := fmt.Sprintf("%s/v8/finance/chart/%s?%s", p.baseURL, url.PathEscape(ticker), params.Encode())
+
+	// 1) Creamos la petición con User-Agent
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return none, fmt.Errorf("crear request: %w", err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; AcmeInc/1.0)")
+
+	// 2) Ejecutamos con timeout
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return none, fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 3) Si no es 200, devolvemos el body como error
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return none, fmt.Errorf("status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	// 4) Decodificamos JSON
+	var payload struct {
+		Chart struct {
+			Result []yahooChartResult `json:"result"`
+			Error  interface{}        `json:"error"`
+		} `json:"chart"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		// leemos el body completo para diagnóstico
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return none, fmt.Errorf("decode JSON: %w – body: %s", err, string(bodyBytes))
+	}
+
+	// 5) Manejo de error en la respuesta de Yahoo
+	if payload.Chart.Error != nil {
+		return none, fmt.Errorf("yahoo error: %v", payload.Chart.Error)
+	}
+	if len(payload.Chart.Result) == 0 {
+		return none, fmt.Errorf("sin resultado para %s: %w", ticker, errNoQuote)
+	}
+
+	return payload.Chart.Result[0], nil
+}
+
+// stooqProvider reads the last close from a Stooq-style CSV quote endpoint
+// (columns Symbol,Date,Time,Open,High,Low,Close,Volume).
+type stooqProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newStooqProvider(baseURL string) *stooqProvider {
+	return &stooqProvider{baseURL: baseURL, client: &http.Client{Timeout: priceHTTPTimeout, Transport: httpTransport}}
+}
+
+func (p *stooqProvider) Name() string { return "stooq" }
+
+// stooqSymbol maps a ticker to Stooq's lowercase, market-suffixed form,
+// e.g. "AAPL" -> "aapl.us".
+func stooqSymbol(ticker string) string {
+	symbol := strings.ToLower(ticker)
+	if !strings.Contains(symbol, ".") {
+		symbol += ".us"
+	}
+	return symbol
+}
+
+func (p *stooqProvider) CurrentPrice(ticker string) (float64, error) {
+	u := fmt.Sprintf("%s/q/l/?s=%s&f=sd2t2ohlcv&h&e=csv", p.baseURL, url.QueryEscape(stooqSymbol(ticker)))
+
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return 0, fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("status %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("decode CSV: %w", err)
+	}
+	if len(records) < 2 {
+		return 0, fmt.Errorf("%w for %s", errNoQuote, ticker)
+	}
+	col := -1
+	for i, h := range records[0] {
+		if strings.EqualFold(h, "Close") {
+			col = i
+		}
+	}
+	if col < 0 || col >= len(records[1]) {
+		return 0, fmt.Errorf("no Close column for %s", ticker)
+	}
+	// Unknown symbols come back as "N/D"
+	price, err := strconv.ParseFloat(records[1][col], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w for %s: %q", errNoQuote, ticker, records[1][col])
+	}
+	return price, nil
+}
+
+// fileProvider serves prices from a static ticker -> price table, so
+// ingestion can run fully offline.
+type fileProvider struct {
+	prices map[string]float64
+}
+
+func (p *fileProvider) Name() string { return "file" }
+
+func (p *fileProvider) CurrentPrice(ticker string) (float64, error) {
+	price, ok := p.prices[strings.ToUpper(ticker)]
+	if !ok {
+		return 0, fmt.Errorf("%w: no fixture price for %s", errNoQuote, ticker)
+	}
+	return price, nil
+}
+
+// loadFileProvider reads a JSON object ({"AAPL": 123.4}) or, for any other
+// extension, a CSV with "ticker,price" rows (a header row is optional).
+func loadFileProvider(path string) (*fileProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening price file: %w", err)
+	}
+	defer f.Close()
+
+	p := &fileProvider{prices: map[string]float64{}}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var raw map[string]float64
+		if err := json.NewDecoder(f).Decode(&raw); err != nil {
+			return nil, fmt.Errorf("decoding price file %s: %w", path, err)
+		}
+		for t, price := range raw {
+			p.prices[strings.ToUpper(t)] = price
+		}
+		return p, nil
+	}
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading price file %s: %w", path, err)
+	}
+	for i, rec := range records {
+		price, err := strconv.ParseFloat(strings.TrimSpace(rec[1]), 64)
+		if err != nil {
+			if i == 0 {
+				continue // header
+			}
+			return nil, fmt.Errorf("price file %s line %d: %w", path, i+1, err)
+		}
+		p.prices[strings.ToUpper(strings.TrimSpace(rec[0]))] = price
+	}
+	return p, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// --- yahooProvider tests ---
+func TestYahooProvider_Success(t *testing.T) {
+	// Mock Yahoo Finance JSON
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v8/finance/chart/ANY", r.URL.Path)
+		fmt.Fprintln(w, `{"chart":{"result":[{"meta":{"regularMarketPrice":123.45}}]}}`)
+	}))
+	defer ts.Close()
+
+	price, err := newYahooProvider(ts.URL).CurrentPrice("ANY")
+	assert.NoError(t, err)
+	assert.Equal(t, 123.45, price)
+}
+
+func TestYahooProvider_HTTPError(t *testing.T) {
+	// Server returns 500
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "internal error")
+	}))
+	defer ts.Close()
+
+	_, err := newYahooProvider(ts.URL).CurrentPrice("ANY")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "status 500")
+}
+
+// --- stooqProvider tests ---
+func TestStooqProvider(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("s") {
+		case "aapl.us":
+			fmt.Fprint(w, "Symbol,Date,Time,Open,High,Low,Close,Volume\nAAPL.US,2025-01-13,22:00:00,233.5,234.67,229.72,234.4,49630725\n")
+		default:
+			fmt.Fprint(w, "Symbol,Date,Time,Open,High,Low,Close,Volume\nZZZ.US,N/D,N/D,N/D,N/D,N/D,N/D,N/D\n")
+		}
+	}))
+	defer ts.Close()
+
+	p := newStooqProvider(ts.URL)
+	price, err := p.CurrentPrice("AAPL")
+	assert.NoError(t, err)
+	assert.Equal(t, 234.4, price)
+
+	_, err = p.CurrentPrice("ZZZ")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no quote")
+}
+
+// --- fileProvider tests ---
+func TestFileProvider_CSVAndJSON(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "prices.csv")
+	jsonPath := filepath.Join(dir, "prices.json")
+	assert.NoError(t, os.WriteFile(csvPath, []byte("ticker,price\naapl,190.5\nMSFT,410\n"), 0o644))
+	assert.NoError(t, os.WriteFile(jsonPath, []byte(`{"nvda": 130.25}`), 0o644))
+
+	p, err := newPriceProvider("file:" + csvPath)
+	assert.NoError(t, err)
+	price, err := p.CurrentPrice("AAPL")
+	assert.NoError(t, err)
+	assert.Equal(t, 190.5, price)
+	_, err = p.CurrentPrice("NVDA")
+	assert.Error(t, err)
+
+	p, err = newPriceProvider("file:" + jsonPath)
+	assert.NoError(t, err)
+	price, err = p.CurrentPrice("nvda")
+	assert.NoError(t, err)
+	assert.Equal(t, 130.25, price)
+}
+
+func TestNewPriceProvider_Unknown(t *testing.T) {
+	p, err := newPriceProvider("")
+	assert.NoError(t, err)
+	assert.Equal(t, "yahoo", p.Name())
+
+	_, err = newPriceProvider("bloomberg")
+	assert.Error(t, err)
+	_, err = newPriceProvider("file:")
+	assert.Error(t, err)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ratingLevel is the canonical level a raw rating maps to, with the score
+// handleRecommend gives it.
+type ratingLevel struct {
+	Canonical string `json:"canonical"`
+	Score     int    `json:"score"`
+}
+
+// Canonical rating levels.
+const (
+	levelStrongBuy  = "Strong Buy"
+	levelBuy        = "Buy"
+	levelHold       = "Hold"
+	levelSell       = "Sell"
+	levelStrongSell = "Strong Sell"
+)
+
+// defaultRatingAliases is the taxonomy the 0009 migration seeds into
+// rating_aliases, keyed by ratingAliasKey. It backs the in-memory repository.
+var defaultRatingAliases = map[string]ratingLevel{
+	"strong buy":          {levelStrongBuy, 2},
+	"outperform":          {levelStrongBuy, 2},
+	"market outperform":   {levelStrongBuy, 2},
+	"sector outperform":   {levelStrongBuy, 2},
+	"top pick":            {levelStrongBuy, 2},
+	"buy":                 {levelBuy, 1},
+	"overweight":          {levelBuy, 1},
+	"positive":            {levelBuy, 1},
+	"accumulate":          {levelBuy, 1},
+	"moderate buy":        {levelBuy, 1},
+	"speculative buy":     {levelBuy, 1},
+	"hold":                {levelHold, 0},
+	"neutral":             {levelHold, 0},
+	"equal weight":        {levelHold, 0},
+	"market perform":      {levelHold, 0},
+	"sector perform":      {levelHold, 0},
+	"sector weight":       {levelHold, 0},
+	"peer perform":        {levelHold, 0},
+	"in line":             {levelHold, 0},
+	"unchanged":           {levelHold, 0},
+	"sell":                {levelSell, -1},
+	"underweight":         {levelSell, -1},
+	"reduce":              {levelSell, -1},
+	"negative":            {levelSell, -1},
+	"moderate sell":       {levelSell, -1},
+	"strong sell":         {levelStrongSell, -2},
+	"underperform":        {levelStrongSell, -2},
+	"market underperform": {levelStrongSell, -2},
+	"sector underperform": {levelStrongSell, -2},
+}
+
+// ratingAliasKey normalizes a raw rating for lookup in rating_aliases:
+// "Strong-Buy " and "strong buy" are the same alias. The 0009 migration
+// applies the same rule in SQL.
+func ratingAliasKey(raw string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(raw), "-", " "))
+}
+
+// ratingCount is a raw rating and how often it was seen.
+type ratingCount struct {
+	Rating string `json:"rating"`
+	Count  int    `json:"count"`
+}
+
+// ratingTaxonomy maps raw ratings to canonical levels and remembers the raw
+// ratings it could not map during a run. A nil taxonomy maps nothing.
+type ratingTaxonomy struct {
+	aliases map[string]ratingLevel
+
+	mu       sync.Mutex
+	unmapped map[string]int
+}
+
+func newRatingTaxonomy(aliases map[string]ratingLevel) *ratingTaxonomy {
+	return &ratingTaxonomy{aliases: aliases, unmapped: map[string]int{}}
+}
+
+// lookup returns the level of raw without recording misses.
+func (t *ratingTaxonomy) lookup(raw string) (ratingLevel, bool) {
+	if t == nil {
+		return ratingLevel{}, false
+	}
+	level, ok := t.aliases[ratingAliasKey(raw)]
+	return level, ok
+}
+
+// normalize returns the canonical level of raw, or "" when raw is empty or
+// unknown. Unknown ratings are counted for unmappedRatings.
+func (t *ratingTaxonomy) normalize(raw string) string {
+	if t == nil || strings.TrimSpace(raw) == "" {
+		return ""
+	}
+	level, ok := t.lookup(raw)
+	if !ok {
+		t.mu.Lock()
+		t.unmapped[raw]++
+		t.mu.Unlock()
+	}
+	return level.Canonical
+}
+
+// unmappedRatings lists the ratings normalize could not map, most frequent
+// first.
+func (t *ratingTaxonomy) unmappedRatings() []ratingCount {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return sortedRatingCounts(t.unmapped)
+}
+
+func sortedRatingCounts(counts map[string]int) []ratingCount {
+	out := make([]ratingCount, 0, len(counts))
+	for rating, n := range counts {
+		out = append(out, ratingCount{Rating: rating, Count: n})
+	}
+	slices.SortFunc(out, func(a, b ratingCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Rating, b.Rating)
+	})
+	return out
+}
+
+// formatRatingCounts renders counts for log lines, e.g. "Positive (3), Add (1)".
+func formatRatingCounts(counts []ratingCount) string {
+	parts := make([]string, len(counts))
+	for i, c := range counts {
+		parts[i] = fmt.Sprintf("%s (%d)", c.Rating, c.Count)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// queryRatingTaxonomy loads rating_aliases.
+func queryRatingTaxonomy(db *sql.DB) (*ratingTaxonomy, error) {
+	rows, err := db.Query("SELECT alias, canonical, score FROM rating_aliases")
+	if err != nil {
+		return nil, fmt.Errorf("loading rating aliases: %w", err)
+	}
+	defer rows.Close()
+	aliases := map[string]ratingLevel{}
+	for rows.Next() {
+		var alias string
+		var level ratingLevel
+		if err := rows.Scan(&alias, &level.Canonical, &level.Score); err != nil {
+			return nil, fmt.Errorf("scanning rating alias: %w", err)
+		}
+		aliases[alias] = level
+	}
+	return newRatingTaxonomy(aliases), rows.Err()
+}
+
+// unmappedRatingsStmt counts stored ratings with no alias, applying
+// ratingAliasKey in SQL so aliases added since ingest are honoured.
+const unmappedRatingsStmt = `
+	SELECT rating, COUNT(*) FROM (
+		SELECT rating_from AS rating FROM stock_info
+		UNION ALL
+		SELECT rating_to FROM stock_info
+	) r
+	WHERE TRIM(rating) <> ''
+		AND NOT EXISTS (SELECT 1 FROM rating_aliases a WHERE a.alias = LOWER(REPLACE(TRIM(r.rating), '-', ' ')))
+	GROUP BY rating
+	ORDER BY COUNT(*) DESC, rating`
+
+// queryUnmappedRatings runs unmappedRatingsStmt.
+func queryUnmappedRatings(db *sql.DB) ([]ratingCount, error) {
+	rows, err := db.Query(unmappedRatingsStmt)
+	if err != nil {
+		return nil, fmt.Errorf("loading unmapped ratings: %w", err)
+	}
+	defer rows.Close()
+	out := []ratingCount{}
+	for rows.Next() {
+		var c ratingCount
+		if err := rows.Scan(&c.Rating, &c.Count); err != nil {
+			return nil, fmt.Errorf("scanning unmapped rating: %w", err)
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
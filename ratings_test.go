@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRatingTaxonomy_NormalizeCountsUnmapped(t *testing.T) {
+	tax := newRatingTaxonomy(defaultRatingAliases)
+	assert.Equal(t, levelStrongBuy, tax.normalize("Strong-Buy"))
+	assert.Equal(t, levelHold, tax.normalize(" In-Line "))
+	assert.Equal(t, levelBuy, tax.normalize("Positive"))
+	assert.Equal(t, "", tax.normalize(""), "an empty rating is not unmapped")
+	assert.Equal(t, "", tax.normalize("Conviction List"))
+	tax.normalize("Conviction List")
+	tax.normalize("Add")
+
+	assert.Equal(t, []ratingCount{{"Conviction List", 2}, {"Add", 1}}, tax.unmappedRatings())
+	level, ok := tax.lookup("Underperform")
+	assert.True(t, ok)
+	assert.Equal(t, -2, level.Score)
+	_, ok = tax.lookup("Add")
+	assert.False(t, ok)
+
+	var none *ratingTaxonomy
+	assert.Equal(t, "", none.normalize("Buy"))
+	assert.Nil(t, none.unmappedRatings())
+}
+
+func TestRatingAliases_SQLite(t *testing.T) {
+	db := openTestSQLite(t)
+	repo := newSQLiteRepository(db)
+
+	// The migration seeds exactly the in-memory defaults
+	tax, err := repo.RatingTaxonomy()
+	require.NoError(t, err)
+	assert.Equal(t, defaultRatingAliases, tax.aliases)
+
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, r := range []Rating{
+		{Ticker: "A", RatingFrom: "Add", RatingTo: "Top Pick", Time: at},
+		{Ticker: "B", RatingFrom: "Hold", RatingTo: "Add", Time: at},
+		{Ticker: "C", RatingFrom: "", RatingTo: "Speculative Sell", Time: at},
+	} {
+		_, err := repo.InsertRating(r)
+		require.NoError(t, err)
+	}
+	unmapped, err := repo.UnmappedRatings()
+	require.NoError(t, err)
+	assert.Equal(t, []ratingCount{{"Add", 2}, {"Speculative Sell", 1}}, unmapped)
+
+	// New aliases apply to ratings already stored
+	_, err = db.Exec("INSERT INTO rating_aliases (alias, canonical, score) VALUES ('add', 'Buy', 1)")
+	require.NoError(t, err)
+	unmapped, err = repo.UnmappedRatings()
+	require.NoError(t, err)
+	assert.Equal(t, []ratingCount{{"Speculative Sell", 1}}, unmapped)
+}
+
+func TestRatingAliasesMigration_BackfillsCanonical(t *testing.T) {
+	db := openTestSQLite(t)
+	migs, err := embeddedMigrations(sqliteDialect)
+	require.NoError(t, err)
+	_, err = migrateDown(db, sqliteDialect, migs, len(migs)-8)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`INSERT INTO stock_info (ticker, company, brokerage, action, rating_from, rating_to, time)
+		VALUES ('OLD', '', 'Brok', '', 'Market-Perform', 'Sector Outperform', '2025-01-01 00:00:00+00:00')`)
+	require.NoError(t, err)
+	_, err = migrateUp(db, sqliteDialect, migs, 0)
+	require.NoError(t, err)
+
+	r, err := newSQLiteRepository(db).LatestForTicker("OLD")
+	require.NoError(t, err)
+	assert.Equal(t, levelHold, r.RatingFromCanonical)
+	assert.Equal(t, levelStrongBuy, r.RatingToCanonical)
+}
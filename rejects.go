@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// fieldError is a StockItem field that failed to parse, named by its JSON key.
+type fieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *fieldError) Error() string { return e.Err.Error() }
+func (e *fieldError) Unwrap() error { return e.Err }
+
+// isItemError reports whether a database error was caused by the row being
+// written (bad encoding, out of range value, violated constraint) rather than
+// by the connection or the server, so the item can be rejected on its own
+// instead of failing the page.
+func isItemError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		class := pqErr.Code.Class()
+		return class == "22" || class == "23" // data exception, integrity constraint
+	}
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) {
+		switch liteErr.Code() & 0xff {
+		case sqlite3.SQLITE_CONSTRAINT, sqlite3.SQLITE_MISMATCH, sqlite3.SQLITE_TOOBIG:
+			return true
+		}
+	}
+	return false
+}
+
+// rejectedField returns the item field cause blames, if it names one.
+func rejectedField(cause error) sql.NullString {
+	var fe *fieldError
+	if errors.As(cause, &fe) {
+		return sql.NullString{String: fe.Field, Valid: true}
+	}
+	var pqErr *pq.Error
+	if errors.As(cause, &pqErr) && pqErr.Column != "" {
+		return sql.NullString{String: pqErr.Column, Valid: true}
+	}
+	return sql.NullString{}
+}
+
+// rejectItem stores item in rejected_items within the page transaction, so
+// a rolled back page does not leave dead letters behind for its retry.
+func rejectItem(tx *sql.Tx, runID int64, item *StockItem, cause error) error {
+	log.Printf("warning: rejected ticker %s: %v", item.Ticker, cause)
+	raw, err := item.rawJSON()
+	if err != nil {
+		return fmt.Errorf("encoding rejected item: %w", err)
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO rejected_items (run_id, raw, field, error) VALUES ($1, $2, $3, $4)",
+		runID, string(raw), rejectedField(cause), cause.Error(),
+	); err != nil {
+		return fmt.Errorf("%w: reject item: %w", errExecInsert, err)
+	}
+	return nil
+}
+
+// rejectedItem is a pending dead letter.
+type rejectedItem struct {
+	ID  int64
+	Raw string
+}
+
+// pendingRejects lists the unresolved rejected items, oldest first.
+func pendingRejects(db *sql.DB) ([]rejectedItem, error) {
+	rows, err := db.Query("SELECT id, raw FROM rejected_items WHERE resolved_at IS NULL ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("loading rejected items: %w", err)
+	}
+	defer rows.Close()
+
+	var pending []rejectedItem
+	for rows.Next() {
+		var ri rejectedItem
+		if err := rows.Scan(&ri.ID, &ri.Raw); err != nil {
+			return nil, fmt.Errorf("scanning rejected item: %w", err)
+		}
+		pending = append(pending, ri)
+	}
+	return pending, rows.Err()
+}
+
+// reprocessRejects retries every unresolved rejected item with the current
+// parsing rules. Items that now store are marked resolved; the others get
+// their field and error updated. Failed counts the items still rejected.
+func reprocessRejects(db *sql.DB, prep *sql.Stmt, lookups ingestLookups) (fetchSummary, error) {
+	var counts fetchSummary
+	// Read everything first: SQLite runs on a single connection
+	pending, err := pendingRejects(db)
+	if err != nil {
+		return counts, err
+	}
+
+	for _, ri := range pending {
+		outcome, cause := retryReject(db, prep, ri, lookups)
+		if cause != nil {
+			if errors.Is(cause, errExecInsert) && !isItemError(cause) {
+				return counts, fmt.Errorf("rejected item %d: %w", ri.ID, cause)
+			}
+			if _, err := db.Exec(
+				"UPDATE rejected_items SET attempts=attempts+1, field=$2, error=$3 WHERE id=$1",
+				ri.ID, rejectedField(cause), cause.Error(),
+			); err != nil {
+				return counts, fmt.Errorf("updating rejected item %d: %w", ri.ID, err)
+			}
+			counts.Failed++
+			continue
+		}
+		counts.record(outcome)
+	}
+	return counts, nil
+}
+
+// retryReject upserts one rejected item, registers its security and marks it
+// resolved in the same transaction.
+func retryReject(db *sql.DB, prep *sql.Stmt, ri rejectedItem, lookups ingestLookups) (upsertOutcome, error) {
+	var item StockItem
+	if err := json.Unmarshal([]byte(ri.Raw), &item); err != nil {
+		return 0, fmt.Errorf("decoding raw item: %w", err)
+	}
+	if err := lookups.Brokerages.Resolve([]string{item.Brokerage}); err != nil {
+		return 0, fmt.Errorf("%w: %w", errExecInsert, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("%w: begin: %w", errExecInsert, err)
+	}
+	defer tx.Rollback()
+
+	outcome, err := insertStockItem(tx.Stmt(prep), &item, lookups)
+	if err != nil {
+		return 0, err
+	}
+	if err := syncSecurities(tx, []StockItem{item}); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(
+		"UPDATE rejected_items SET attempts=attempts+1, resolved_at=CURRENT_TIMESTAMP WHERE id=$1", ri.ID,
+	); err != nil {
+		return 0, fmt.Errorf("%w: resolve: %w", errExecInsert, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%w: commit: %w", errExecInsert, err)
+	}
+	return outcome, nil
+}
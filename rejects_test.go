@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsItemError(t *testing.T) {
+	assert.True(t, isItemError(fmt.Errorf("%w: %w", errExecInsert, &pq.Error{Code: "22021"})))
+	assert.True(t, isItemError(&pq.Error{Code: "23502"}))
+	assert.False(t, isItemError(&pq.Error{Code: "40P01"}), "deadlocks are retried with the page")
+	assert.False(t, isItemError(fmt.Errorf("connection reset")))
+	assert.False(t, isItemError(nil))
+}
+
+func TestCopyItems_RefusedBatchFallsBackToRows(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	badBytes := &pq.Error{Code: "22021", Message: "invalid byte sequence for encoding \"UTF8\": 0x00"}
+	prep := mock.ExpectPrepare("INSERT INTO stock_info")
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TEMP TABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+	copyStmt := mock.ExpectPrepare("COPY")
+	copyStmt.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	copyStmt.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	copyStmt.ExpectExec().WithoutArgs().WillReturnError(badBytes)
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT batch").WillReturnResult(sqlmock.NewResult(0, 0))
+	// Row by row: the good item is stored, the bad one rejected
+	mock.ExpectExec("SAVEPOINT item").WillReturnResult(sqlmock.NewResult(0, 0))
+	prep.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"inserted"}).AddRow(true))
+	mock.ExpectExec("RELEASE SAVEPOINT item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT item").WillReturnResult(sqlmock.NewResult(0, 0))
+	prep.ExpectQuery().WillReturnError(badBytes)
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RELEASE SAVEPOINT item").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO rejected_items").WithArgs(4, sqlmock.AnyArg(), nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("RELEASE SAVEPOINT batch").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	stmt, err := db.Prepare(insertStmt)
+	require.NoError(t, err)
+	f := &pageFetcher{db: db, prep: stmt, prices: newPriceCache(testPrices.CurrentPrice), batchSize: 10}
+	tx, err := db.Begin()
+	require.NoError(t, err)
+
+	at := time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+	counts, stored, err := f.copyItems(tx, 4, []StockItem{
+		{Ticker: "TCK", Company: "Good", Time: at},
+		{Ticker: "TCK", Company: "Bad\x00", Time: at},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, fetchSummary{Inserted: 1, Failed: 1}, counts)
+	assert.Equal(t, []StockItem{{Ticker: "TCK", Company: "Good", Time: at}}, stored)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRejectsAndReprocess_SQLite(t *testing.T) {
+	const badItem = `{"ticker":"TCK","company":"Comp","brokerage":"Brok","action":"Act","rating_from":"Hold","rating_to":"Buy","target_from":"TBD","target_to":"$2","time":"2025-01-13T00:30:05Z"}`
+	restore := stubUpstream(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"items":[%s],"next_page":""}`, badItem)
+	})
+	defer restore()
+
+	db := openTestSQLite(t)
+	prep, err := db.Prepare(sqliteDialect.InsertStmt)
+	require.NoError(t, err)
+	defer prep.Close()
+
+	summary, err := fetchAndStoreAllPages(db, prep, fetchOptions{Prices: testPrices})
+	require.NoError(t, err)
+	assert.Equal(t, fetchSummary{Failed: 1}, summary)
+
+	var runID int64
+	var raw, errText string
+	var field sql.NullString
+	require.NoError(t, db.QueryRow("SELECT run_id, raw, field, error FROM rejected_items").Scan(&runID, &raw, &field, &errText))
+	assert.Equal(t, int64(1), runID)
+	assert.Equal(t, badItem, raw, "the item is kept verbatim")
+	assert.Equal(t, "target_from", field.String)
+	assert.Contains(t, errText, "TBD")
+
+	// A rejected item the current rules accept, as after a parser fix
+	fixed := `{"ticker":"FIX","brokerage":"Brok","rating_to":"Buy","target_from":"$1","time":"2025-01-14T00:00:00Z"}`
+	_, err = db.Exec("INSERT INTO rejected_items (run_id, raw, field, error) VALUES (1, $1, 'target_from', 'old rules')", fixed)
+	require.NoError(t, err)
+
+	summary, err = reprocessRejects(db, prep, ingestLookups{Current: testPrices.CurrentPrice})
+	require.NoError(t, err)
+	assert.Equal(t, fetchSummary{Inserted: 1, Failed: 1}, summary)
+
+	latest, err := newSQLiteRepository(db).LatestForTicker("FIX")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, *latest.TargetFrom)
+	sec, err := newSQLiteRepository(db).Security("FIX")
+	require.NoError(t, err, "a recovered ticker is registered")
+	assert.Equal(t, "FIX", sec.Ticker)
+	_, err = newSQLiteRepository(db).Security("TCK")
+	assert.ErrorIs(t, err, errNotFound, "a reject still failing is not")
+
+	var pending, attempts int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*), MAX(attempts) FROM rejected_items WHERE resolved_at IS NULL").Scan(&pending, &attempts))
+	assert.Equal(t, 1, pending)
+	assert.Equal(t, 2, attempts)
+
+	// Resolved items are not retried again
+	summary, err = reprocessRejects(db, prep, ingestLookups{Current: testPrices.CurrentPrice})
+	require.NoError(t, err)
+	assert.Equal(t, fetchSummary{Failed: 1}, summary)
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errNotFound is returned by repository lookups that match no rating.
+var errNotFound = errors.New("not found")
+
+// StockRepository is the rating store behind the HTTP handlers.
+type StockRepository interface {
+	// ListRatings returns one page of ratings matching f.
+	ListRatings(f RatingFilter) ([]Rating, error)
+	// EachRating calls fn with every rating matching f, in order, without
+	// holding them in memory. An error from fn stops the walk and is
+	// returned.
+	EachRating(f RatingFilter, fn func(Rating) error) error
+	// LatestForTicker returns the most recent rating of ticker, or errNotFound.
+	LatestForTicker(ticker string) (Rating, error)
+	// LatestPerTicker returns the most recent rating of every ticker matching f.
+	LatestPerTicker(f LatestFilter) ([]Rating, error)
+	// InsertRating upserts r on its natural key (ticker, brokerage, time,
+	// rating_to, target_to).
+	InsertRating(r Rating) (upsertOutcome, error)
+	// FXRates returns the USD value of one unit of each known currency.
+	FXRates() (map[string]float64, error)
+	// RatingTaxonomy returns the rating_aliases mapping.
+	RatingTaxonomy() (*ratingTaxonomy, error)
+	// UnmappedRatings counts stored raw ratings that no alias maps.
+	UnmappedRatings() ([]ratingCount, error)
+	// Brokerages lists the canonical brokerages with their aliases.
+	Brokerages() ([]Brokerage, error)
+	// BrokerageSuggestions lists brokerages that look like another one.
+	BrokerageSuggestions() ([]brokerageSuggestion, error)
+	// Securities lists the securities master by ticker.
+	Securities() ([]Security, error)
+	// Security returns the security of ticker, or errNotFound.
+	Security(ticker string) (Security, error)
+}
+
+// Rating is a stored analyst rating with its targets and prices parsed.
+type Rating struct {
+	Ticker    string
+	Company   string
+	Brokerage string
+	// Canonical brokerage; nil until the name is resolved
+	BrokerageID *int64
+	Action      string
+	ActionType  ratingAction // empty when Action is unclassified
+	RatingFrom  string
+	RatingTo    string
+	// Canonical levels of RatingFrom/RatingTo; empty when unmapped
+	RatingFromCanonical string
+	RatingToCanonical   string
+	TargetFrom          *float64
+	TargetTo            *float64
+	Currency            string // ISO code of the targets; empty means baseCurrency
+	Time                time.Time
+
+	CurrentPrice   *float64
+	PriceAtRating  *float64
+	PriceUpdatedAt *time.Time
+
+	// Joined from securities; nil when the ticker is not registered
+	Security *Security
+}
+
+// item renders r in the API representation.
+func (r Rating) item() StockItem {
+	return StockItem{
+		Ticker:              r.Ticker,
+		Company:             r.Company,
+		Brokerage:           r.Brokerage,
+		BrokerageID:         r.BrokerageID,
+		Action:              r.Action,
+		ActionType:          string(r.ActionType),
+		RatingFrom:          r.RatingFrom,
+		RatingTo:            r.RatingTo,
+		RatingFromCanonical: r.RatingFromCanonical,
+		RatingToCanonical:   r.RatingToCanonical,
+		TargetFrom:          formatTarget(r.TargetFrom),
+		TargetTo:            formatTarget(r.TargetTo),
+		Currency:            r.currency(),
+		Time:                r.Time.Format(time.RFC3339Nano),
+		CurrentPrice:        r.CurrentPrice,
+		PriceAtRating:       r.PriceAtRating,
+		Security:            r.Security,
+	}
+}
+
+// currency is the ISO code r's targets are in.
+func (r Rating) currency() string {
+	if r.Currency == "" {
+		return baseCurrency
+	}
+	return r.Currency
+}
+
+// priceCurrency is the ISO code r's prices are quoted in: the listing
+// currency of its security, else baseCurrency.
+func (r Rating) priceCurrency() string {
+	if r.Security == nil || r.Security.Currency == "" {
+		return baseCurrency
+	}
+	return r.Security.Currency
+}
+
+func formatTarget(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+// RatingFilter selects and orders a page of ratings. Nil bounds and empty
+// lists don't filter.
+type RatingFilter struct {
+	Search       string // case-insensitive substring of any text field
+	Actions      []string
+	ActionTypes  []string // classified actions, see ratingActions
+	Brokerages   []string
+	BrokerageIDs []int64 // canonical brokerages, matching all their aliases
+	RatingFrom   []string
+	RatingTo     []string
+	Sectors      []string // of the joined security
+	Exchanges    []string
+
+	MinTargetFrom, MaxTargetFrom *float64
+	MinTargetTo, MaxTargetTo     *float64
+	DateFrom, DateTo             *time.Time
+
+	Sort   string // one of ratingSortColumns
+	Desc   bool
+	Limit  int // <= 0 returns every match
+	Offset int
+}
+
+// ratingSortColumns are the columns ListRatings can order by.
+var ratingSortColumns = map[string]bool{
+	"ticker":          true,
+	"company":         true,
+	"brokerage":       true,
+	"action":          true,
+	"rating_from":     true,
+	"rating_to":       true,
+	"target_from":     true,
+	"target_to":       true,
+	"time":            true,
+	"current_price":   true,
+	"price_at_rating": true,
+}
+
+// LatestFilter narrows LatestPerTicker.
+type LatestFilter struct {
+	// Priced only considers ratings with a non-zero current price, so a
+	// ticker is represented by its latest priced rating.
+	Priced bool
+	// PricedSince, when set, only considers prices refreshed at or after it.
+	PricedSince time.Time
+}
+
+// pgRepository is the Postgres StockRepository.
+type pgRepository struct {
+	db *sql.DB
+}
+
+func newPostgresRepository(db *sql.DB) *pgRepository {
+	return &pgRepository{db: db}
+}
+
+// ratingColumns is the select list scanned by scanRating, read from
+// ratingSource.
+const ratingColumns = "ticker, stock_info.company AS company, brokerage, action, rating_from, rating_to, target_from, target_to, time, current_price, price_at_rating, price_updated_at, target_currency, rating_from_canonical, rating_to_canonical, brokerage_id, action_type, " +
+	"sec.company AS security_company, sec.exchange, sec.sector, sec.industry, sec.currency, sec.active"
+
+// ratingSource joins each rating to its security, if registered.
+const ratingSource = "stock_info LEFT JOIN securities sec USING (ticker)"
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRating(row rowScanner) (Rating, error) {
+	var r Rating
+	var tf, tt, cp, pr sql.NullFloat64
+	var pricedAt sql.NullTime
+	var fromLevel, toLevel, actionType sql.NullString
+	var brokerageID sql.NullInt64
+	var secCompany, secExchange, secSector, secIndustry, secCurrency sql.NullString
+	var secActive sql.NullBool
+	if err := row.Scan(
+		&r.Ticker, &r.Company, &r.Brokerage, &r.Action, &r.RatingFrom, &r.RatingTo,
+		&tf, &tt, &r.Time, &cp, &pr, &pricedAt, &r.Currency, &fromLevel, &toLevel, &brokerageID, &actionType,
+		&secCompany, &secExchange, &secSector, &secIndustry, &secCurrency, &secActive,
+	); err != nil {
+		return Rating{}, err
+	}
+	if secCompany.Valid {
+		r.Security = &Security{
+			Ticker: r.Ticker, Company: secCompany.String,
+			Exchange: secExchange.String, Sector: secSector.String, Industry: secIndustry.String,
+			Currency: secCurrency.String, Active: secActive.Bool,
+		}
+	}
+	if brokerageID.Valid {
+		r.BrokerageID = &brokerageID.Int64
+	}
+	r.RatingFromCanonical, r.RatingToCanonical = fromLevel.String, toLevel.String
+	r.ActionType = ratingAction(actionType.String)
+	r.TargetFrom = nullFloatPtr(tf)
+	r.TargetTo = nullFloatPtr(tt)
+	r.CurrentPrice = nullFloatPtr(cp)
+	r.PriceAtRating = nullFloatPtr(pr)
+	if pricedAt.Valid {
+		r.PriceUpdatedAt = &pricedAt.Time
+	}
+	return r, nil
+}
+
+func scanRatings(rows *sql.Rows) ([]Rating, error) {
+	defer rows.Close()
+	ratings := []Rating{}
+	for rows.Next() {
+		r, err := scanRating(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan rating: %w", err)
+		}
+		ratings = append(ratings, r)
+	}
+	return ratings, rows.Err()
+}
+
+// eachRating scans rows one at a time into fn.
+func eachRating(rows *sql.Rows, fn func(Rating) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		r, err := scanRating(rows)
+		if err != nil {
+			return fmt.Errorf("scan rating: %w", err)
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// listQuery builds the SQL and arguments for ListRatings, searching with the
+// given case-insensitive LIKE operator.
+func listQuery(f RatingFilter, like string) (string, []any) {
+	var filters []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Search != "" {
+		// Search multiple fields with case-insensitive match
+		p := arg("%" + f.Search + "%")
+		filters = append(filters, fmt.Sprintf("(ticker %[1]s %[2]s OR stock_info.company %[1]s %[2]s OR sec.company %[1]s %[2]s OR brokerage %[1]s %[2]s OR action %[1]s %[2]s OR rating_from %[1]s %[2]s OR rating_to %[1]s %[2]s)", like, p))
+	}
+
+	// Helper to add IN(...) filters
+	addInFilter := func(field string, vals []string) {
+		if len(vals) == 0 {
+			return
+		}
+		var ph []string
+		for _, v := range vals {
+			ph = append(ph, arg(v))
+		}
+		filters = append(filters, fmt.Sprintf("%s IN (%s)", field, strings.Join(ph, ",")))
+	}
+	addInFilter("action", f.Actions)
+	addInFilter("action_type", f.ActionTypes)
+	addInFilter("brokerage", f.Brokerages)
+	if len(f.BrokerageIDs) > 0 {
+		var ph []string
+		for _, id := range f.BrokerageIDs {
+			ph = append(ph, arg(id))
+		}
+		filters = append(filters, fmt.Sprintf("brokerage_id IN (%s)", strings.Join(ph, ",")))
+	}
+	addInFilter("rating_from", f.RatingFrom)
+	addInFilter("rating_to", f.RatingTo)
+	addInFilter("sec.sector", f.Sectors)
+	addInFilter("sec.exchange", f.Exchanges)
+
+	addBound := func(cond string, v any) {
+		filters = append(filters, fmt.Sprintf(cond, arg(v)))
+	}
+	if f.MinTargetFrom != nil {
+		addBound("target_from >= %s", *f.MinTargetFrom)
+	}
+	if f.MaxTargetFrom != nil {
+		addBound("target_from <= %s", *f.MaxTargetFrom)
+	}
+	if f.MinTargetTo != nil {
+		addBound("target_to >= %s", *f.MinTargetTo)
+	}
+	if f.MaxTargetTo != nil {
+		addBound("target_to <= %s", *f.MaxTargetTo)
+	}
+	if f.DateFrom != nil {
+		addBound("time >= %s", *f.DateFrom)
+	}
+	if f.DateTo != nil {
+		addBound("time <= %s", *f.DateTo)
+	}
+
+	where := ""
+	if len(filters) > 0 {
+		where = "WHERE " + strings.Join(filters, " AND ")
+	}
+	sortBy := f.Sort
+	if !ratingSortColumns[sortBy] {
+		sortBy = "ticker"
+	}
+	order := "ASC"
+	if f.Desc {
+		order = "DESC"
+	}
+	// SQLite only accepts OFFSET after a LIMIT
+	limit := int64(f.Limit)
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s %s ORDER BY %s %s LIMIT %s OFFSET %s",
+		ratingColumns, ratingSource, where, sortBy, order, arg(limit), arg(f.Offset))
+	return query, args
+}
+
+func (p *pgRepository) ListRatings(f RatingFilter) ([]Rating, error) {
+	query, args := listQuery(f, "ILIKE")
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanRatings(rows)
+}
+
+func (p *pgRepository) EachRating(f RatingFilter, fn func(Rating) error) error {
+	query, args := listQuery(f, "ILIKE")
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	return eachRating(rows, fn)
+}
+
+func (p *pgRepository) LatestForTicker(ticker string) (Rating, error) {
+	r, err := scanRating(p.db.QueryRow(
+		"SELECT "+ratingColumns+" FROM "+ratingSource+" WHERE ticker=$1 ORDER BY time DESC LIMIT 1",
+		ticker,
+	))
+	if err == sql.ErrNoRows {
+		return Rating{}, errNotFound
+	}
+	return r, err
+}
+
+func (p *pgRepository) LatestPerTicker(f LatestFilter) ([]Rating, error) {
+	var pricedSince sql.NullTime
+	if !f.PricedSince.IsZero() {
+		pricedSince = sql.NullTime{Time: f.PricedSince, Valid: true}
+	}
+	rows, err := p.db.Query(`
+		SELECT DISTINCT ON (ticker) `+ratingColumns+`
+		FROM `+ratingSource+`
+		WHERE (NOT $1 OR current_price <> 0)
+			AND ($2::TIMESTAMPTZ IS NULL OR price_updated_at >= $2)
+		ORDER BY ticker, time DESC`,
+		f.Priced, pricedSince,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanRatings(rows)
+}
+
+func (p *pgRepository) InsertRating(r Rating) (upsertOutcome, error) {
+	return scanUpsert(p.db.QueryRow(insertStmt, r.upsertArgs()...))
+}
+
+func (p *pgRepository) FXRates() (map[string]float64, error) {
+	return queryFXRates(p.db)
+}
+
+func (p *pgRepository) RatingTaxonomy() (*ratingTaxonomy, error) {
+	return queryRatingTaxonomy(p.db)
+}
+
+func (p *pgRepository) UnmappedRatings() ([]ratingCount, error) {
+	return queryUnmappedRatings(p.db)
+}
+
+func (p *pgRepository) Brokerages() ([]Brokerage, error) {
+	return queryBrokerages(p.db)
+}
+
+func (p *pgRepository) BrokerageSuggestions() ([]brokerageSuggestion, error) {
+	return queryBrokerageSuggestions(p.db)
+}
+
+func (p *pgRepository) Securities() ([]Security, error) {
+	return querySecurities(p.db)
+}
+
+func (p *pgRepository) Security(ticker string) (Security, error) {
+	return querySecurity(p.db, ticker)
+}
+
+// upsertArgs are the insertStmt parameters for r.
+func (r Rating) upsertArgs() []any {
+	return []any{
+		r.Ticker,
+		r.Company,
+		r.Brokerage,
+		r.Action,
+		r.RatingFrom,
+		r.RatingTo,
+		r.TargetFrom,
+		r.TargetTo,
+		r.Time,
+		r.CurrentPrice,
+		r.PriceAtRating,
+		r.PriceUpdatedAt,
+		r.currency(),
+		nullString(r.RatingFromCanonical),
+		nullString(r.RatingToCanonical),
+		r.BrokerageID,
+		nullString(string(r.ActionType)),
+	}
+}
+
+// nullString stores an empty s as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// scanUpsert reads the insertStmt RETURNING row into an outcome.
+func scanUpsert(row *sql.Row) (upsertOutcome, error) {
+	var inserted bool
+	err := row.Scan(&inserted)
+	if err == sql.ErrNoRows {
+		return outcomeUnchanged, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("%w: %w", errExecInsert, err)
+	}
+	if inserted {
+		return outcomeInserted, nil
+	}
+	return outcomeUpdated, nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// memoryRepository is an in-process StockRepository mirroring the Postgres
+// semantics (upsert key, NULL handling and ordering). It backs handler tests
+// and runs without a database.
+type memoryRepository struct {
+	mu          sync.RWMutex
+	ratings     []Rating
+	fx          map[string]float64
+	taxonomy    *ratingTaxonomy
+	brokerages  []Brokerage
+	suggestions []brokerageSuggestion
+	securities  map[string]Security
+}
+
+func newMemoryRepository(ratings ...Rating) *memoryRepository {
+	m := &memoryRepository{taxonomy: newRatingTaxonomy(defaultRatingAliases)}
+	for _, r := range ratings {
+		m.InsertRating(r)
+	}
+	return m
+}
+
+// sameRatingKey compares the natural key used by insertStmt.
+func sameRatingKey(a, b Rating) bool {
+	return a.Ticker == b.Ticker && a.Brokerage == b.Brokerage && a.Time.Equal(b.Time) &&
+		a.RatingTo == b.RatingTo && equalFloatPtr(a.TargetTo, b.TargetTo)
+}
+
+func equalFloatPtr(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func equalInt64Ptr(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (m *memoryRepository) InsertRating(r Rating) (upsertOutcome, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.ratings {
+		cur := &m.ratings[i]
+		if !sameRatingKey(*cur, r) {
+			continue
+		}
+		// Same columns and condition as the ON CONFLICT clause
+		changed := cur.Company != r.Company || cur.Action != r.Action ||
+			cur.RatingFrom != r.RatingFrom || !equalFloatPtr(cur.TargetFrom, r.TargetFrom) ||
+			cur.currency() != r.currency() ||
+			cur.RatingFromCanonical != r.RatingFromCanonical || cur.RatingToCanonical != r.RatingToCanonical ||
+			!equalInt64Ptr(cur.BrokerageID, r.BrokerageID) || cur.ActionType != r.ActionType
+		if !changed && (cur.PriceAtRating != nil || r.PriceAtRating == nil) {
+			return outcomeUnchanged, nil
+		}
+		cur.Company, cur.Action, cur.RatingFrom, cur.TargetFrom = r.Company, r.Action, r.RatingFrom, r.TargetFrom
+		cur.Currency = r.currency()
+		cur.RatingFromCanonical, cur.RatingToCanonical = r.RatingFromCanonical, r.RatingToCanonical
+		cur.BrokerageID, cur.ActionType = r.BrokerageID, r.ActionType
+		if cur.PriceAtRating == nil {
+			cur.PriceAtRating = r.PriceAtRating
+		}
+		return outcomeUpdated, nil
+	}
+	m.ratings = append(m.ratings, r)
+	return outcomeInserted, nil
+}
+
+func (m *memoryRepository) ListRatings(f RatingFilter) ([]Rating, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := []Rating{}
+	for _, r := range m.ratings {
+		r = m.withSecurity(r)
+		if f.matches(r) {
+			out = append(out, r)
+		}
+	}
+
+	sortBy := f.Sort
+	if !ratingSortColumns[sortBy] {
+		sortBy = "ticker"
+	}
+	slices.SortStableFunc(out, func(a, b Rating) int {
+		c := compareRatingColumn(sortBy, a, b)
+		if f.Desc {
+			return -c
+		}
+		return c
+	})
+
+	if f.Offset >= len(out) {
+		return []Rating{}, nil
+	}
+	out = out[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(out) {
+		out = out[:f.Limit]
+	}
+	return out, nil
+}
+
+// EachRating walks a ListRatings snapshot, so fn may use the repository.
+func (m *memoryRepository) EachRating(f RatingFilter, fn func(Rating) error) error {
+	ratings, err := m.ListRatings(f)
+	if err != nil {
+		return err
+	}
+	for _, r := range ratings {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches applies the WHERE clause built by listQuery. As in SQL, a NULL
+// target never satisfies a bound.
+func (f RatingFilter) matches(r Rating) bool {
+	if f.Search != "" {
+		needle := strings.ToLower(f.Search)
+		found := false
+		fields := []string{r.Ticker, r.Company, r.Brokerage, r.Action, r.RatingFrom, r.RatingTo}
+		if r.Security != nil {
+			fields = append(fields, r.Security.Company)
+		}
+		for _, s := range fields {
+			if strings.Contains(strings.ToLower(s), needle) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	inList := func(vals []string, v string) bool {
+		return len(vals) == 0 || slices.Contains(vals, v)
+	}
+	var sector, exchange string
+	if r.Security != nil {
+		sector, exchange = r.Security.Sector, r.Security.Exchange
+	}
+	// As in SQL, an unregistered ticker matches no sector or exchange
+	if (len(f.Sectors) > 0 && (r.Security == nil || !slices.Contains(f.Sectors, sector))) ||
+		(len(f.Exchanges) > 0 && (r.Security == nil || !slices.Contains(f.Exchanges, exchange))) {
+		return false
+	}
+	if len(f.BrokerageIDs) > 0 && (r.BrokerageID == nil || !slices.Contains(f.BrokerageIDs, *r.BrokerageID)) {
+		return false
+	}
+	if !inList(f.Actions, r.Action) || !inList(f.ActionTypes, string(r.ActionType)) || !inList(f.Brokerages, r.Brokerage) ||
+		!inList(f.RatingFrom, r.RatingFrom) || !inList(f.RatingTo, r.RatingTo) {
+		return false
+	}
+	inRange := func(v, lo, hi *float64) bool {
+		if lo == nil && hi == nil {
+			return true
+		}
+		return v != nil && (lo == nil || *v >= *lo) && (hi == nil || *v <= *hi)
+	}
+	if !inRange(r.TargetFrom, f.MinTargetFrom, f.MaxTargetFrom) || !inRange(r.TargetTo, f.MinTargetTo, f.MaxTargetTo) {
+		return false
+	}
+	if f.DateFrom != nil && r.Time.Before(*f.DateFrom) {
+		return false
+	}
+	if f.DateTo != nil && r.Time.After(*f.DateTo) {
+		return false
+	}
+	return true
+}
+
+// compareRatingColumn orders a and b by one of ratingSortColumns, with NULLs
+// last as Postgres does for ascending sorts.
+func compareRatingColumn(col string, a, b Rating) int {
+	nullable := func(x, y *float64) int {
+		switch {
+		case x == nil && y == nil:
+			return 0
+		case x == nil:
+			return 1
+		case y == nil:
+			return -1
+		}
+		return cmp.Compare(*x, *y)
+	}
+	switch col {
+	case "company":
+		return cmp.Compare(a.Company, b.Company)
+	case "brokerage":
+		return cmp.Compare(a.Brokerage, b.Brokerage)
+	case "action":
+		return cmp.Compare(a.Action, b.Action)
+	case "rating_from":
+		return cmp.Compare(a.RatingFrom, b.RatingFrom)
+	case "rating_to":
+		return cmp.Compare(a.RatingTo, b.RatingTo)
+	case "target_from":
+		return nullable(a.TargetFrom, b.TargetFrom)
+	case "target_to":
+		return nullable(a.TargetTo, b.TargetTo)
+	case "time":
+		return a.Time.Compare(b.Time)
+	case "current_price":
+		return nullable(a.CurrentPrice, b.CurrentPrice)
+	case "price_at_rating":
+		return nullable(a.PriceAtRating, b.PriceAtRating)
+	}
+	return cmp.Compare(a.Ticker, b.Ticker)
+}
+
+func (m *memoryRepository) LatestForTicker(ticker string) (Rating, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var latest *Rating
+	for i, r := range m.ratings {
+		if r.Ticker == ticker && (latest == nil || r.Time.After(latest.Time)) {
+			latest = &m.ratings[i]
+		}
+	}
+	if latest == nil {
+		return Rating{}, errNotFound
+	}
+	return m.withSecurity(*latest), nil
+}
+
+func (m *memoryRepository) LatestPerTicker(f LatestFilter) ([]Rating, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	latest := map[string]Rating{}
+	for _, r := range m.ratings {
+		if f.Priced && (r.CurrentPrice == nil || *r.CurrentPrice == 0) {
+			continue
+		}
+		if !f.PricedSince.IsZero() && (r.PriceUpdatedAt == nil || r.PriceUpdatedAt.Before(f.PricedSince)) {
+			continue
+		}
+		if cur, ok := latest[r.Ticker]; !ok || r.Time.After(cur.Time) {
+			latest[r.Ticker] = r
+		}
+	}
+	out := make([]Rating, 0, len(latest))
+	for _, r := range latest {
+		out = append(out, m.withSecurity(r))
+	}
+	slices.SortFunc(out, func(a, b Rating) int { return cmp.Compare(a.Ticker, b.Ticker) })
+	return out, nil
+}
+
+func (m *memoryRepository) FXRates() (map[string]float64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rates := map[string]float64{baseCurrency: 1}
+	for code, rate := range m.fx {
+		rates[code] = rate
+	}
+	return rates, nil
+}
+
+func (m *memoryRepository) RatingTaxonomy() (*ratingTaxonomy, error) {
+	return newRatingTaxonomy(m.taxonomy.aliases), nil
+}
+
+func (m *memoryRepository) UnmappedRatings() ([]ratingCount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	counts := map[string]int{}
+	for _, r := range m.ratings {
+		for _, raw := range []string{r.RatingFrom, r.RatingTo} {
+			if _, ok := m.taxonomy.lookup(raw); !ok && strings.TrimSpace(raw) != "" {
+				counts[raw]++
+			}
+		}
+	}
+	return sortedRatingCounts(counts), nil
+}
+
+func (m *memoryRepository) Brokerages() ([]Brokerage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Brokerage{}, m.brokerages...), nil
+}
+
+func (m *memoryRepository) BrokerageSuggestions() ([]brokerageSuggestion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]brokerageSuggestion{}, m.suggestions...), nil
+}
+
+// withSecurity joins r to its security like ratingSource. Called with mu
+// held.
+func (m *memoryRepository) withSecurity(r Rating) Rating {
+	if s, ok := m.securities[r.Ticker]; ok {
+		r.Security = &s
+	}
+	return r
+}
+
+func (m *memoryRepository) Securities() ([]Security, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := []Security{}
+	for _, s := range m.securities {
+		out = append(out, s)
+	}
+	slices.SortFunc(out, func(a, b Security) int { return cmp.Compare(a.Ticker, b.Ticker) })
+	return out, nil
+}
+
+func (m *memoryRepository) Security(ticker string) (Security, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.securities[ticker]
+	if !ok {
+		return Security{}, errNotFound
+	}
+	return s, nil
+}
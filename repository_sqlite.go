@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqliteInsertStmt is insertStmt for SQLite: the conflict target matches the
+// IFNULL rating key index, and upsert_inserted stands in for xmax.
+var sqliteInsertStmt = `
+		INSERT INTO stock_info (
+		ticker, company, brokerage, action,
+		rating_from, rating_to, target_from, target_to,
+		time, current_price, price_at_rating, price_updated_at, target_currency,
+		rating_from_canonical, rating_to_canonical, brokerage_id, action_type
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		ON CONFLICT (ticker, brokerage, time, rating_to, IFNULL(target_to, '')) DO UPDATE SET
+			company         = excluded.company,
+			action          = excluded.action,
+			rating_from     = excluded.rating_from,
+			target_from     = excluded.target_from,
+			target_currency = excluded.target_currency,
+			rating_from_canonical = excluded.rating_from_canonical,
+			rating_to_canonical   = excluded.rating_to_canonical,
+			brokerage_id    = excluded.brokerage_id,
+			action_type     = excluded.action_type,
+			price_at_rating = COALESCE(stock_info.price_at_rating, excluded.price_at_rating),
+			upsert_inserted = 0
+		WHERE stock_info.company IS NOT excluded.company
+			OR stock_info.action IS NOT excluded.action
+			OR stock_info.rating_from IS NOT excluded.rating_from
+			OR stock_info.target_from IS NOT excluded.target_from
+			OR stock_info.target_currency IS NOT excluded.target_currency
+			OR stock_info.rating_from_canonical IS NOT excluded.rating_from_canonical
+			OR stock_info.rating_to_canonical IS NOT excluded.rating_to_canonical
+			OR stock_info.brokerage_id IS NOT excluded.brokerage_id
+			OR stock_info.action_type IS NOT excluded.action_type
+			OR (stock_info.price_at_rating IS NULL AND excluded.price_at_rating IS NOT NULL)
+		RETURNING upsert_inserted
+	`
+
+// sqliteRepository is the SQLite StockRepository. It shares the Postgres
+// queries except where SQLite lacks the syntax (ILIKE, DISTINCT ON, casts).
+type sqliteRepository struct {
+	*pgRepository
+}
+
+func newSQLiteRepository(db *sql.DB) *sqliteRepository {
+	return &sqliteRepository{newPostgresRepository(db)}
+}
+
+func (s *sqliteRepository) ListRatings(f RatingFilter) ([]Rating, error) {
+	// LIKE is already case-insensitive for ASCII in SQLite
+	query, args := listQuery(f, "LIKE")
+	rows, err := s.db.Query(query, utcArgs(args)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanRatings(rows)
+}
+
+func (s *sqliteRepository) EachRating(f RatingFilter, fn func(Rating) error) error {
+	query, args := listQuery(f, "LIKE")
+	rows, err := s.db.Query(query, utcArgs(args)...)
+	if err != nil {
+		return err
+	}
+	return eachRating(rows, fn)
+}
+
+func (s *sqliteRepository) LatestPerTicker(f LatestFilter) ([]Rating, error) {
+	var pricedSince sql.NullTime
+	if !f.PricedSince.IsZero() {
+		pricedSince = sql.NullTime{Time: f.PricedSince.UTC(), Valid: true}
+	}
+	rows, err := s.db.Query(`
+		SELECT `+ratingColumns+` FROM (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY ticker ORDER BY time DESC) AS latest
+			FROM stock_info
+			WHERE (NOT $1 OR current_price <> 0)
+				AND ($2 IS NULL OR price_updated_at >= $2)
+		) stock_info LEFT JOIN securities sec USING (ticker)
+		WHERE latest = 1
+		ORDER BY ticker`,
+		f.Priced, pricedSince,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanRatings(rows)
+}
+
+func (s *sqliteRepository) InsertRating(r Rating) (upsertOutcome, error) {
+	return scanUpsert(s.db.QueryRow(sqliteInsertStmt, utcArgs(r.upsertArgs())...))
+}
+
+// utcArgs converts time arguments to UTC. SQLite compares the stored text, so
+// every timestamp must be written with the same offset.
+func utcArgs(args []any) []any {
+	out := make([]any, len(args))
+	for i, a := range args {
+		switch v := a.(type) {
+		case time.Time:
+			a = v.UTC()
+		case *time.Time:
+			if v != nil {
+				a = v.UTC()
+			}
+		}
+		out[i] = a
+	}
+	return out
+}
+
+// dbTime scans a nullable timestamp that SQLite may return as text, e.g.
+// from an aggregate where the column type is lost.
+type dbTime struct {
+	sql.NullTime
+}
+
+func (t *dbTime) Scan(v any) error {
+	s, ok := v.(string)
+	if !ok {
+		return t.NullTime.Scan(v)
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999-07:00", time.DateTime, time.DateOnly} {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time, t.Valid = parsed, true
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized time %q", s)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openTestSQLite returns a migrated in-memory SQLite database.
+func openTestSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, d, err := openDatabase("sqlite::memory:")
+	require.NoError(t, err)
+	require.Equal(t, sqliteDialect.Driver, d.Driver)
+	t.Cleanup(func() { db.Close() })
+
+	migs, err := embeddedMigrations(d)
+	require.NoError(t, err)
+	_, err = migrateUp(db, d, migs, 0)
+	require.NoError(t, err)
+	return db
+}
+
+func TestSQLiteMigrations_RoundTrip(t *testing.T) {
+	db := openTestSQLite(t)
+	migs, _ := embeddedMigrations(sqliteDialect)
+
+	done, err := migrateDown(db, sqliteDialect, migs, len(migs))
+	assert.NoError(t, err)
+	assert.Len(t, done, len(migs))
+	_, err = db.Exec("SELECT 1 FROM stock_info")
+	assert.Error(t, err, "stock_info should be dropped")
+
+	done, err = migrateUp(db, sqliteDialect, migs, 0)
+	assert.NoError(t, err)
+	assert.Len(t, done, len(migs))
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	repo := newSQLiteRepository(openTestSQLite(t))
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	priced := day.Add(time.Hour)
+
+	for _, r := range []Rating{
+		{Ticker: "XYZ", Company: "X Co", Brokerage: "Brok", Action: "init", RatingFrom: "Hold", RatingTo: "Buy",
+			TargetFrom: ptr(1.0), TargetTo: ptr(2.0), Time: day, CurrentPrice: ptr(3.0), PriceUpdatedAt: &priced},
+		{Ticker: "XYZ", Company: "X Co", Brokerage: "Brok", Action: "reiterated", RatingFrom: "Buy", RatingTo: "Buy",
+			TargetFrom: ptr(2.0), TargetTo: ptr(4.0), Time: day.AddDate(0, 0, 1), CurrentPrice: ptr(3.0), PriceUpdatedAt: &priced},
+		// No target and a non-UTC time: still one row per natural key
+		{Ticker: "ABC", Company: "A Co", Brokerage: "Other", Action: "dropped", RatingFrom: "Buy", RatingTo: "Sell",
+			Time: day.In(time.FixedZone("EST", -5*3600))},
+	} {
+		outcome, err := repo.InsertRating(r)
+		assert.NoError(t, err)
+		assert.Equal(t, outcomeInserted, outcome)
+		outcome, err = repo.InsertRating(r)
+		assert.NoError(t, err)
+		assert.Equal(t, outcomeUnchanged, outcome, r.Ticker)
+	}
+
+	updated := Rating{Ticker: "ABC", Company: "A Co", Brokerage: "Other", Action: "downgraded", RatingFrom: "Buy", RatingTo: "Sell", Time: day}
+	outcome, err := repo.InsertRating(updated)
+	assert.NoError(t, err)
+	assert.Equal(t, outcomeUpdated, outcome)
+
+	all, err := repo.ListRatings(RatingFilter{Sort: "time", Desc: true, Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, all, 3)
+	assert.Equal(t, "reiterated", all[0].Action)
+	assert.True(t, day.Equal(all[2].Time))
+
+	// LIKE search is case-insensitive, as ILIKE is on Postgres
+	found, err := repo.ListRatings(RatingFilter{Search: "x co", MinTargetTo: ptr(3.0), DateFrom: ptr(day.Add(time.Minute))})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, 4.0, *found[0].TargetTo)
+
+	latest, err := repo.LatestForTicker("ABC")
+	assert.NoError(t, err)
+	assert.Equal(t, "downgraded", latest.Action)
+	assert.Nil(t, latest.TargetTo)
+	_, err = repo.LatestForTicker("NOPE")
+	assert.ErrorIs(t, err, errNotFound)
+
+	perTicker, err := repo.LatestPerTicker(LatestFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, perTicker, 2)
+	assert.Equal(t, "ABC", perTicker[0].Ticker)
+	assert.Equal(t, "reiterated", perTicker[1].Action)
+
+	perTicker, err = repo.LatestPerTicker(LatestFilter{Priced: true, PricedSince: priced.In(time.FixedZone("CET", 3600))})
+	assert.NoError(t, err)
+	assert.Len(t, perTicker, 1)
+	assert.True(t, priced.Equal(*perTicker[0].PriceUpdatedAt))
+
+	perTicker, err = repo.LatestPerTicker(LatestFilter{Priced: true, PricedSince: priced.Add(time.Second)})
+	assert.NoError(t, err)
+	assert.Empty(t, perTicker)
+}
+
+func TestFetchAndStoreAllPages_SQLite(t *testing.T) {
+	pages := 0
+	restore := stubUpstream(func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		fmt.Fprint(w, onePage)
+	})
+	defer restore()
+
+	db := openTestSQLite(t)
+	prep, err := db.Prepare(sqliteDialect.InsertStmt)
+	require.NoError(t, err)
+	defer prep.Close()
+
+	summary, err := fetchAndStoreAllPages(db, prep, fetchOptions{Prices: testPrices, Ratings: newRatingTaxonomy(defaultRatingAliases)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, summary.Inserted)
+	stored, err := newSQLiteRepository(db).LatestForTicker("TCK")
+	assert.NoError(t, err)
+	assert.Equal(t, levelHold, stored.RatingFromCanonical)
+	assert.Equal(t, levelBuy, stored.RatingToCanonical)
+
+	// A second incremental run sees the same rating as already stored
+	summary, err = fetchAndStoreAllPages(db, prep, fetchOptions{Incremental: true, Prices: testPrices})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, summary.Inserted)
+
+	watermark, ok, err := loadWatermark(db, APIEndpoint)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, time.Date(2025, 1, 13, 0, 30, 5, 0, time.UTC).Equal(watermark))
+	assert.Equal(t, 2, pages)
+}
+
+func TestBackfillPriceHistory_SQLite(t *testing.T) {
+	db := openTestSQLite(t)
+	repo := newSQLiteRepository(db)
+	_, err := repo.InsertRating(Rating{Ticker: "NEW", Time: time.Date(2025, 1, 10, 8, 0, 0, 0, time.UTC)})
+	require.NoError(t, err)
+
+	today := time.Date(2025, 1, 20, 15, 0, 0, 0, time.UTC)
+	bar := PriceBar{Date: time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC), Close: 1.5}
+	hist := &stubHistory{bars: []PriceBar{bar}, requested: map[string][2]time.Time{}}
+
+	n, err := backfillPriceHistory(db, hist, time.Time{}, today)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC), hist.requested["NEW"][0])
+
+	// The second pass resumes after the stored bar
+	_, err = backfillPriceHistory(db, hist, time.Time{}, today)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 1, 18, 0, 0, 0, 0, time.UTC), hist.requested["NEW"][0])
+}
+
+func TestRatingKeyMigration_KeepsOldestDuplicate(t *testing.T) {
+	db := openTestSQLite(t)
+	migs, err := embeddedMigrations(sqliteDialect)
+	require.NoError(t, err)
+	_, err = migrateDown(db, sqliteDialect, migs, len(migs)-1)
+	require.NoError(t, err)
+
+	for _, row := range []struct {
+		id     int
+		target any
+	}{{1, 10.0}, {2, 10.0}, {3, nil}, {4, nil}, {5, 12.0}} {
+		_, err = db.Exec(`INSERT INTO stock_info (id, ticker, company, brokerage, action, rating_from, rating_to, target_to, time)
+			VALUES ($1, 'DUP', '', 'Brok', '', '', 'Buy', $2, '2025-01-01 00:00:00+00:00')`, row.id, row.target)
+		require.NoError(t, err)
+	}
+	_, err = migrateUp(db, sqliteDialect, migs, 0)
+	require.NoError(t, err)
+
+	var ids []int
+	rows, err := db.Query("SELECT id FROM stock_info ORDER BY id")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		require.NoError(t, rows.Scan(&id))
+		ids = append(ids, id)
+	}
+	assert.Equal(t, []int{1, 3, 5}, ids)
+
+	// A table made by hand before migrations existed, with no id column
+	_, err = migrateDown(db, sqliteDialect, migs, len(migs)-1)
+	require.NoError(t, err)
+	_, err = db.Exec(`DROP TABLE stock_info`)
+	require.NoError(t, err)
+	_, err = db.Exec(`CREATE TABLE stock_info (ticker TEXT NOT NULL, company TEXT NOT NULL, brokerage TEXT NOT NULL,
+		action TEXT NOT NULL, rating_from TEXT NOT NULL, rating_to TEXT NOT NULL, target_from NUMERIC, target_to NUMERIC,
+		time TIMESTAMP NOT NULL, current_price NUMERIC, upsert_inserted BOOLEAN NOT NULL DEFAULT 1)`)
+	require.NoError(t, err)
+	for _, company := range []string{"first", "second"} {
+		_, err = db.Exec(`INSERT INTO stock_info (ticker, company, brokerage, action, rating_from, rating_to, target_to, time)
+			VALUES ('DUP', $1, 'Brok', '', '', 'Buy', 10, '2025-01-01 00:00:00+00:00')`, company)
+		require.NoError(t, err)
+	}
+	_, err = migrateUp(db, sqliteDialect, migs, 0)
+	require.NoError(t, err)
+	var companies []string
+	rows, err = db.Query("SELECT company FROM stock_info")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var company string
+		require.NoError(t, rows.Scan(&company))
+		companies = append(companies, company)
+	}
+	assert.Equal(t, []string{"first"}, companies)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var ratingColumnNames = []string{
+	"ticker", "company", "brokerage", "action", "rating_from", "rating_to",
+	"target_from", "target_to", "time", "current_price", "price_at_rating", "price_updated_at",
+	"target_currency", "rating_from_canonical", "rating_to_canonical", "brokerage_id", "action_type",
+	"security_company", "exchange", "sector", "industry", "currency", "active",
+}
+
+func TestListQuery(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	query, args := listQuery(RatingFilter{
+		Search:       "acme",
+		Brokerages:   []string{"B1", "B2"},
+		BrokerageIDs: []int64{7},
+		MinTargetTo:  ptr(10.0),
+		DateFrom:     &from,
+		Sort:         "time",
+		Desc:         true,
+		Limit:        20,
+		Offset:       40,
+	}, "ILIKE")
+	assert.Contains(t, query, "ticker ILIKE $1 OR stock_info.company ILIKE $1 OR sec.company ILIKE $1")
+	assert.Contains(t, query, "brokerage IN ($2,$3) AND brokerage_id IN ($4) AND target_to >= $5 AND time >= $6")
+	assert.Contains(t, query, "ORDER BY time DESC LIMIT $7 OFFSET $8")
+	assert.Equal(t, []any{"%acme%", "B1", "B2", int64(7), 10.0, from, int64(20), 40}, args)
+
+	// Unknown sort columns never reach the SQL
+	query, _ = listQuery(RatingFilter{Sort: "ticker; DROP TABLE stock_info"}, "ILIKE")
+	assert.Contains(t, query, "ORDER BY ticker ASC LIMIT $1 OFFSET $2")
+}
+
+func TestPostgresRepository_ListRatings(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT ticker, .* FROM stock_info LEFT JOIN securities").
+		WithArgs(int64(100), 0).
+		WillReturnRows(sqlmock.NewRows(ratingColumnNames).
+			AddRow("T1", "C1", "B1", "A1", "RF1", "RT1", "1.00", nil, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), 1.5, nil, nil, "USD", nil, nil, nil, nil,
+				nil, nil, nil, nil, nil, nil))
+
+	ratings, err := newPostgresRepository(db).ListRatings(RatingFilter{Limit: 100})
+	assert.NoError(t, err)
+	assert.Len(t, ratings, 1)
+	assert.Equal(t, 1.0, *ratings[0].TargetFrom)
+	assert.Nil(t, ratings[0].TargetTo)
+	assert.Nil(t, ratings[0].PriceAtRating)
+	assert.Nil(t, ratings[0].Security, "no securities row")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresRepository_LatestForTicker(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	mock.ExpectQuery("WHERE ticker=\\$1 ORDER BY time DESC LIMIT 1").
+		WithArgs("ZZZ").
+		WillReturnRows(sqlmock.NewRows(ratingColumnNames))
+
+	_, err := newPostgresRepository(db).LatestForTicker("ZZZ")
+	assert.ErrorIs(t, err, errNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresRepository_LatestPerTicker(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	since := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT ON (ticker)")+".*"+regexp.QuoteMeta("ORDER BY ticker, time DESC")).
+		WithArgs(true, since).
+		WillReturnRows(sqlmock.NewRows(ratingColumnNames).
+			AddRow("A", "CoA", "B1", "up", "Buy", "Buy", 10.0, 12.0, since, 5.0, nil, since, "EUR", "Buy", "Buy", 3, "upgrade",
+				"Co A Inc", "NYSE", "Technology", nil, "USD", true))
+
+	ratings, err := newPostgresRepository(db).LatestPerTicker(LatestFilter{Priced: true, PricedSince: since})
+	assert.NoError(t, err)
+	assert.Len(t, ratings, 1)
+	assert.True(t, since.Equal(*ratings[0].PriceUpdatedAt))
+	assert.Equal(t, int64(3), *ratings[0].BrokerageID)
+	assert.Equal(t, actionUpgrade, ratings[0].ActionType)
+	assert.Equal(t, &Security{Ticker: "A", Company: "Co A Inc", Exchange: "NYSE", Sector: "Technology", Currency: "USD", Active: true}, ratings[0].Security)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresRepository_InsertRating(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	defer db.Close()
+
+	r := Rating{Ticker: "TCK", Brokerage: "Brok", Time: time.Now(), TargetTo: ptr(2.0)}
+	mock.ExpectQuery("INSERT INTO stock_info").
+		WithArgs(r.upsertArgs()[0], sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "USD", sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"inserted"}).AddRow(true))
+	mock.ExpectQuery("INSERT INTO stock_info").WillReturnRows(sqlmock.NewRows([]string{"inserted"}))
+
+	repo := newPostgresRepository(db)
+	outcome, err := repo.InsertRating(r)
+	assert.NoError(t, err)
+	assert.Equal(t, outcomeInserted, outcome)
+	outcome, err = repo.InsertRating(r)
+	assert.NoError(t, err)
+	assert.Equal(t, outcomeUnchanged, outcome)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMemoryRepository_InsertRatingUpserts(t *testing.T) {
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := Rating{Ticker: "TCK", Brokerage: "Brok", Action: "init", RatingTo: "Buy", TargetTo: ptr(2.0), Time: at}
+	repo := newMemoryRepository()
+
+	outcome, _ := repo.InsertRating(r)
+	assert.Equal(t, outcomeInserted, outcome)
+	outcome, _ = repo.InsertRating(r)
+	assert.Equal(t, outcomeUnchanged, outcome)
+
+	// A first price at rating fills the gap but is never overwritten
+	r.PriceAtRating = ptr(1.5)
+	outcome, _ = repo.InsertRating(r)
+	assert.Equal(t, outcomeUpdated, outcome)
+	r.PriceAtRating = ptr(9.9)
+	outcome, _ = repo.InsertRating(r)
+	assert.Equal(t, outcomeUnchanged, outcome)
+
+	r.Action = "reiterated"
+	outcome, _ = repo.InsertRating(r)
+	assert.Equal(t, outcomeUpdated, outcome)
+
+	// A different target is a different rating
+	r.TargetTo = ptr(3.0)
+	outcome, _ = repo.InsertRating(r)
+	assert.Equal(t, outcomeInserted, outcome)
+
+	got, err := repo.LatestForTicker("TCK")
+	assert.NoError(t, err)
+	assert.Equal(t, "reiterated", got.Action)
+	ratings, _ := repo.ListRatings(RatingFilter{Sort: "target_to", Desc: true})
+	assert.Len(t, ratings, 2)
+	assert.Equal(t, 1.5, *ratings[1].PriceAtRating)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Security is a listed instrument from the securities master. Ingest only
+// registers tickers it has not seen; the reference CSV is authoritative.
+type Security struct {
+	Ticker   string `json:"ticker"`
+	Company  string `json:"company"`
+	Exchange string `json:"exchange,omitempty"`
+	Sector   string `json:"sector,omitempty"`
+	Industry string `json:"industry,omitempty"`
+	Currency string `json:"currency"`
+	Active   bool   `json:"active"` // false once delisted
+}
+
+// securityColumns is the select list scanned by scanSecurity.
+const securityColumns = "ticker, company, exchange, sector, industry, currency, active"
+
+func scanSecurity(row rowScanner) (Security, error) {
+	var s Security
+	var exchange, sector, industry sql.NullString
+	if err := row.Scan(&s.Ticker, &s.Company, &exchange, &sector, &industry, &s.Currency, &s.Active); err != nil {
+		return Security{}, err
+	}
+	s.Exchange, s.Sector, s.Industry = exchange.String, sector.String, industry.String
+	return s, nil
+}
+
+// normalizeTicker is the form tickers are stored and joined in, both from
+// ratings and from the reference CSV.
+func normalizeTicker(ticker string) string {
+	return strings.ToUpper(strings.TrimSpace(ticker))
+}
+
+// syncSecurities registers the tickers of a page's stored items in
+// securities within the page transaction. A known ticker only gets its
+// company filled in when the reference data left it empty, so brokerages
+// cannot rename it.
+func syncSecurities(tx *sql.Tx, items []StockItem) error {
+	// Pages are newest first: the first company seen for a ticker wins
+	seen := map[string]bool{}
+	var values []string
+	var args []any
+	for _, item := range items {
+		ticker := normalizeTicker(item.Ticker)
+		if ticker == "" || seen[ticker] {
+			continue
+		}
+		seen[ticker] = true
+		args = append(args, ticker, item.Company)
+		values = append(values, fmt.Sprintf("($%d, $%d)", len(args)-1, len(args)))
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO securities (ticker, company) VALUES `+strings.Join(values, ", ")+`
+		ON CONFLICT (ticker) DO UPDATE SET company = excluded.company, updated_at = CURRENT_TIMESTAMP
+		WHERE securities.company = ''`,
+		args...,
+	); err != nil {
+		return fmt.Errorf("%w: securities: %w", errExecInsert, err)
+	}
+	return nil
+}
+
+// loadSecuritiesCSV reads a reference CSV whose header names its columns:
+// ticker is required, company, exchange, sector, industry, currency and
+// active are optional. Empty currencies default to USD and empty active
+// flags to true.
+func loadSecuritiesCSV(r io.Reader) ([]Security, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading securities header: %w", err)
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "ticker", "company", "exchange", "sector", "industry", "currency", "active":
+			cols[name] = i
+		default:
+			return nil, fmt.Errorf("securities header: unknown column %q", name)
+		}
+	}
+	if _, ok := cols["ticker"]; !ok {
+		return nil, errors.New("securities header: missing ticker column")
+	}
+
+	var out []Security
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading securities: %w", err)
+		}
+		field := func(name string) string {
+			if i, ok := cols[name]; ok {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		s := Security{
+			Ticker:   normalizeTicker(field("ticker")),
+			Company:  field("company"),
+			Exchange: field("exchange"),
+			Sector:   field("sector"),
+			Industry: field("industry"),
+			Currency: strings.ToUpper(field("currency")),
+			Active:   true,
+		}
+		if s.Ticker == "" {
+			return nil, fmt.Errorf("securities line %d: empty ticker", line)
+		}
+		if s.Currency == "" {
+			s.Currency = baseCurrency
+		} else if len(s.Currency) != 3 {
+			return nil, fmt.Errorf("securities line %d: invalid currency %q", line, s.Currency)
+		}
+		if v := field("active"); v != "" {
+			if s.Active, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("securities line %d: invalid active %q", line, v)
+			}
+		}
+		out = append(out, s)
+	}
+}
+
+// storeSecurities upserts reference securities in one transaction. An empty
+// company keeps the one registered at ingest.
+func storeSecurities(db *sql.DB, secs []Security) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin securities: %w", err)
+	}
+	defer tx.Rollback()
+	for _, s := range secs {
+		if _, err := tx.Exec(`
+			INSERT INTO securities (ticker, company, exchange, sector, industry, currency, active, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
+			ON CONFLICT (ticker) DO UPDATE SET
+				company    = COALESCE(NULLIF(excluded.company, ''), securities.company),
+				exchange   = excluded.exchange,
+				sector     = excluded.sector,
+				industry   = excluded.industry,
+				currency   = excluded.currency,
+				active     = excluded.active,
+				updated_at = excluded.updated_at`,
+			s.Ticker, s.Company, nullString(s.Exchange), nullString(s.Sector), nullString(s.Industry), s.Currency, s.Active,
+		); err != nil {
+			return fmt.Errorf("storing security %s: %w", s.Ticker, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// querySecurities lists the securities master by ticker.
+func querySecurities(db *sql.DB) ([]Security, error) {
+	rows, err := db.Query("SELECT " + securityColumns + " FROM securities ORDER BY ticker")
+	if err != nil {
+		return nil, fmt.Errorf("listing securities: %w", err)
+	}
+	defer rows.Close()
+	out := []Security{}
+	for rows.Next() {
+		s, err := scanSecurity(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning security: %w", err)
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// querySecurity returns the security of ticker, or errNotFound.
+func querySecurity(db *sql.DB, ticker string) (Security, error) {
+	s, err := scanSecurity(db.QueryRow("SELECT "+securityColumns+" FROM securities WHERE ticker=$1", ticker))
+	if err == sql.ErrNoRows {
+		return Security{}, errNotFound
+	}
+	return s, err
+}
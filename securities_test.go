@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSecuritiesCSV(t *testing.T) {
+	secs, err := loadSecuritiesCSV(strings.NewReader(
+		"Ticker,Company,Sector,Currency,Active\n" +
+			"aapl,Apple Inc.,Technology,,\n" +
+			"SAP,SAP SE,Technology,eur,true\n" +
+			"TWTR,Twitter,Communication Services,USD,false\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []Security{
+		{Ticker: "AAPL", Company: "Apple Inc.", Sector: "Technology", Currency: "USD", Active: true},
+		{Ticker: "SAP", Company: "SAP SE", Sector: "Technology", Currency: "EUR", Active: true},
+		{Ticker: "TWTR", Company: "Twitter", Sector: "Communication Services", Currency: "USD", Active: false},
+	}, secs)
+
+	for csv, msg := range map[string]string{
+		"company\nApple\n":             "missing ticker column",
+		"ticker,isin\nAAPL,US0378\n":   `unknown column "isin"`,
+		"ticker,active\nAAPL,maybe\n":  `line 2: invalid active "maybe"`,
+		"ticker,currency\nAAPL,US\n":   `line 2: invalid currency "US"`,
+		"ticker,company\nAAPL,A\n,B\n": "line 3: empty ticker",
+	} {
+		_, err := loadSecuritiesCSV(strings.NewReader(csv))
+		assert.ErrorContains(t, err, msg, csv)
+	}
+}
+
+func TestSecurities_SQLite(t *testing.T) {
+	restore := stubUpstream(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items":[
+			{"ticker":"TCK","company":"Tick Corp","brokerage":"B1","action":"A","rating_from":"Hold","rating_to":"Buy","target_from":"$1","target_to":"$2","time":"2025-01-14T00:00:00Z"},
+			{"ticker":"TCK","company":"Tick Corporation","brokerage":"B2","action":"A","rating_from":"Hold","rating_to":"Buy","target_from":"$1","target_to":"$2","time":"2025-01-13T00:00:00Z"},
+			{"ticker":"NEW","company":"","brokerage":"B1","action":"A","rating_from":"Hold","rating_to":"Buy","target_from":"$1","target_to":"$2","time":"2025-01-13T00:00:00Z"}
+		],"next_page":""}`)
+	})
+	defer restore()
+
+	db := openTestSQLite(t)
+	repo := newSQLiteRepository(db)
+	prep, err := db.Prepare(sqliteDialect.InsertStmt)
+	require.NoError(t, err)
+	defer prep.Close()
+
+	_, err = fetchAndStoreAllPages(db, prep, fetchOptions{Prices: testPrices})
+	require.NoError(t, err)
+	secs, err := repo.Securities()
+	require.NoError(t, err)
+	assert.Equal(t, []Security{
+		{Ticker: "NEW", Currency: "USD", Active: true},
+		{Ticker: "TCK", Company: "Tick Corp", Currency: "USD", Active: true},
+	}, secs, "named after the newest rating")
+
+	// Reference data wins over ingest, and fills the empty name
+	require.NoError(t, storeSecurities(db, []Security{
+		{Ticker: "TCK", Company: "Tick Holdings", Exchange: "NYSE", Sector: "Industrials", Currency: "USD", Active: true},
+		{Ticker: "NEW", Company: "New Co", Sector: "Energy", Currency: "CAD", Active: false},
+	}))
+	_, err = fetchAndStoreAllPages(db, prep, fetchOptions{Prices: testPrices})
+	require.NoError(t, err)
+	tck, err := repo.Security("TCK")
+	require.NoError(t, err)
+	assert.Equal(t, Security{Ticker: "TCK", Company: "Tick Holdings", Exchange: "NYSE", Sector: "Industrials", Currency: "USD", Active: true}, tck)
+	_, err = repo.Security("ZZZ")
+	assert.ErrorIs(t, err, errNotFound)
+
+	ratings, err := repo.ListRatings(RatingFilter{Sectors: []string{"Industrials"}, Sort: "company"})
+	require.NoError(t, err)
+	require.Len(t, ratings, 2)
+	assert.Equal(t, "Tick Corp", ratings[0].Company, "ratings keep the name they were published with")
+	assert.Equal(t, "Tick Holdings", ratings[0].Security.Company)
+
+	ratings, err = repo.ListRatings(RatingFilter{Search: "holdings"})
+	require.NoError(t, err)
+	assert.Len(t, ratings, 2)
+
+	latest, err := repo.LatestPerTicker(LatestFilter{})
+	require.NoError(t, err)
+	require.Len(t, latest, 2)
+	assert.False(t, latest[0].Security.Active)
+	assert.Equal(t, "Energy", latest[0].Security.Sector)
+}
+
+func TestSecurities_RegistersStoredTickersOnly(t *testing.T) {
+	restore := stubUpstream(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items":[
+			{"ticker":" tck ","company":"Tick Corp","brokerage":"B1","action":"A","rating_from":"Hold","rating_to":"Buy","target_from":"$1","target_to":"$2","time":"2025-01-14T00:00:00Z"},
+			{"ticker":"BAD","company":"Bad Co","brokerage":"B1","action":"A","rating_from":"Hold","rating_to":"Buy","target_from":"TBD","target_to":"$2","time":"2025-01-14T00:00:00Z"}
+		],"next_page":""}`)
+	})
+	defer restore()
+
+	db := openTestSQLite(t)
+	repo := newSQLiteRepository(db)
+	prep, err := db.Prepare(sqliteDialect.InsertStmt)
+	require.NoError(t, err)
+	defer prep.Close()
+	summary, err := fetchAndStoreAllPages(db, prep, fetchOptions{Prices: testPrices})
+	require.NoError(t, err)
+	assert.Equal(t, fetchSummary{Inserted: 1, Failed: 1}, summary)
+
+	secs, err := repo.Securities()
+	require.NoError(t, err)
+	assert.Equal(t, []Security{{Ticker: "TCK", Company: "Tick Corp", Currency: "USD", Active: true}}, secs, "the rejected BAD is not registered")
+
+	// The reference CSV spells the ticker its own way, and still joins
+	ref, err := loadSecuritiesCSV(strings.NewReader("ticker,sector\ntck ,Industrials\n"))
+	require.NoError(t, err)
+	require.NoError(t, storeSecurities(db, ref))
+	ratings, err := repo.ListRatings(RatingFilter{Sectors: []string{"Industrials"}})
+	require.NoError(t, err)
+	require.Len(t, ratings, 1)
+	assert.Equal(t, "TCK", ratings[0].Ticker)
+}
+
+func TestSecuritiesMigration_BackfillsTickers(t *testing.T) {
+	db := openTestSQLite(t)
+	migs, err := embeddedMigrations(sqliteDialect)
+	require.NoError(t, err)
+	_, err = migrateDown(db, sqliteDialect, migs, len(migs)-11)
+	require.NoError(t, err)
+
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo := newSQLiteRepository(db)
+	for _, r := range []Rating{
+		{Ticker: "OLD", Company: "Old Name", Brokerage: "B1", Time: at},
+		{Ticker: "OLD", Company: "New Name", Brokerage: "B2", Time: at.AddDate(0, 0, 1)},
+	} {
+		_, err := db.Exec(`INSERT INTO stock_info (ticker, company, brokerage, action, rating_from, rating_to, time)
+			VALUES ($1, $2, $3, '', '', '', $4)`, r.Ticker, r.Company, r.Brokerage, r.Time)
+		require.NoError(t, err)
+	}
+	_, err = migrateUp(db, sqliteDialect, migs, 0)
+	require.NoError(t, err)
+
+	sec, err := repo.Security("OLD")
+	require.NoError(t, err)
+	assert.Equal(t, "New Name", sec.Company)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+)
+
+// seedReport counts what -mode=seed stored.
+type seedReport struct {
+	importReport
+	Securities int
+	Bars       int
+}
+
+// itemsReader yields generated items as import lines, numbered from 1.
+type itemsReader struct {
+	items []StockItem
+	next  int
+}
+
+func (r *itemsReader) Next() (importLine, error) {
+	if r.next == len(r.items) {
+		return importLine{}, io.EOF
+	}
+	r.next++
+	return importLine{Line: r.next, Item: r.items[r.next-1]}, nil
+}
+
+// seedBarStmt stores a generated bar unless the day is already priced.
+const seedBarStmt = `
+	INSERT INTO price_history (ticker, date, open, high, low, close, volume)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (ticker, date) DO NOTHING`
+
+// fillSecurities registers generated securities, filling in only the
+// reference data a security lacks, so seeding a real database never
+// replaces what -mode=load-securities stored.
+func fillSecurities(db *sql.DB, secs []Security) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin securities: %w", err)
+	}
+	defer tx.Rollback()
+	for _, s := range secs {
+		if _, err := tx.Exec(`
+			INSERT INTO securities (ticker, company, exchange, sector, industry, currency, active)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (ticker) DO UPDATE SET
+				exchange = COALESCE(securities.exchange, excluded.exchange),
+				sector   = COALESCE(securities.sector, excluded.sector),
+				industry = COALESCE(securities.industry, excluded.industry)`,
+			s.Ticker, s.Company, nullString(s.Exchange), nullString(s.Sector), nullString(s.Industry), s.Currency, s.Active,
+		); err != nil {
+			return fmt.Errorf("storing security %s: %w", s.Ticker, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// seedSyntheticData stores the ratings of syntheticItems through the import
+// path, so they are parsed, scored and deduplicated like fetched ones, then
+// the securities they rate and daily bars from the first rating to the
+// newest, keeping any already stored. Prices come from syntheticPrices
+// rather than a provider, so seeding needs no network and the same seed
+// always stores the same rows.
+func seedSyntheticData(db *sql.DB, d dialect, seed uint64, tickers, ratings int) (seedReport, error) {
+	var report seedReport
+	if err := checkSyntheticSize(tickers, ratings); err != nil {
+		return report, err
+	}
+	items := syntheticItems(seed, tickers, ratings)
+	newest, err := time.Parse(time.RFC3339Nano, items[0].Time)
+	if err != nil {
+		return report, err
+	}
+	oldest, err := time.Parse(time.RFC3339Nano, items[len(items)-1].Time)
+	if err != nil {
+		return report, err
+	}
+	prices := syntheticPrices{asOf: newest}
+
+	prep, err := db.Prepare(d.InsertStmt)
+	if err != nil {
+		return report, fmt.Errorf("prepare insert: %w", err)
+	}
+	defer prep.Close()
+	f := &pageFetcher{
+		db:       db,
+		prep:     prep,
+		prices:   newPriceCache(prices.CurrentPrice),
+		atRating: newRatingPriceCache(prices),
+		workers:  1,
+	}
+	if f.ratings, err = queryRatingTaxonomy(db); err != nil {
+		return report, fmt.Errorf("rating taxonomy: %w", err)
+	}
+	if f.brokerages, err = loadBrokerageResolver(db); err != nil {
+		return report, fmt.Errorf("brokerage aliases: %w", err)
+	}
+	if report.importReport, err = importItems(f, &itemsReader{items: items}); err != nil {
+		return report, err
+	}
+
+	secs := syntheticSecurities(items)
+	if err := fillSecurities(db, secs); err != nil {
+		return report, err
+	}
+	report.Securities = len(secs)
+
+	// Only the seeded tickers: a backfill would price every rated ticker
+	// with syntheticPrice
+	tx, err := db.Begin()
+	if err != nil {
+		return report, fmt.Errorf("begin bars: %w", err)
+	}
+	defer tx.Rollback()
+	bar, err := tx.Prepare(seedBarStmt)
+	if err != nil {
+		return report, fmt.Errorf("prepare bar insert: %w", err)
+	}
+	defer bar.Close()
+	bars := 0
+	for _, s := range secs {
+		daily, _ := prices.DailyBars(s.Ticker, oldest, newest)
+		for _, b := range daily {
+			res, err := bar.Exec(s.Ticker, b.Date, b.Open, b.High, b.Low, b.Close, b.Volume)
+			if err != nil {
+				return report, fmt.Errorf("insert bar %s %s: %w", s.Ticker, b.Date.Format(time.DateOnly), err)
+			}
+			if n, err := res.RowsAffected(); err == nil {
+				bars += int(n)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return report, fmt.Errorf("commit bars: %w", err)
+	}
+	report.Bars = bars
+	return report, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeedSyntheticData(t *testing.T) {
+	db := openTestSQLite(t)
+	report, err := seedSyntheticData(db, sqliteDialect, 7, 12, 300)
+	require.NoError(t, err)
+	assert.Equal(t, fetchSummary{Inserted: 300}, report.fetchSummary)
+	assert.Empty(t, report.Errors)
+	assert.Equal(t, 12, report.Securities)
+	assert.Positive(t, report.Bars)
+
+	again, err := seedSyntheticData(db, sqliteDialect, 7, 12, 300)
+	require.NoError(t, err)
+	assert.Equal(t, fetchSummary{Unchanged: 300}, again.fetchSummary, "same seed, same rows")
+	assert.Zero(t, again.Bars, "days already priced")
+
+	repo := newSQLiteRepository(db)
+	var pages []Rating
+	for offset := 0; ; offset += 50 {
+		page, err := repo.ListRatings(RatingFilter{Sort: "target_to", Desc: true, Limit: 50, Offset: offset})
+		require.NoError(t, err)
+		if len(page) == 0 {
+			break
+		}
+		pages = append(pages, page...)
+	}
+	require.Len(t, pages, 300, "pages cover every rating once")
+	for i, r := range pages {
+		require.NotNil(t, r.TargetTo)
+		require.NotNil(t, r.CurrentPrice, r.Ticker)
+		require.NotNil(t, r.PriceAtRating, "bars cover %s on %s", r.Ticker, r.Time)
+		require.NotNil(t, r.Security, r.Ticker)
+		assert.NotEmpty(t, r.Security.Sector)
+		assert.NotNil(t, r.BrokerageID, r.Brokerage)
+		if i > 0 {
+			assert.LessOrEqual(t, *r.TargetTo, *pages[i-1].TargetTo, "sorted by target_to")
+		}
+	}
+
+	var bars int
+	require.NoError(t, db.QueryRow("SELECT COUNT(DISTINCT ticker) FROM price_history").Scan(&bars))
+	assert.Equal(t, 12, bars)
+
+	_, err = seedSyntheticData(db, sqliteDialect, 7, -3, 300)
+	assert.ErrorContains(t, err, "invalid -tickers -3")
+}
+
+func TestSeedSyntheticData_KeepsReferenceData(t *testing.T) {
+	db := openTestSQLite(t)
+	ticker := syntheticItems(7, 12, 300)[0].Ticker
+	require.NoError(t, storeSecurities(db, []Security{{Ticker: ticker, Company: "Real Co", Exchange: "LSE", Sector: "Real Estate", Currency: "GBP", Active: true}}))
+	day := truncateDay(syntheticEpoch).AddDate(0, 0, -1)
+	_, err := db.Exec(insertBarStmt, ticker, day, 1, 1, 1, 1, 1)
+	require.NoError(t, err)
+
+	_, err = seedSyntheticData(db, sqliteDialect, 7, 12, 300)
+	require.NoError(t, err)
+
+	secs, err := querySecurities(db)
+	require.NoError(t, err)
+	require.Len(t, secs, 12)
+	for _, s := range secs {
+		if s.Ticker == ticker {
+			assert.Equal(t, Security{Ticker: ticker, Company: "Real Co", Exchange: "LSE", Sector: "Real Estate", Currency: "GBP", Active: true}, s)
+		} else {
+			assert.NotEmpty(t, s.Sector, s.Ticker)
+		}
+	}
+	var close float64
+	require.NoError(t, db.QueryRow("SELECT close FROM price_history WHERE ticker = $1 AND date = $2", ticker, day).Scan(&close))
+	assert.Equal(t, 1.0, close)
+}
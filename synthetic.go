@@ -0,0 +1,275 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+// syntheticEpoch is when generated ratings end, so a seed always yields the
+// same dataset.
+var syntheticEpoch = time.Date(2025, 1, 14, 0, 0, 0, 0, time.UTC)
+
+// syntheticBrokerages mixes spellings of the same firm, as upstream does.
+var syntheticBrokerages = []string{
+	"Goldman Sachs", "The Goldman Sachs Group", "Morgan Stanley", "JPMorgan Chase & Co.",
+	"Barclays", "UBS Group", "Wells Fargo & Company", "Needham & Company LLC",
+	"HC Wainwright", "Piper Sandler", "Raymond James", "Royal Bank of Canada",
+}
+
+var syntheticCompanyWords = []string{
+	"Apex", "Blue", "Cedar", "Delta", "Ember", "Falcon", "Granite", "Harbor",
+	"Iron", "Juniper", "Keystone", "Lumen", "Meridian", "Nova", "Orion", "Pioneer",
+}
+
+var syntheticCompanySuffixes = []string{"Inc.", "Corp.", "Holdings", "Group", "Therapeutics", "Energy", "Systems"}
+
+var syntheticSectors = []string{
+	"Technology", "Health Care", "Financials", "Industrials", "Energy",
+	"Consumer Discretionary", "Utilities", "Materials",
+}
+
+// syntheticLevels are the spellings of the default taxonomy grouped by
+// ratingLevel.Score, worst first, so upgrades and downgrades move along the
+// scores the ratingTaxonomy gives them at ingest.
+var syntheticLevels = func() [][]string {
+	byScore := map[int][]string{}
+	for alias, level := range defaultRatingAliases {
+		byScore[level.Score] = append(byScore[level.Score], titleWords(alias))
+	}
+	scores := make([]int, 0, len(byScore))
+	for score, spellings := range byScore {
+		slices.Sort(spellings)
+		scores = append(scores, score)
+	}
+	slices.Sort(scores)
+	levels := make([][]string, len(scores))
+	for i, score := range scores {
+		levels[i] = byScore[score]
+	}
+	return levels
+}()
+
+// titleWords capitalizes each word of s: "strong buy" is "Strong Buy".
+func titleWords(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// Bounds of a generated dataset. Three- and four-letter symbols run out
+// at about 474k, and drawing them gets slow well before that.
+const (
+	maxSyntheticTickers = 100_000
+	maxSyntheticRatings = 5_000_000
+)
+
+// checkSyntheticSize validates the -tickers and -ratings of a generated
+// dataset.
+func checkSyntheticSize(tickers, ratings int) error {
+	if tickers < 1 || tickers > maxSyntheticTickers {
+		return fmt.Errorf("invalid -tickers %d; use 1 to %d", tickers, maxSyntheticTickers)
+	}
+	if ratings < 1 || ratings > maxSyntheticRatings {
+		return fmt.Errorf("invalid -ratings %d; use 1 to %d", ratings, maxSyntheticRatings)
+	}
+	return nil
+}
+
+// syntheticCoverage is a brokerage's standing view of a ticker.
+type syntheticCoverage struct {
+	level  int
+	target float64
+	price  float64 // when target was set
+}
+
+// syntheticItems generates ratings items in the upstream format, spread over
+// tickers symbols, newest first, ending at syntheticEpoch. The same seed and
+// sizes always give the same items; see checkSyntheticSize for the sizes
+// it can generate.
+//
+// A brokerage covers a ticker from an initiation until it drops coverage.
+// Its ratings chain, rating_from being its previous rating_to in the firm's
+// own vocabulary, and its targets drift with syntheticPrice before the
+// action raises or lowers them, so upside scores stay plausible.
+func syntheticItems(seed uint64, tickers, ratings int) []StockItem {
+	if tickers <= 0 || ratings <= 0 {
+		return nil
+	}
+	rng := rand.New(rand.NewPCG(seed, seed^0x5eed))
+	symbols := syntheticTickers(rng, tickers)
+	companies := make([]string, len(symbols))
+	for i := range symbols {
+		companies[i] = syntheticCompanyWords[rng.IntN(len(syntheticCompanyWords))] + " " +
+			syntheticCompanySuffixes[rng.IntN(len(syntheticCompanySuffixes))]
+	}
+
+	// A few minutes to a few hours between consecutive ratings
+	times := make([]time.Time, ratings)
+	at := syntheticEpoch
+	for i := range times {
+		at = at.Add(-time.Duration(1+rng.IntN(240)) * time.Minute)
+		times[i] = at
+	}
+
+	top := len(syntheticLevels) - 1
+	covered := map[[2]int]*syntheticCoverage{}
+	items := make([]StockItem, ratings)
+	// Oldest first, so each rating follows the brokerage's previous one
+	for i := ratings - 1; i >= 0; i-- {
+		t, b := rng.IntN(len(symbols)), rng.IntN(len(syntheticBrokerages))
+		spelling := func(level int) string {
+			return syntheticLevels[level][b%len(syntheticLevels[level])]
+		}
+		price := syntheticPrice(symbols[t], times[i])
+		item := StockItem{
+			Ticker:    symbols[t],
+			Company:   companies[t],
+			Brokerage: syntheticBrokerages[b],
+			Time:      times[i].Format(time.RFC3339Nano),
+		}
+
+		key := [2]int{t, b}
+		c := covered[key]
+		if c == nil {
+			c = &syntheticCoverage{level: rng.IntN(top + 1), target: price * (0.9 + 0.5*rng.Float64()), price: price}
+			covered[key] = c
+			item.Action = "initiated by"
+			item.RatingTo = spelling(c.level)
+			item.TargetTo = fmt.Sprintf("$%.2f", c.target)
+			items[i] = item
+			continue
+		}
+
+		from, prev := c.level, c.target
+		drifted := prev * price / c.price
+		switch p := rng.Float64(); {
+		case p < 0.05:
+			item.Action = "coverage dropped by"
+			delete(covered, key)
+		case p < 0.2 && from < top:
+			item.Action = "upgraded by"
+			c.level = min(from+1+rng.IntN(2), top)
+			c.target = drifted * (1.05 + 0.15*rng.Float64())
+		case p < 0.35 && from > 0:
+			item.Action = "downgraded by"
+			c.level = max(from-1-rng.IntN(2), 0)
+			c.target = drifted * (0.8 + 0.15*rng.Float64())
+		case p < 0.6:
+			item.Action = "target raised by"
+			c.target = max(drifted, prev) * (1.02 + 0.13*rng.Float64())
+		case p < 0.8:
+			item.Action = "target lowered by"
+			c.target = min(drifted, prev) * (0.85 + 0.13*rng.Float64())
+		default:
+			item.Action = "reiterated by"
+		}
+		c.price = price
+		item.RatingFrom, item.RatingTo = spelling(from), spelling(c.level)
+		item.TargetFrom, item.TargetTo = fmt.Sprintf("$%.2f", prev), fmt.Sprintf("$%.2f", c.target)
+		items[i] = item
+	}
+	return items
+}
+
+// syntheticTickers draws n distinct symbols of three or four letters.
+func syntheticTickers(rng *rand.Rand, n int) []string {
+	seen := map[string]bool{}
+	out := make([]string, 0, n)
+	for len(out) < n {
+		var b strings.Builder
+		for range 3 + rng.IntN(2) {
+			b.WriteByte(byte('A' + rng.IntN(26)))
+		}
+		if s := b.String(); !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// syntheticHash derives the fixed properties of a ticker: its base price,
+// sector, exchange and volume.
+func syntheticHash(ticker string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(ticker))
+	return h.Sum64()
+}
+
+// syntheticPrice is a deterministic close for ticker on at's day: a
+// per-ticker base between $5 and $500 with a slow drift, so the fake price
+// endpoints agree with the generated targets.
+func syntheticPrice(ticker string, at time.Time) float64 {
+	sum := syntheticHash(ticker)
+	base := 5 + float64(sum%49500)/100
+	day := float64(truncateDay(at).Unix() / 86400)
+	drift := 1 + 0.1*math.Sin(day/30+float64(sum%360))
+	return math.Round(base*drift*100) / 100
+}
+
+// syntheticSecurities lists the securities rated in items, by ticker.
+func syntheticSecurities(items []StockItem) []Security {
+	seen := map[string]bool{}
+	var out []Security
+	for _, item := range items {
+		if seen[item.Ticker] {
+			continue
+		}
+		seen[item.Ticker] = true
+		sum := syntheticHash(item.Ticker)
+		exchange := "NASDAQ"
+		if sum%3 == 0 {
+			exchange = "NYSE"
+		}
+		out = append(out, Security{
+			Ticker:   item.Ticker,
+			Company:  item.Company,
+			Exchange: exchange,
+			Sector:   syntheticSectors[sum%uint64(len(syntheticSectors))],
+			Currency: baseCurrency,
+			Active:   true,
+		})
+	}
+	slices.SortFunc(out, func(a, b Security) int { return cmp.Compare(a.Ticker, b.Ticker) })
+	return out
+}
+
+// syntheticPrices prices generated tickers with syntheticPrice: weekday
+// bars, and the close of asOf as the current price.
+type syntheticPrices struct {
+	asOf time.Time
+}
+
+func (p syntheticPrices) Name() string { return "synthetic" }
+
+func (p syntheticPrices) CurrentPrice(ticker string) (float64, error) {
+	return syntheticPrice(ticker, p.asOf), nil
+}
+
+func (p syntheticPrices) DailyBars(ticker string, from, to time.Time) ([]PriceBar, error) {
+	var bars []PriceBar
+	for day := truncateDay(from); !day.After(to); day = day.AddDate(0, 0, 1) {
+		if wd := day.Weekday(); wd == time.Saturday || wd == time.Sunday {
+			continue
+		}
+		c := syntheticPrice(ticker, day)
+		bars = append(bars, PriceBar{
+			Date:   day,
+			Open:   syntheticPrice(ticker, day.AddDate(0, 0, -1)),
+			High:   math.Round(c*101) / 100,
+			Low:    math.Round(c*99) / 100,
+			Close:  c,
+			Volume: 100_000 + int64(syntheticHash(ticker)%5_000_000),
+		})
+	}
+	return bars, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyntheticItems(t *testing.T) {
+	items := syntheticItems(42, 10, 200)
+	require.Len(t, items, 200)
+	assert.Equal(t, items, syntheticItems(42, 10, 200), "same seed, same items")
+	assert.NotEqual(t, items, syntheticItems(43, 10, 200))
+
+	taxonomy := newRatingTaxonomy(defaultRatingAliases)
+	tickers := map[string]bool{}
+	prev := syntheticEpoch
+	for _, item := range items {
+		tickers[item.Ticker] = true
+		lookups := fixedPrice(1)
+		lookups.Ratings = taxonomy
+		r, err := parseStockItem(&item, lookups)
+		require.NoError(t, err, item)
+		assert.True(t, r.Time.Before(prev), "newest first")
+		prev = r.Time
+		assert.NotEmpty(t, r.ActionType, item.Action)
+		assert.NotEmpty(t, r.RatingToCanonical, "ratings are in the default taxonomy")
+		if r.ActionType == actionUpgrade {
+			from, fromOK := taxonomy.lookup(item.RatingFrom)
+			to, toOK := taxonomy.lookup(item.RatingTo)
+			require.True(t, fromOK && toOK, item)
+			assert.Greater(t, to.Score, from.Score, item)
+		}
+	}
+	assert.LessOrEqual(t, len(tickers), 10)
+
+	// Each brokerage's ratings of a ticker chain, oldest to newest
+	last := map[[2]string]StockItem{}
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		key := [2]string{item.Ticker, item.Brokerage}
+		prev, covered := last[key]
+		if !covered {
+			assert.Equal(t, "initiated by", item.Action, item)
+		} else {
+			assert.Equal(t, prev.RatingTo, item.RatingFrom, item)
+			assert.Equal(t, prev.TargetTo, item.TargetFrom, item)
+		}
+		last[key] = item
+		if item.Action == "coverage dropped by" {
+			delete(last, key)
+		}
+	}
+}
+
+func TestSyntheticPrice(t *testing.T) {
+	day := time.Date(2025, 1, 13, 15, 0, 0, 0, time.UTC)
+	p := syntheticPrice("AAA", day)
+	assert.Equal(t, p, syntheticPrice("AAA", truncateDay(day)), "one price per day")
+	assert.NotEqual(t, p, syntheticPrice("BBB", day))
+	assert.GreaterOrEqual(t, p, 4.5)
+	assert.LessOrEqual(t, p, 550.0)
+}
+
+func TestCheckSyntheticSize(t *testing.T) {
+	assert.NoError(t, checkSyntheticSize(1, 1))
+	assert.NoError(t, checkSyntheticSize(maxSyntheticTickers, maxSyntheticRatings))
+	for _, size := range [][2]int{{-1, 10}, {0, 10}, {maxSyntheticTickers + 1, 10}, {10, -5}, {10, 0}, {10, maxSyntheticRatings + 1}} {
+		assert.Error(t, checkSyntheticSize(size[0], size[1]), size)
+	}
+	assert.Nil(t, syntheticItems(1, -1, 10), "no panic on a negative count")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"cmp"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// upstreamConfig controls timeouts, retries and rate limiting for calls to
+// the ratings API.
+type upstreamConfig struct {
+	Timeout     time.Duration // per-request timeout, including reading the body
+	MaxRetries  int           // retries after the first attempt on 429/5xx or transport errors
+	BaseBackoff time.Duration // first retry delay, doubled on every attempt
+	MaxBackoff  time.Duration // cap for the computed backoff
+	// Cap for a delay asked for with Retry-After; zero caps it at MaxBackoff
+	MaxRetryAfter time.Duration
+	RatePerSec    float64 // token-bucket refill rate; <= 0 disables limiting
+	Burst         int     // token-bucket capacity
+}
+
+var defaultUpstreamConfig = upstreamConfig{
+	Timeout:       30 * time.Second,
+	MaxRetries:    5,
+	BaseBackoff:   500 * time.Millisecond,
+	MaxBackoff:    30 * time.Second,
+	MaxRetryAfter: 5 * time.Minute,
+	RatePerSec:    5,
+	Burst:         1,
+}
+
+// upstreamClient performs HTTP requests with retry, backoff and rate limiting.
+type upstreamClient struct {
+	cfg     upstreamConfig
+	http    *http.Client
+	limiter *tokenBucket
+	sleep   func(time.Duration)
+	now     func() time.Time
+}
+
+func newUpstreamClient(cfg upstreamConfig) *upstreamClient {
+	return &upstreamClient{
+		cfg:     cfg,
+		http:    &http.Client{Timeout: cfg.Timeout, Transport: httpTransport},
+		limiter: newTokenBucket(cfg.RatePerSec, cfg.Burst),
+		sleep:   time.Sleep,
+		now:     time.Now,
+	}
+}
+
+// Do sends req, retrying 429/5xx responses and transport errors with
+// exponential backoff and jitter. A Retry-After header overrides the computed
+// delay, up to MaxRetryAfter. After the last retry the final response or
+// error is returned as is. req must be replayable (no body or a GetBody).
+func (c *upstreamClient) Do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		c.sleep(c.limiter.reserve())
+
+		resp, err := c.http.Do(req)
+		retryable := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if !retryable || attempt >= c.cfg.MaxRetries {
+			return resp, err
+		}
+
+		wait := c.backoff(attempt)
+		if err != nil {
+			log.Printf("upstream request error: %v; retrying in %s", err, wait)
+		} else {
+			if ra, ok := parseRetryAfter(resp.Header.Get("Retry-After"), c.now()); ok {
+				wait = min(ra, cmp.Or(c.cfg.MaxRetryAfter, c.cfg.MaxBackoff))
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			log.Printf("upstream returned %s; retrying in %s", resp.Status, wait)
+		}
+		c.sleep(wait)
+	}
+}
+
+// backoff returns the delay before retry number attempt+1: the capped
+// exponential delay with "equal jitter" (half fixed, half random).
+func (c *upstreamClient) backoff(attempt int) time.Duration {
+	d := c.cfg.BaseBackoff << attempt
+	if d <= 0 || d > c.cfg.MaxBackoff {
+		d = c.cfg.MaxBackoff
+	}
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + rand.N(half)
+}
+
+// parseRetryAfter reads a Retry-After value in either delay-seconds or
+// HTTP-date form.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// tokenBucket is a minimal token-bucket rate limiter. A nil bucket never
+// limits.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now(), now: time.Now}
+}
+
+// reserve takes a token and returns how long the caller must wait before
+// using it. Tokens may go negative, which queues later callers behind it.
+func (b *tokenBucket) reserve() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
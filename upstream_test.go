@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestUpstreamClient builds a client that records sleeps instead of waiting.
+func newTestUpstreamClient(cfg upstreamConfig) (*upstreamClient, *[]time.Duration) {
+	var slept []time.Duration
+	c := newUpstreamClient(cfg)
+	c.sleep = func(d time.Duration) {
+		if d > 0 {
+			slept = append(slept, d)
+		}
+	}
+	return c, &slept
+}
+
+func TestUpstreamClient_RetriesWithRetryAfter(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			fmt.Fprint(w, `{"items":[]}`)
+		}
+	}))
+	defer ts.Close()
+
+	c, slept := newTestUpstreamClient(upstreamConfig{MaxRetries: 3, BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, MaxRetryAfter: time.Minute})
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := c.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 3, calls)
+	assert.Len(t, *slept, 2)
+	assert.Equal(t, 3*time.Second, (*slept)[0])
+	// Second retry uses exponential backoff with equal jitter: [100ms, 200ms)
+	assert.GreaterOrEqual(t, (*slept)[1], 100*time.Millisecond)
+	assert.Less(t, (*slept)[1], 200*time.Millisecond)
+}
+
+func TestUpstreamClient_CapsRetryAfter(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "86400")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, `{"items":[]}`)
+	}))
+	defer ts.Close()
+
+	for cfg, want := range map[upstreamConfig]time.Duration{
+		{MaxRetries: 1, MaxBackoff: time.Second, MaxRetryAfter: time.Minute}: time.Minute,
+		{MaxRetries: 1, MaxBackoff: time.Second}:                             time.Second,
+	} {
+		calls = 0
+		c, slept := newTestUpstreamClient(cfg)
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		resp, err := c.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, []time.Duration{want}, *slept, "a day-long Retry-After is capped")
+	}
+}
+
+func TestUpstreamClient_GivesUpAfterMaxRetries(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	oldEndpoint := APIEndpoint
+	APIEndpoint = ts.URL
+	defer func() { APIEndpoint = oldEndpoint }()
+
+	c, _ := newTestUpstreamClient(upstreamConfig{MaxRetries: 2, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	_, err := fetchPage(c, "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "503")
+	assert.Equal(t, 3, calls)
+}
+
+func TestUpstreamClient_DoesNotRetryClientErrors(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	c, _ := newTestUpstreamClient(upstreamConfig{MaxRetries: 5})
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	resp, err := c.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, 1, calls)
+}
+
+func TestUpstreamClient_Timeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	c, slept := newTestUpstreamClient(upstreamConfig{Timeout: 10 * time.Millisecond, MaxRetries: 1, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	_, err := c.Do(req)
+	assert.Error(t, err)
+	assert.Len(t, *slept, 1)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	d, ok := parseRetryAfter("7", now)
+	assert.True(t, ok)
+	assert.Equal(t, 7*time.Second, d)
+
+	d, ok = parseRetryAfter(now.Add(90*time.Second).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, 90*time.Second, d)
+
+	_, ok = parseRetryAfter("soon", now)
+	assert.False(t, ok)
+}
+
+func TestTokenBucket_Reserve(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newTokenBucket(2, 2)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	// Burst is available immediately, then callers queue at 1/rate each
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, time.Duration(0), b.reserve())
+	assert.Equal(t, 500*time.Millisecond, b.reserve())
+	assert.Equal(t, time.Second, b.reserve())
+
+	// Refill pays back the debt
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, time.Duration(0), b.reserve())
+
+	assert.Nil(t, newTokenBucket(0, 1))
+	assert.Equal(t, time.Duration(0), (*tokenBucket)(nil).reserve())
+}